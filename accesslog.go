@@ -0,0 +1,264 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/record"
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// accessLogEntry is a request that was forwarded by a proxy, as found in its
+// access log.
+type accessLogEntry struct {
+	Proxy      string //named after the log file
+	Downstream string //the client
+	Upstream   string //where the request was forwarded to (empty if unknown)
+	RequestID  string
+	Method     string
+	Path       string
+	Status     string
+	Start      time.Time
+	Duration   time.Duration
+}
+
+var (
+	//Envoy's default format (further fields at the end are ignored):
+	//[%START_TIME%] "%REQ(:METHOD)% %REQ(X-ENVOY-ORIGINAL-PATH?:PATH)% %PROTOCOL%" %RESPONSE_CODE% %RESPONSE_FLAGS%
+	//%BYTES_RECEIVED% %BYTES_SENT% %DURATION% %RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)% "%REQ(X-FORWARDED-FOR)%"
+	//"%REQ(USER-AGENT)%" "%REQ(X-REQUEST-ID)%" "%REQ(:AUTHORITY)%" "%UPSTREAM_HOST%"
+	envoyLogRx = regexp.MustCompile(`^\[([^\]]+)\] "(\S+) (\S+)[^"]*" (\S+) \S+ \S+ \S+ (\S+) \S+ "([^"]*)" "[^"]*" "([^"]*)" "([^"]*)" "([^"]*)"`)
+	//nginx's "combined" format, optionally followed by key=value fields (e.g.
+	//upstream_addr=$upstream_addr request_id=$request_id request_time=$request_time)
+	nginxLogRx = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)[^"]*" (\d+) \S+ "[^"]*" "[^"]*"(.*)$`)
+	nginxKVRx  = regexp.MustCompile(`(\w+)=("[^"]*"|\S*)`)
+)
+
+// parseAccessLogLine recognizes Envoy's default text format, Envoy's JSON
+// format, and nginx's "combined" format. It returns false for lines in other
+// formats.
+func parseAccessLogLine(line, proxy string) (accessLogEntry, bool) {
+	e := accessLogEntry{Proxy: proxy}
+	switch {
+	case strings.HasPrefix(line, "{"):
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(line), &fields) != nil {
+			return e, false
+		}
+		field := func(keys ...string) string {
+			for _, key := range keys {
+				if value, exists := fields[key]; exists && value != nil && value != "-" {
+					return fmt.Sprint(value)
+				}
+			}
+			return ""
+		}
+		e.Start, _ = time.Parse(time.RFC3339Nano, field("start_time"))
+		e.Method, e.Path, e.Status = field("method"), field("path"), field("response_code")
+		e.Duration = parseMilliseconds(field("duration"))
+		e.Downstream = firstForwardedFor(field("x_forwarded_for", "downstream_remote_address"))
+		e.RequestID = field("request_id", "x_request_id")
+		e.Upstream = field("upstream_cluster", "authority", "upstream_host")
+	case strings.HasPrefix(line, "["):
+		m := envoyLogRx.FindStringSubmatch(line)
+		if m == nil {
+			return e, false
+		}
+		e.Start, _ = time.Parse(time.RFC3339Nano, m[1])
+		e.Method, e.Path, e.Status = m[2], m[3], m[4]
+		e.Duration = parseMilliseconds(m[5])
+		e.Downstream = firstForwardedFor(m[6])
+		e.RequestID = m[7]
+		e.Upstream = m[8]
+		if e.Upstream == "" || e.Upstream == "-" {
+			e.Upstream = m[9]
+		}
+	default:
+		m := nginxLogRx.FindStringSubmatch(line)
+		if m == nil {
+			return e, false
+		}
+		e.Downstream = m[1]
+		e.Start, _ = time.Parse("02/Jan/2006:15:04:05 -0700", m[2])
+		e.Method, e.Path, e.Status = m[3], m[4], m[5]
+		for _, kv := range nginxKVRx.FindAllStringSubmatch(m[6], -1) {
+			value := strings.Trim(kv[2], `"`)
+			if value == "-" {
+				continue
+			}
+			switch kv[1] {
+			case "upstream_addr", "upstream":
+				//retries are listed separated by commas; the last one answered
+				addrs := strings.Split(value, ",")
+				e.Upstream = strings.TrimSpace(addrs[len(addrs)-1])
+			case "request_id":
+				e.RequestID = value
+			case "request_time":
+				seconds, _ := strconv.ParseFloat(value, 64)
+				e.Duration = time.Duration(seconds * float64(time.Second))
+			case "http_x_forwarded_for":
+				e.Downstream = firstForwardedFor(value)
+			}
+		}
+	}
+	if e.Method == "" {
+		return e, false
+	}
+	if e.Downstream == "" {
+		e.Downstream = "client"
+	} else if host, _, err := splitHostPort(e.Downstream); err == nil {
+		e.Downstream = host
+	}
+	if e.Upstream == "-" {
+		e.Upstream = ""
+	}
+	e.Upstream = strings.TrimPrefix(strings.TrimPrefix(e.Upstream, "tcp://"), "http://")
+	return e, true
+}
+
+func parseMilliseconds(value string) time.Duration {
+	ms, _ := strconv.ParseFloat(value, 64)
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// firstForwardedFor returns the original client from an X-Forwarded-For list.
+func firstForwardedFor(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return strings.TrimSpace(strings.Split(value, ",")[0])
+}
+
+// splitHostPort is like net.SplitHostPort, but fails for addresses without port.
+func splitHostPort(address string) (string, string, error) {
+	idx := strings.LastIndex(address, ":")
+	if idx < 0 || strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+		return "", "", fmt.Errorf("no port in %s", address)
+	}
+	return strings.Trim(address[:idx], "[]"), address[idx+1:], nil
+}
+
+// importAccessLogs renders the requests found in the access logs of one or
+// more proxies. Each log file belongs to one proxy, which is named after the
+// file (e.g. "edge" for "edge.access.log"). Entries with the same request ID
+// are combined into a call chain, where an entry is forwarded by the proxy of
+// the entry that encloses it in time (e.g. a sidecar log named after the
+// upstream of the edge proxy's entry).
+func importAccessLogs(paths []string, opts *sequence.Options, outputPath string) {
+	var entries []accessLogEntry
+	for _, path := range paths {
+		proxy := strings.SplitN(filepath.Base(path), ".", 2)[0]
+		file, err := os.Open(path)
+		failIfErr(err)
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		lineNo := 0
+		for scanner.Scan() {
+			lineNo++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			e, ok := parseAccessLogLine(line, proxy)
+			if !ok {
+				slog.Warn("skipping line in unknown format", "mode", "accesslog", "path", path, "line", lineNo)
+				continue
+			}
+			entries = append(entries, e)
+		}
+		failIfErr(scanner.Err())
+		failIfErr(file.Close())
+	}
+	if len(entries) == 0 {
+		fail("accesslog: no requests found")
+	}
+
+	//group entries by request (entries without ID are requests by themselves)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Start.Before(entries[j].Start) })
+	var requests [][]accessLogEntry
+	requestIndex := make(map[string]int)
+	for _, e := range entries {
+		idx, exists := requestIndex[e.RequestID]
+		if !exists || e.RequestID == "" {
+			idx = len(requests)
+			requests = append(requests, nil)
+			if e.RequestID != "" {
+				requestIndex[e.RequestID] = idx
+			}
+		}
+		requests[idx] = append(requests[idx], e)
+	}
+
+	rec := record.New()
+	for _, request := range requests {
+		rec.RecordTrace(accessLogSpans(request), request[0].Downstream)
+	}
+	var buf bytes.Buffer
+	_, err := rec.WriteTo(&buf)
+	failIfErr(err)
+	render(parse(&buf), opts, outputPath)
+}
+
+// accessLogSpans converts the entries of one request (sorted by start time)
+// into spans: one for each proxy, and one for each upstream below it.
+func accessLogSpans(request []accessLogEntry) []record.Span {
+	var spans []record.Span
+	for idx, e := range request {
+		proxySpan := record.Span{
+			ID:      fmt.Sprintf("proxy-%d", idx),
+			Service: e.Proxy,
+			Name:    e.Method + " " + e.Path,
+			Start:   e.Start,
+			End:     e.Start.Add(e.Duration),
+			Status:  e.Status,
+		}
+		//the parent is the upstream of the innermost earlier entry that encloses this one
+		for parent := idx - 1; parent >= 0; parent-- {
+			p := request[parent]
+			if !e.Start.Before(p.Start) && !e.Start.After(p.Start.Add(p.Duration)) {
+				proxySpan.ParentID = fmt.Sprintf("upstream-%d", parent)
+				if p.Upstream == "" {
+					proxySpan.ParentID = fmt.Sprintf("proxy-%d", parent)
+				}
+				break
+			}
+		}
+		spans = append(spans, proxySpan)
+		if e.Upstream != "" {
+			upstreamSpan := proxySpan
+			upstreamSpan.ID = fmt.Sprintf("upstream-%d", idx)
+			upstreamSpan.ParentID = proxySpan.ID
+			upstreamSpan.Service = e.Upstream
+			spans = append(spans, upstreamSpan)
+		}
+	}
+	return spans
+}
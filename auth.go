@@ -0,0 +1,142 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// serverAuth restricts who can use the "serve" subcommand. Requests must come
+// from one of the Networks (if any), and must carry one of the Tokens (if
+// any). The client address is taken from the connection, so behind a reverse
+// proxy, -allow-networks refers to the proxy (X-Forwarded-For is not trusted).
+type serverAuth struct {
+	Tokens   [][]byte
+	Networks []*net.IPNet
+}
+
+// loadServerAuth reads the -auth-tokens-file and -allow-networks flags.
+func loadServerAuth() *serverAuth {
+	auth := &serverAuth{}
+	if *authTokensFileFlag != "" {
+		file, err := os.Open(*authTokensFileFlag)
+		failIfErr(err)
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				auth.Tokens = append(auth.Tokens, []byte(line))
+			}
+		}
+		failIfErr(scanner.Err())
+		if len(auth.Tokens) == 0 {
+			fail("-auth-tokens-file: no tokens found in %s", *authTokensFileFlag)
+		}
+	}
+
+	if *allowNetworksFlag != "" {
+		for _, field := range strings.Split(*allowNetworksFlag, ",") {
+			field = strings.TrimSpace(field)
+			//single addresses are accepted as networks with only that address
+			if ip := net.ParseIP(field); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				auth.Networks = append(auth.Networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, network, err := net.ParseCIDR(field)
+			if err != nil {
+				fail("-allow-networks: invalid network: %q (expected an address or CIDR like 10.0.0.0/8)", field)
+			}
+			auth.Networks = append(auth.Networks, network)
+		}
+	}
+	return auth
+}
+
+// check returns an HTTP status code and message if the request is not
+// allowed, or 0 if it is.
+func (auth *serverAuth) check(r *http.Request) (int, string) {
+	if code, msg := auth.checkNetwork(r); code != 0 {
+		return code, msg
+	}
+	if len(auth.Tokens) > 0 && !auth.isValidToken(requestToken(r)) {
+		return http.StatusUnauthorized, "missing or invalid API token"
+	}
+	return 0, ""
+}
+
+// checkNetwork is like check, but does not require a token. It is used for
+// endpoints that web browsers open directly, like the playground.
+func (auth *serverAuth) checkNetwork(r *http.Request) (int, string) {
+	if len(auth.Networks) > 0 && !auth.isAllowedAddress(r.RemoteAddr) {
+		return http.StatusForbidden, "forbidden"
+	}
+	return 0, ""
+}
+
+func (auth *serverAuth) isAllowedAddress(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range auth.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (auth *serverAuth) isValidToken(token []byte) bool {
+	if len(token) == 0 {
+		return false
+	}
+	//compare with all tokens, so that the response time does not tell which one matched
+	valid := 0
+	for _, candidate := range auth.Tokens {
+		valid |= subtle.ConstantTimeCompare(token, candidate)
+	}
+	return valid == 1
+}
+
+// requestToken returns the API token from the "Authorization: Bearer <token>"
+// or the "X-API-Key: <token>" header.
+func requestToken(r *http.Request) []byte {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			return []byte(strings.TrimSpace(token))
+		}
+		return nil
+	}
+	return []byte(r.Header.Get("X-API-Key"))
+}
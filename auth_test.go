@@ -0,0 +1,105 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerAuth(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	err := os.WriteFile(tokensFile, []byte("# comment\nsecret1\n\n  secret2  \n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withFlags := func(tokensFile, networks string) *serverAuth {
+		oldTokensFile, oldNetworks := *authTokensFileFlag, *allowNetworksFlag
+		defer func() { *authTokensFileFlag, *allowNetworksFlag = oldTokensFile, oldNetworks }()
+		*authTokensFileFlag, *allowNetworksFlag = tokensFile, networks
+		return loadServerAuth()
+	}
+	open := withFlags("", "")
+	tokens := withFlags(tokensFile, "")
+	networks := withFlags("", "10.0.0.0/8, 192.0.2.1, 2001:db8::/32")
+	both := withFlags(tokensFile, "10.0.0.0/8")
+
+	testCases := []struct {
+		Name       string
+		Auth       *serverAuth
+		RemoteAddr string
+		Header     http.Header
+		Status     int
+	}{
+		{"no restrictions", open, "203.0.113.1:1234", nil, 0},
+		{"missing token", tokens, "203.0.113.1:1234", nil, http.StatusUnauthorized},
+		{"bearer token", tokens, "203.0.113.1:1234", http.Header{"Authorization": {"Bearer secret1"}}, 0},
+		{"bearer scheme is case-insensitive", tokens, "203.0.113.1:1234", http.Header{"Authorization": {"bearer secret2"}}, 0},
+		{"API key header", tokens, "203.0.113.1:1234", http.Header{"X-Api-Key": {"secret2"}}, 0},
+		{"wrong token", tokens, "203.0.113.1:1234", http.Header{"Authorization": {"Bearer secret3"}}, http.StatusUnauthorized},
+		{"token prefix", tokens, "203.0.113.1:1234", http.Header{"Authorization": {"Bearer secret"}}, http.StatusUnauthorized},
+		{"comment is not a token", tokens, "203.0.113.1:1234", http.Header{"Authorization": {"Bearer # comment"}}, http.StatusUnauthorized},
+		{"other scheme", tokens, "203.0.113.1:1234", http.Header{"Authorization": {"Basic secret1"}}, http.StatusUnauthorized},
+		{"address in network", networks, "10.1.2.3:1234", nil, 0},
+		{"single address", networks, "192.0.2.1:1234", nil, 0},
+		{"neighbor of single address", networks, "192.0.2.2:1234", nil, http.StatusForbidden},
+		{"IPv6 address in network", networks, "[2001:db8::1]:1234", nil, 0},
+		{"address outside networks", networks, "203.0.113.1:1234", nil, http.StatusForbidden},
+		{"network and token", both, "10.1.2.3:1234", http.Header{"Authorization": {"Bearer secret1"}}, 0},
+		{"network without token", both, "10.1.2.3:1234", nil, http.StatusUnauthorized},
+		{"token outside network", both, "203.0.113.1:1234", http.Header{"Authorization": {"Bearer secret1"}}, http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/render", nil)
+		r.RemoteAddr = tc.RemoteAddr
+		for key, values := range tc.Header {
+			r.Header[key] = values
+		}
+		if status, _ := tc.Auth.check(r); status != tc.Status {
+			t.Errorf("%s: expected status %d, got %d", tc.Name, tc.Status, status)
+		}
+	}
+}
+
+func TestLoadServerAuthErrors(t *testing.T) {
+	emptyFile := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(emptyFile, []byte("# no tokens yet\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		TokensFile string
+		Networks   string
+		Failure    string
+	}{
+		{emptyFile, "", "-auth-tokens-file: no tokens found in " + emptyFile},
+		{"", "10.0.0.0/33", `-allow-networks: invalid network: "10.0.0.0/33" (expected an address or CIDR like 10.0.0.0/8)`},
+		{"", "localhost", `-allow-networks: invalid network: "localhost" (expected an address or CIDR like 10.0.0.0/8)`},
+	}
+	oldTokensFile, oldNetworks := *authTokensFileFlag, *allowNetworksFlag
+	defer func() { *authTokensFileFlag, *allowNetworksFlag = oldTokensFile, oldNetworks }()
+	for _, tc := range testCases {
+		*authTokensFileFlag, *allowNetworksFlag = tc.TokensFile, tc.Networks
+		if msg := catchFailure(func() { loadServerAuth() }); msg != tc.Failure {
+			t.Errorf("expected failure %q, got %q", tc.Failure, msg)
+		}
+	}
+}
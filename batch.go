@@ -0,0 +1,116 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// BatchExtension is the file extension of diagram files that are found when
+// a directory is given to the "batch" subcommand.
+const BatchExtension = ".seq"
+
+// renderBatch renders each given file (and each file with BatchExtension
+// below each given directory) into a file next to it, with the extension of
+// the output format (see renderFiles).
+func renderBatch(args []string, opts *sequence.Options) {
+	ext, exists := sequence.OutputExtensions[opts.Format]
+	if !exists {
+		fail("unknown output format: %s", opts.Format)
+	}
+
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		failIfErr(err)
+		if info.IsDir() {
+			paths = append(paths, findDiagramFiles(arg)...)
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+
+	renderFiles(paths, opts, func(path string) string {
+		return strings.TrimSuffix(path, filepath.Ext(path)) + ext
+	})
+}
+
+// findDiagramFiles returns all files with BatchExtension below the given directory.
+func findDiagramFiles(dir string) []string {
+	var paths []string
+	failIfErr(filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && filepath.Ext(path) == BatchExtension {
+			paths = append(paths, path)
+		}
+		return err
+	}))
+	return paths
+}
+
+// renderFiles renders each of the given files into the file given by
+// outputPathFor (see processFiles).
+func renderFiles(paths []string, opts *sequence.Options, outputPathFor func(path string) string) {
+	processFiles(paths, func(idx int, path string) {
+		render(parseFile(path), opts, outputPathFor(path))
+	})
+}
+
+// processFiles calls process for each of the given files, with the index of
+// the file in the list. Up to -j files are processed concurrently. Errors are
+// collected and reported together after all files have been processed.
+func processFiles(paths []string, process func(idx int, path string)) {
+	if *jobsFlag < 1 {
+		fail("-j must be at least 1")
+	}
+	errs := make([]string, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for idx := 0; idx < *jobsFlag; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				errs[job] = catchFailure(func() {
+					process(job, paths[job])
+				})
+			}
+		}()
+	}
+	for idx := range paths {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	var failed []string
+	for idx, msg := range errs {
+		if msg != "" {
+			failed = append(failed, fmt.Sprintf("%s: %s", paths[idx], msg))
+		}
+	}
+	if len(failed) > 0 {
+		fail("%d of %d files could not be rendered:\n%s", len(failed), len(paths), strings.Join(failed, "\n"))
+	}
+}
@@ -0,0 +1,122 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// galleryEntry describes one diagram file on the index page of buildSite.
+type galleryEntry struct {
+	Source string //path of the diagram file, relative to the source directory
+	Title  string //of the first page with a title, or the file name otherwise
+	Pages  []galleryPage
+}
+
+type galleryPage struct {
+	Number int
+	Path   string //of the SVG file, relative to the output directory
+}
+
+// buildSite renders each diagram file (with BatchExtension) below srcDir into
+// the same relative path below outDir, and writes an index.html into outDir
+// that shows all diagrams as a gallery of thumbnails. Files are rendered
+// concurrently like in renderBatch. The result is a static website that can
+// be served by any web server.
+func buildSite(srcDir, outDir string, opts *sequence.Options) {
+	if opts.Format != "svg" {
+		fail("build: only SVG output is supported")
+	}
+	paths := findDiagramFiles(srcDir)
+	sort.Strings(paths)
+	entries := make([]galleryEntry, len(paths))
+
+	processFiles(paths, func(idx int, path string) {
+		relPath, err := filepath.Rel(srcDir, path)
+		failIfErr(err)
+		relOutputPath := strings.TrimSuffix(relPath, filepath.Ext(relPath)) + ".svg"
+		outputPath := filepath.Join(outDir, relOutputPath)
+		failIfErr(os.MkdirAll(filepath.Dir(outputPath), 0777))
+
+		//like render(), but we need to know the titles and pages for the index
+		diagrams := parseFile(path)
+		renderOpts := *opts
+		renderOpts.WarnOverlaps = true
+		pages := renderDiagrams(diagrams, &renderOpts)
+		writeOutput(outputPath, pages)
+
+		entry := galleryEntry{Source: filepath.ToSlash(relPath), Title: strings.TrimSuffix(filepath.Base(path), BatchExtension)}
+		for _, diagram := range diagrams {
+			if diagram.Title != "" {
+				entry.Title = diagram.Title
+				break
+			}
+		}
+		for pageIdx := range pages {
+			entry.Pages = append(entry.Pages, galleryPage{
+				Number: pageIdx + 1,
+				Path:   filepath.ToSlash(pagePath(relOutputPath, pageIdx, len(pages))),
+			})
+		}
+		entries[idx] = entry
+	})
+
+	file, err := os.Create(filepath.Join(outDir, "index.html"))
+	failIfErr(err)
+	failIfErr(galleryTemplate.Execute(file, entries))
+	failIfErr(file.Close())
+}
+
+var galleryTemplate = template.Must(template.New("index.html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sequence diagrams</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.gallery { display: flex; flex-wrap: wrap; gap: 1.5em; }
+figure { margin: 0; width: 240px; }
+figure img { width: 240px; height: 180px; object-fit: contain; object-position: top; border: 1px solid #ccc; background: white; }
+figcaption { margin-top: 0.5em; }
+figcaption small { display: block; color: gray; }
+</style>
+</head>
+<body>
+<h1>Sequence diagrams</h1>
+<div class="gallery">
+{{- range $entry := . }}
+<figure>
+{{- with index .Pages 0 }}
+<a href="{{ .Path }}"><img src="{{ .Path }}" alt="{{ $entry.Title }}" loading="lazy"></a>
+{{- end }}
+<figcaption>{{ .Title }}
+<small>{{ .Source }}{{ if gt (len .Pages) 1 }} &middot; pages:{{ range .Pages }} <a href="{{ .Path }}">{{ .Number }}</a>{{ end }}{{ end }}</small>
+</figcaption>
+</figure>
+{{- end }}
+</div>
+</body>
+</html>
+`))
@@ -0,0 +1,86 @@
+//go:build capi
+
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// This file contains the C API of the shared library, which is built with:
+//
+//	go build -tags capi -buildmode=c-shared -o libsequencediagram.so .
+//
+// The build also writes the header file libsequencediagram.h.
+
+// sd_render renders the diagram source src. The options are a JSON object with
+// the same names as the command-line flags (e.g. {"format": "dot",
+// "autonumber": true}), or NULL for the defaults. On success, the output is
+// returned (with multiple pages separated by sequence.PageDelimiter). On
+// error, NULL is returned, and if errorMessage is not NULL, the error message
+// is stored in it. All returned strings must be released with sd_free().
+//
+//export sd_render
+func sd_render(src *C.char, options *C.char, errorMessage **C.char) *C.char {
+	var output string
+	msg := catchFailure(func() {
+		opts := sequence.DefaultOptions()
+		if options != nil {
+			var values map[string]interface{}
+			decoder := json.NewDecoder(strings.NewReader(C.GoString(options)))
+			decoder.UseNumber() //keep integers intact, e.g. 1000000 instead of 1e+06
+			if err := decoder.Decode(&values); err != nil {
+				fail("invalid options: %s", err.Error())
+			}
+			stringValues := make(map[string]string, len(values))
+			for name, value := range values {
+				stringValues[name] = fmt.Sprint(value)
+			}
+			failIfErr(opts.Set(stringValues))
+		}
+		var err error
+		output, err = sequence.RenderSource(C.GoString(src), opts)
+		failIfErr(err)
+	})
+	if msg != "" {
+		if errorMessage != nil {
+			*errorMessage = C.CString(msg)
+		}
+		return nil
+	}
+	return C.CString(output)
+}
+
+// sd_free releases a string returned by sd_render.
+//
+//export sd_free
+func sd_free(str *C.char) {
+	C.free(unsafe.Pointer(str))
+}
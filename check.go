@@ -0,0 +1,75 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+var lenientFlag = flag.Bool("lenient", false, "report errors in the input as warnings, and render the rest of the diagram")
+
+// checkFiles implements the "check" subcommand. It reports all errors in the
+// given files (or stdin) instead of stopping at the first one.
+func checkFiles(paths []string) {
+	count := 0
+	check := func(name string, r io.Reader) {
+		_, errs, err := sequence.ParseRecovering(r, nil)
+		failIfErr(err)
+		for _, e := range errs {
+			fmt.Printf("%s:%d: %s\n", name, e.Line, e.Message)
+		}
+		count += len(errs)
+	}
+
+	if len(paths) == 0 {
+		check("<stdin>", os.Stdin)
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		failIfErr(err)
+		//an empty file is reported like any other error
+		msg := catchFailure(func() { check(path, file) })
+		if msg != "" {
+			fmt.Printf("%s: %s\n", path, msg)
+			count++
+		}
+		file.Close()
+	}
+	switch {
+	case count == 1:
+		fail("found 1 error")
+	case count > 1:
+		fail("found %d errors", count)
+	}
+}
+
+// parsePagesLeniently implements -lenient.
+func parsePagesLeniently(r io.Reader) []*sequence.Diagram {
+	diagrams, errs, err := sequence.ParseRecovering(r, nil)
+	failIfErr(err)
+	for _, e := range errs {
+		warn(e.String())
+	}
+	return diagrams
+}
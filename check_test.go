@@ -0,0 +1,63 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	valid := write("valid.txt", "start a\nstop a\n")
+	invalid := write("invalid.txt", "start a\nbogus\nstop a\nstop a\n")
+	empty := write("empty.txt", "")
+
+	testCases := []struct {
+		Paths   []string
+		Failure string
+	}{
+		{[]string{valid}, ""},
+		{[]string{invalid}, "found 2 errors"},
+		{[]string{empty}, "found 1 error"},
+		{[]string{valid, invalid, empty}, "found 3 errors"},
+	}
+	//checkFiles prints the errors to stdout
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	for _, tc := range testCases {
+		if msg := catchFailure(func() { checkFiles(tc.Paths) }); msg != tc.Failure {
+			t.Errorf("check %v: expected failure %q, got %q", tc.Paths, tc.Failure, msg)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// subcommands lists all subcommands for "completion" and "man". Keep this in
+// sync with the switch in main().
+var subcommands = []struct {
+	Name        string
+	Args        string
+	Description string
+}{
+	{"verify", "<protocol-file>", "check the diagram on stdin against a protocol"},
+	{"diff", "<old-file> <new-file>", "highlight the differences between two versions of a diagram"},
+	{"merge", "<file>...", "combine the diagrams in multiple files into one diagram"},
+	{"stream", "<file>", "render a huge diagram with bounded memory usage"},
+	{"watch", "<file>", "render the file into -o whenever it changes"},
+	{"batch", "<file-or-directory>...", "render many files concurrently"},
+	{"build", "<source-directory> <output-directory>", "render all diagram files into a static website with an index page"},
+	{"accesslog", "<access-log-file>...", "render the request paths found in Envoy or nginx access logs"},
+	{"tail", "<log-file>", "follow a log file and render the events found by -rules into -o"},
+	{"ingest", "[nats://<host>[:<port>] <subject>]", "render events from NATS or stdin into -o every -flush-interval"},
+	{"serve", "", "render diagrams over HTTP"},
+	{"md", "<markdown-file>", "render the sequence code blocks in a Markdown file"},
+	{"mdbook", "[supports <renderer>]", "run as an mdBook preprocessor"},
+	{"hugo", "[<site-directory>]", "render the diagram files of a Hugo site"},
+	{"export-html", "<file>", "render a self-contained HTML page with zoom, highlighting and the source"},
+	{"confluence", "", "render an attachment and a Confluence storage-format snippet"},
+	{"fmt", "[<file>...]", "format diagram sources"},
+	{"check", "[<file>...]", "report all errors in diagram sources"},
+	{"lsp", "", "run a language server on stdin/stdout"},
+	{"theme", "export", "print the theme of the current options as a starting point for -theme-file"},
+	{"completion", "bash|zsh|fish", "print a shell completion script"},
+	{"man", "", "print a man page"},
+}
+
+// flagValues contains the possible values of flags that take one of a fixed
+// set of values. Flags with the value nil take a file name.
+func flagValues() map[string][]string {
+	var formats []string
+	for format := range sequence.OutputExtensions {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return map[string][]string{
+		"format":              formats,
+		"hidden-messages":     {"drop", "border"},
+		"redact-style":        {"hash", "pseudonym"},
+		"md-images":           {"inline", "files"},
+		"o":                   nil,
+		"cpuprofile":          nil,
+		"memprofile":          nil,
+		"rules":               nil,
+		"theme-file":          nil,
+		"redact-key-file":     nil,
+		"auth-tokens-file":    nil,
+		"webhook-secret-file": nil,
+	}
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// shortUsage returns the usage text of a flag up to the first colon, e.g.
+// "output format" for -format.
+func shortUsage(f *flag.Flag) string {
+	usage := f.Usage
+	if idx := strings.Index(usage, ": "); idx > 0 && !strings.HasPrefix(usage, "with ") {
+		usage = usage[:idx]
+	}
+	return usage
+}
+
+func printCompletion(shell string) {
+	switch shell {
+	case "bash":
+		printBashCompletion(os.Stdout)
+	case "zsh":
+		printZshCompletion(os.Stdout)
+	case "fish":
+		printFishCompletion(os.Stdout)
+	default:
+		fail("unknown shell: %s (expected bash, zsh or fish)", shell)
+	}
+}
+
+func printBashCompletion(w io.Writer) {
+	var allFlags, valueFlags []string
+	flag.VisitAll(func(f *flag.Flag) {
+		allFlags = append(allFlags, "-"+f.Name)
+		if !isBoolFlag(f) {
+			valueFlags = append(valueFlags, "-"+f.Name)
+		}
+	})
+	var names []string
+	for _, cmd := range subcommands {
+		names = append(names, cmd.Name)
+	}
+
+	fmt.Fprintln(w, "# bash completion for sequence-diagram")
+	fmt.Fprintln(w, "_sequence_diagram() {")
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `	case "$prev" in`)
+	values := flagValues()
+	for _, name := range sortedKeys(values) {
+		if values[name] == nil {
+			fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", name)
+		} else {
+			fmt.Fprintf(w, "\t-%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", name, strings.Join(values[name], " "))
+		}
+	}
+	fmt.Fprintf(w, "\t%s) return ;;\n", strings.Join(valueFlags, "|"))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(allFlags, " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\t#look for a subcommand, skipping flags and their values")
+	fmt.Fprintln(w, "\tlocal i word")
+	fmt.Fprintln(w, "\tfor ((i = 1; i < COMP_CWORD; i++)); do")
+	fmt.Fprintln(w, `		word="${COMP_WORDS[i]}"`)
+	fmt.Fprintln(w, `		case "$word" in`)
+	fmt.Fprintf(w, "\t\t%s) ((i++)) ;;\n", strings.Join(valueFlags, "|"))
+	fmt.Fprintln(w, "\t\t-*) ;;")
+	fmt.Fprintln(w, `		*) COMPREPLY=($(compgen -f -- "$cur")); return ;;`)
+	fmt.Fprintln(w, "\t\tesac")
+	fmt.Fprintln(w, "\tdone")
+	fmt.Fprintf(w, "\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _sequence_diagram sequence-diagram")
+}
+
+func printZshCompletion(w io.Writer) {
+	escape := strings.NewReplacer(`[`, `\[`, `]`, `\]`, `'`, `'\''`, `:`, `\:`)
+	values := flagValues()
+
+	fmt.Fprintln(w, "#compdef sequence-diagram")
+	fmt.Fprintln(w, "_sequence_diagram() {")
+	fmt.Fprintln(w, "\tlocal -a commands")
+	fmt.Fprintln(w, "\tcommands=(")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(w, "\t\t'%s:%s'\n", cmd.Name, escape.Replace(cmd.Description))
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, "\t_arguments \\")
+	flag.VisitAll(func(f *flag.Flag) {
+		spec := fmt.Sprintf("-%s[%s]", f.Name, escape.Replace(shortUsage(f)))
+		if !isBoolFlag(f) {
+			choices, exists := values[f.Name]
+			switch {
+			case !exists:
+				spec += ":" + f.Name + ": "
+			case choices == nil:
+				spec += ":file:_files"
+			default:
+				spec += ":" + f.Name + ":(" + strings.Join(choices, " ") + ")"
+			}
+		}
+		fmt.Fprintf(w, "\t\t'%s' \\\n", spec)
+	})
+	fmt.Fprintln(w, "\t\t'1:command:_describe command commands' \\")
+	fmt.Fprintln(w, "\t\t'*:file:_files'")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `_sequence_diagram "$@"`)
+}
+
+func printFishCompletion(w io.Writer) {
+	quote := func(text string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(text) + "'"
+	}
+	values := flagValues()
+
+	fmt.Fprintln(w, "# fish completion for sequence-diagram")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(w, "complete -c sequence-diagram -n __fish_use_subcommand -f -a %s -d %s\n", cmd.Name, quote(cmd.Description))
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		line := fmt.Sprintf("complete -c sequence-diagram -o %s -d %s", f.Name, quote(shortUsage(f)))
+		if !isBoolFlag(f) {
+			choices, exists := values[f.Name]
+			switch {
+			case !exists:
+				line += " -x"
+			case choices == nil:
+				line += " -r -F"
+			default:
+				line += " -x -a " + quote(strings.Join(choices, " "))
+			}
+		}
+		fmt.Fprintln(w, line)
+	})
+}
+
+// printManPage writes a man page in troff format. For reproducible builds,
+// the date is taken from $SOURCE_DATE_EPOCH if set.
+func printManPage(w io.Writer) {
+	escape := strings.NewReplacer(`\`, `\e`, `-`, `\-`, `'`, `\(aq`)
+	date := time.Now()
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		date = time.Unix(epoch, 0).UTC()
+	}
+	fmt.Fprintf(w, ".TH SEQUENCE-DIAGRAM 1 %q\n", date.Format("2006-01-02"))
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `sequence\-diagram \- render sequence diagrams from a textual description`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, `.B sequence\-diagram`)
+	fmt.Fprintln(w, `[\fIoptions\fR] < \fIdiagram-file\fR`)
+	fmt.Fprintln(w, ".br")
+	fmt.Fprintln(w, `.B sequence\-diagram`)
+	fmt.Fprintln(w, `[\fIoptions\fR] \fIcommand\fR [\fIarguments\fR]`)
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintln(w, "Without a command, the diagram on stdin is rendered to stdout (or into the file given with")
+	fmt.Fprintln(w, `.BR \-o ).`)
+	fmt.Fprintln(w, "Each line of input contains one or more commands (separated by semicolons), and each empty line advances time.")
+	fmt.Fprintln(w, "Lines starting with # are comments. The input language has the following commands:")
+	fmt.Fprintln(w, ".PP")
+	fmt.Fprintln(w, escape.Replace(strings.Join(lspCommands, ", ")))
+	fmt.Fprintln(w, ".SH COMMANDS")
+	for _, cmd := range subcommands {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, ".B %s\n", escape.Replace(strings.TrimSpace(cmd.Name+" "+cmd.Args)))
+		fmt.Fprintln(w, escape.Replace(cmd.Description))
+	}
+	fmt.Fprintln(w, ".SH OPTIONS")
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintln(w, ".TP")
+		name := escape.Replace("-" + f.Name)
+		if isBoolFlag(f) {
+			fmt.Fprintf(w, ".B %s\n", name)
+		} else {
+			fmt.Fprintf(w, ".BI %s \" \" %s\n", name, f.Name)
+		}
+		usage := f.Usage
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+			usage += " (default: " + f.DefValue + ")"
+		}
+		fmt.Fprintln(w, escape.Replace(usage))
+	})
+	fmt.Fprintln(w, ".SH EXIT STATUS")
+	fmt.Fprintln(w, "0 on success. Otherwise, the cause is reported on stderr, and the exit status is:")
+	for _, status := range []struct {
+		Code        int
+		Description string
+	}{
+		{ExitWarnings, "warnings were reported, and -warnings-as-errors is set"},
+		{ExitInvalidInput, "the input contains errors, or the arguments are invalid"},
+		{ExitIOError, "input could not be read, or output could not be written"},
+	} {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, ".B %d\n", status.Code)
+		fmt.Fprintln(w, escape.Replace(status.Description))
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
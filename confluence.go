@@ -0,0 +1,66 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// exportConfluence renders the diagrams from the input as SVG into the output
+// file (-o), which is meant to be uploaded as an attachment to a Confluence
+// page, and writes a snippet in Confluence's storage format (XHTML) onto
+// stdout that shows the attachment. Like with writeOutput, multiple pages are
+// written into numbered files, and the snippet contains one image per page.
+func exportConfluence(input io.Reader, opts *sequence.Options, outputPath string) {
+	if outputPath == "" {
+		fail("confluence: attachment file must be given with -o")
+	}
+	svgOpts := *opts
+	svgOpts.Format = "svg"
+
+	diagrams, err := sequence.Transform(parse(input), &svgOpts)
+	failIfErr(err)
+	var pages []sequence.Page
+	var titles []string
+	for _, diagram := range diagrams {
+		title := diagram.Title
+		if title == "" {
+			title = "sequence diagram"
+		}
+		diagramPages, err := sequence.RenderPages(diagram, &svgOpts)
+		failIfErr(err)
+		for _, page := range diagramPages {
+			pages = append(pages, page)
+			titles = append(titles, title)
+		}
+	}
+	writeOutput(outputPath, pages)
+
+	for idx, title := range titles {
+		fmt.Printf(`<p><ac:image ac:alt="%s" ac:title="%s"><ri:attachment ri:filename="%s" /></ac:image></p>`+"\n",
+			html.EscapeString(title), html.EscapeString(title),
+			html.EscapeString(filepath.Base(pagePath(outputPath, idx, len(pages)))),
+		)
+	}
+}
@@ -0,0 +1,68 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// diffFiles compares the diagrams in two input files. Unless -format is
+// "changes", the new version of each diagram is rendered with added messages
+// highlighted in green, and removed messages inserted in red. With "-format
+// changes", a list of added and removed messages is written instead.
+func diffFiles(oldPath, newPath string, opts *sequence.Options, outputPath string) {
+	oldDiagrams, newDiagrams := parseFile(oldPath), parseFile(newPath)
+	if len(oldDiagrams) != len(newDiagrams) {
+		fail("cannot compare %s and %s: different number of pages (%d vs. %d)",
+			oldPath, newPath, len(oldDiagrams), len(newDiagrams),
+		)
+	}
+
+	var pages []sequence.Page
+	for idx, newDiagram := range newDiagrams {
+		changes, err := sequence.Diff(oldDiagrams[idx], newDiagram)
+		failIfErr(err)
+		if opts.Format == "changes" {
+			pages = append(pages, func(w io.Writer) error {
+				for _, change := range changes {
+					if _, err := fmt.Fprintln(w, change); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		} else {
+			diagramPages, err := sequence.RenderPages(newDiagram, opts)
+			failIfErr(err)
+			pages = append(pages, diagramPages...)
+		}
+	}
+	writeOutput(outputPath, pages)
+}
+
+func parseFile(path string) []*sequence.Diagram {
+	file, err := os.Open(path)
+	failIfErr(err)
+	defer file.Close()
+	return parse(file)
+}
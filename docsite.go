@@ -0,0 +1,122 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// runMdBook implements the preprocessor protocol of mdBook, to be configured
+// in book.toml as:
+//
+//	[preprocessor.sequence-diagram]
+//	command = "sequence-diagram mdbook"
+//
+// mdBook first calls "sequence-diagram mdbook supports <renderer>", where a
+// zero exit code means that the renderer is supported. Since the diagrams
+// become Markdown images with data URIs, every renderer is supported. Then it
+// calls "sequence-diagram mdbook" with the JSON array [context, book] on stdin,
+// and expects the book with all chapters rewritten by rewriteMarkdown on
+// stdout.
+func runMdBook(args []string, opts *sequence.Options) {
+	if len(args) > 0 {
+		if len(args) == 2 && args[0] == "supports" {
+			return
+		}
+		fail("usage: %s mdbook [supports <renderer>]", os.Args[0])
+	}
+
+	var input []json.RawMessage
+	failIfErr(json.NewDecoder(os.Stdin).Decode(&input))
+	if len(input) != 2 {
+		fail("mdbook: expected [context, book] on stdin, got an array with %d elements", len(input))
+	}
+	//the book is decoded generically to retain all fields that we do not know about
+	var book map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(input[1]))
+	decoder.UseNumber()
+	failIfErr(decoder.Decode(&book))
+
+	//the list of chapters was renamed from "sections" to "items" in mdBook 0.5
+	var sections []interface{}
+	found := false
+	for _, key := range []string{"items", "sections"} {
+		if value, exists := book[key]; exists {
+			sections, found = value.([]interface{})
+			break
+		}
+	}
+	if !found {
+		fail("mdbook: expected a list of chapters in the book's \"items\" or \"sections\"")
+	}
+	rewriteBookItems(sections, opts)
+	failIfErr(json.NewEncoder(os.Stdout).Encode(book))
+}
+
+// rewriteBookItems rewrites the chapters (and their sub-chapters) in a list of
+// mdBook's BookItems. Other items (separators and part titles) are skipped.
+func rewriteBookItems(items []interface{}, opts *sequence.Options) {
+	for _, item := range items {
+		wrapper, ok := item.(map[string]interface{})
+		if !ok {
+			continue //"Separator"
+		}
+		chapter, ok := wrapper["Chapter"].(map[string]interface{})
+		if !ok {
+			continue //{"PartTitle": ...}
+		}
+		content, _ := chapter["content"].(string)
+		name, _ := chapter["path"].(string) //null for draft chapters
+		if name == "" {
+			name, _ = chapter["name"].(string)
+		}
+		chapter["content"] = rewriteMarkdown(content, name, opts, func(number int, svg []byte) string {
+			return dataURI(svg)
+		})
+		subItems, _ := chapter["sub_items"].([]interface{})
+		rewriteBookItems(subItems, opts)
+	}
+}
+
+// renderHugoSite renders each diagram file (with BatchExtension) below the
+// content/ directory of a Hugo site into the same relative path below static/,
+// so that e.g. content/docs/login.seq can be referenced in content/docs/_index.md
+// as /docs/login.svg. Files are rendered concurrently like in renderBatch.
+func renderHugoSite(siteDir string, opts *sequence.Options) {
+	ext, exists := sequence.OutputExtensions[opts.Format]
+	if !exists {
+		fail("unknown output format: %s", opts.Format)
+	}
+	contentDir := filepath.Join(siteDir, "content")
+	staticDir := filepath.Join(siteDir, "static")
+
+	renderFiles(findDiagramFiles(contentDir), opts, func(path string) string {
+		relPath, err := filepath.Rel(contentDir, path)
+		failIfErr(err)
+		outputPath := filepath.Join(staticDir, strings.TrimSuffix(relPath, filepath.Ext(relPath))+ext)
+		failIfErr(os.MkdirAll(filepath.Dir(outputPath), 0777))
+		return outputPath
+	})
+}
@@ -0,0 +1,254 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+var writeFlag = flag.Bool("w", false, "fmt: write the result into the input files instead of stdout")
+
+// structuralArgs contains the number of arguments of each command that are
+// names (of actors, messages etc.) and are therefore aligned by formatSource.
+// All other arguments are free text.
+var structuralArgs = map[string]int{
+	"start": 1, "stop": 1, "label": 1, "style": 1, "participant": 1, "stereotype": 1,
+	"send": 2, "call": 2, "return": 2,
+	"send!": 3, "call!": 3, "return!": 3,
+	"receive": 2, "timeout": 2, "hide-return": 1, "placement": 1,
+	"timer": 3, "constraint": 2, "before": 2, "option": 1, "state": 1, "coregion": 2,
+}
+
+// formatFiles implements the "fmt" subcommand. Without arguments, it formats
+// stdin onto stdout.
+func formatFiles(paths []string) {
+	if len(paths) == 0 {
+		input, err := io.ReadAll(os.Stdin)
+		failIfErr(err)
+		_, err = os.Stdout.WriteString(formatSource(decodeSource(input)))
+		failIfErr(err)
+		return
+	}
+	for _, path := range paths {
+		input, err := os.ReadFile(path)
+		failIfErr(err)
+		output := formatSource(decodeSource(input))
+		if *writeFlag {
+			if output != string(input) {
+				failIfErr(os.WriteFile(path, []byte(output), 0666))
+			}
+		} else {
+			_, err = os.Stdout.WriteString(output)
+			failIfErr(err)
+		}
+	}
+}
+
+// decodeSource is like DecodeInput, but for input that has been read
+// completely already.
+func decodeSource(input []byte) string {
+	decoded, err := io.ReadAll(sequence.DecodeInput(bytes.NewReader(input)))
+	failIfErr(err)
+	return string(decoded)
+}
+
+// fmtLine is a line of output in formatSource. Lines with Cells are aligned
+// with adjacent lines of the same Shape; all other lines are written as Text.
+type fmtLine struct {
+	Text     string
+	Cells    []string
+	Shape    string
+	Sortable bool //for "label" commands whose position does not matter (see formatSource)
+	SortKey  string
+}
+
+// formatSource returns the canonical form of a diagram source, which is
+// rendered exactly like the original:
+//
+//   - Whitespace within commands is normalized, and the names in consecutive
+//     commands are aligned in columns.
+//   - Blank lines are kept where they advance time, but removed or collapsed
+//     at the end of a page, in "legend" blocks, and in auto-tick mode.
+//   - Consecutive "label" commands are sorted by actor name if all of these
+//     actors have been mentioned before, since only the first mention of an
+//     actor determines its position.
+//   - Comments are kept, but break up alignment and sorting, since they
+//     usually refer to the following line.
+func formatSource(input string) string {
+	var lines []*fmtLine
+	var autoTick, inLegend bool
+	seenActors := make(map[string]bool)
+
+	isBlank := func(line *fmtLine) bool { return line.Text == "" && line.Cells == nil }
+	trimBlankLines := func() {
+		for len(lines) > 0 && isBlank(lines[len(lines)-1]) {
+			lines = lines[:len(lines)-1]
+		}
+	}
+
+	for _, line := range strings.Split(input, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case sequence.IsComment(line):
+			lines = append(lines, &fmtLine{Text: strings.TrimSpace(line)})
+		case len(fields) == 0:
+			if inLegend || (autoTick && len(lines) > 0 && isBlank(lines[len(lines)-1])) {
+				continue //does not advance time
+			}
+			lines = append(lines, &fmtLine{})
+		case fields[0] == "newpage":
+			trimBlankLines()
+			lines = append(lines, &fmtLine{Text: strings.Join(fields, " ")})
+			autoTick, inLegend = false, false
+			seenActors = make(map[string]bool)
+		case inLegend:
+			if len(fields) == 1 && fields[0] == "end" {
+				inLegend = false
+			}
+			lines = append(lines, &fmtLine{Cells: splitCells(fields, 1), Shape: "legend"})
+		default:
+			result := &fmtLine{}
+			commands := sequence.SplitCommands(line)
+			var normalized []string
+			for _, command := range commands {
+				cmdFields := strings.Fields(command)
+				if len(cmdFields) == 0 {
+					continue
+				}
+				normalized = append(normalized, escapeCommand(strings.Join(cmdFields, " ")))
+				cmd := cmdFields
+				if strings.HasPrefix(cmd[0], "@") && len(cmd) > 1 {
+					cmd = cmd[1:]
+				}
+				switch {
+				case cmd[0] == "legend":
+					inLegend = true
+				case cmd[0] == "option" && len(cmd) == 2 && cmd[1] == "auto-tick":
+					autoTick = true
+				}
+				if len(commands) == 1 {
+					if len(cmd) < len(cmdFields) {
+						result.Cells = append([]string{cmdFields[0]}, splitCells(cmd, 1+structuralArgs[cmd[0]])...)
+						result.Shape = "timestamp"
+					} else {
+						result.Cells = splitCells(cmd, 1+structuralArgs[cmd[0]])
+						result.Shape = "command"
+					}
+					for idx, cell := range result.Cells {
+						result.Cells[idx] = escapeCommand(cell)
+					}
+					if cmd[0] == "label" && len(cmd) > 2 && seenActors[cmd[1]] {
+						result.Sortable = true
+						result.SortKey = cmd[1]
+					}
+				}
+			}
+			if result.Cells == nil {
+				result.Text = strings.Join(normalized, "; ")
+			}
+			tokens, _ := tokenizeLine(line, false)
+			for _, token := range tokens {
+				if token.Role == tokenActor {
+					seenActors[token.Text] = true
+				}
+			}
+			lines = append(lines, result)
+		}
+	}
+	trimBlankLines()
+
+	//sort runs of sortable "label" commands
+	for start := 0; start < len(lines); start++ {
+		end := start
+		for end < len(lines) && lines[end].Sortable {
+			end++
+		}
+		run := lines[start:end]
+		sort.SliceStable(run, func(i, j int) bool { return run[i].SortKey < run[j].SortKey })
+		start = end
+	}
+
+	//align cells in runs of lines with the same shape
+	for start := 0; start < len(lines); {
+		end := start + 1
+		if lines[start].Cells != nil {
+			for end < len(lines) && lines[end].Cells != nil && lines[end].Shape == lines[start].Shape {
+				end++
+			}
+			alignCells(lines[start:end])
+		}
+		start = end
+	}
+
+	var out strings.Builder
+	for _, line := range lines {
+		out.WriteString(line.Text)
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// splitCells returns the first count fields as separate cells, and the
+// remaining fields (if any) as one cell.
+func splitCells(fields []string, count int) []string {
+	if len(fields) <= count {
+		return append([]string(nil), fields...)
+	}
+	return append(append([]string(nil), fields[:count]...), strings.Join(fields[count:], " "))
+}
+
+// escapeCommand reverses the unescaping of semicolons in SplitCommands.
+func escapeCommand(command string) string {
+	return strings.Replace(command, ";", `\;`, -1)
+}
+
+// alignCells pads all cells except for the last one in each line to the
+// width of the widest cell in the same column, and stores the result in Text.
+// All lines must have Cells.
+func alignCells(lines []*fmtLine) {
+	var widths []int
+	for _, line := range lines {
+		for idx, cell := range line.Cells[:len(line.Cells)-1] {
+			if idx == len(widths) {
+				widths = append(widths, 0)
+			}
+			if width := sequence.TextWidth(cell); widths[idx] < width {
+				widths[idx] = width
+			}
+		}
+	}
+	for _, line := range lines {
+		var text strings.Builder
+		last := len(line.Cells) - 1
+		for idx, cell := range line.Cells {
+			text.WriteString(cell)
+			if idx < last {
+				text.WriteString(strings.Repeat(" ", widths[idx]-sequence.TextWidth(cell)+1))
+			}
+		}
+		line.Text = text.String()
+	}
+}
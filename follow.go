@@ -0,0 +1,197 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+var followFlag = flag.Bool("follow", false, "keep reading stdin as it grows, and render into -o after each change")
+
+// follower keeps the parser state for -follow between chunks of input.
+type follower struct {
+	Pages      []*sequence.Diagram //finished pages
+	Parser     *sequence.Parser    //for the current page
+	Title      string              //of the current page
+	HasContent bool                //whether the current page contains anything
+	LineNo     int
+}
+
+// followInput parses the input as it arrives, and renders the diagram into
+// the output file whenever new lines have been parsed. Lines that cannot be
+// parsed are reported and skipped. If the input is a regular file, reaching
+// its end does not end the input (like "tail -f"); otherwise the diagram is
+// checked for completeness at the end of the input.
+func followInput(input *os.File, opts *sequence.Options, outputPath string) {
+	if outputPath == "" {
+		fail("-follow requires an output file (-o)")
+	}
+	info, err := input.Stat()
+	failIfErr(err)
+	lines := readLines(sequence.DecodeInput(input), info.Mode().IsRegular())
+
+	f := &follower{Parser: sequence.NewParser()}
+	for batch := range lines {
+		//take everything that has arrived in the meantime, to avoid rendering
+		//after each line when the input arrives quickly
+		for more := true; more; {
+			select {
+			case next, ok := <-lines:
+				batch = append(batch, next...)
+				more = ok
+			default:
+				more = false
+			}
+		}
+		f.addLines(batch)
+		msg := catchFailure(func() {
+			writeOutputAtomically(outputPath, renderDiagrams(f.snapshot(), opts))
+		})
+		if msg != "" {
+			slog.Error(msg, "mode", "follow")
+		}
+	}
+
+	f.finishPage()
+	if len(f.Pages) == 0 {
+		fail("input does not contain any commands")
+	}
+	writeOutputAtomically(outputPath, renderDiagrams(f.Pages, opts))
+}
+
+// readLines sends the complete lines of the input to the channel, in chunks
+// of what a single read returned. If wait is true, reaching the end of the
+// input does not end it; instead, the input is polled for more data.
+func readLines(input io.Reader, wait bool) <-chan []string {
+	lines := make(chan []string, 16)
+	go func() {
+		defer close(lines)
+		buf := make([]byte, 64<<10)
+		var partial string //incomplete last line
+		for {
+			n, err := input.Read(buf)
+			chunk := strings.SplitAfter(partial+string(buf[:n]), "\n")
+			partial = chunk[len(chunk)-1]
+			if len(chunk) > 1 {
+				lines <- chunk[:len(chunk)-1]
+			}
+			switch {
+			case err == io.EOF && wait:
+				time.Sleep(WatchInterval)
+			case err != nil:
+				if err != io.EOF {
+					slog.Error(err.Error(), "mode", "follow")
+				}
+				if partial != "" {
+					lines <- []string{partial}
+				}
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// addLines parses the given lines. Lines that fail to parse are reported and
+// skipped.
+func (f *follower) addLines(lines []string) {
+	//a line that fails to parse may have changed the parser state partially,
+	//so the state is then rebuilt from the last good state
+	backup := f.Parser.Clone()
+	var parsed []string //since backup was taken
+	for _, line := range lines {
+		f.LineNo++
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "newpage" {
+			f.finishPage()
+			f.Title = sequence.ParseText(fields[1:])
+			backup, parsed = f.Parser.Clone(), nil
+			continue
+		}
+
+		err := f.Parser.ParseLine(line)
+		if err == nil {
+			parsed = append(parsed, line)
+			f.HasContent = f.HasContent || len(fields) > 0
+			continue
+		}
+		slog.Error(err.Error(), "mode", "follow", "line", f.LineNo)
+		f.Parser = backup.Clone()
+		for _, line := range parsed {
+			f.Parser.ParseLine(line) //cannot fail, since it did not fail before
+		}
+	}
+}
+
+// finishPage moves the current page into f.Pages, and starts a new one.
+// Incomplete diagrams are reported, but kept.
+func (f *follower) finishPage() {
+	if f.HasContent {
+		if err := f.Parser.Clone().Finish(); err != nil {
+			slog.Error(err.Error(), "mode", "follow", "page_end_line", f.LineNo)
+		}
+		diagram := f.Parser.Snapshot()
+		diagram.Title = f.Title
+		f.Pages = append(f.Pages, diagram)
+	}
+	f.Parser, f.Title, f.HasContent = sequence.NewParser(), "", false
+}
+
+// snapshot returns copies of all pages (including the current one), since
+// rendering may modify the diagrams.
+func (f *follower) snapshot() []*sequence.Diagram {
+	var result []*sequence.Diagram
+	for _, diagram := range f.Pages {
+		result = append(result, diagram.Clone())
+	}
+	if f.HasContent {
+		diagram := f.Parser.Snapshot()
+		diagram.Title = f.Title
+		result = append(result, diagram)
+	}
+	return result
+}
+
+// writeOutputAtomically is like writeOutput into a file, but each output file
+// is replaced only once it has been written completely, so that readers never
+// see a partial file.
+func writeOutputAtomically(outputPath string, pages []sequence.Page) {
+	defer timings.Measure("output")()
+	for idx, page := range pages {
+		path := pagePath(outputPath, idx, len(pages))
+		file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+		failIfErr(err)
+		defer os.Remove(file.Name()) //only has an effect if the rename did not happen
+		failIfErr(file.Chmod(0644))
+		out := bufio.NewWriter(file)
+		failIfErr(page(out))
+		failIfErr(out.Flush())
+		failIfErr(file.Close())
+		failIfErr(os.Rename(file.Name(), path))
+	}
+}
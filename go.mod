@@ -0,0 +1,3 @@
+module github.com/johan48191/sequence-diagram
+
+go 1.26
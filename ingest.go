@@ -0,0 +1,270 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/record"
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+var (
+	senderFieldFlag   = flag.String("sender-field", "sender", "ingest: JSON field with the sender of an event (nested fields are separated by dots)")
+	receiverFieldFlag = flag.String("receiver-field", "receiver", "ingest: JSON field with the receiver of an event")
+	labelFieldFlag    = flag.String("label-field", "", "ingest: JSON field with the message label (default: the NATS subject, or the event type for stdin)")
+	flushIntervalFlag = flag.Duration("flush-interval", 10*time.Second, "ingest: how often the rendered snapshot is written into -o")
+)
+
+// ingester builds a diagram from events observed on a message bus. Each event
+// is a JSON object, which is mapped to a message by the -*-field flags.
+type ingester struct {
+	Recorder *record.Recorder
+	mutex    sync.Mutex
+	Changed  bool //whether events were added since the last snapshot
+}
+
+// ingestEvents renders the events from the given source into the output file
+// (-o) every -flush-interval. The source is either a NATS server URL (with the
+// subject as second argument), or empty for JSON lines on stdin, e.g.
+//
+//	kcat -C -u -t <topic> -f '%s\n' | sequence-diagram -o out.svg ingest
+func ingestEvents(args []string, opts *sequence.Options, outputPath string) {
+	if outputPath == "" {
+		fail("ingest: output file must be given with -o")
+	}
+	in := &ingester{Recorder: record.New()}
+	go func() {
+		for range time.Tick(*flushIntervalFlag) {
+			in.flush(opts, outputPath)
+		}
+	}()
+
+	var err error
+	switch len(args) {
+	case 0:
+		err = in.readLines(os.Stdin)
+	case 2:
+		err = in.subscribeNATS(args[0], args[1])
+	default:
+		fail("usage: %s ingest -o <output-file> [nats://<host>[:<port>] <subject>]", os.Args[0])
+	}
+	//render the final state before reporting why the input ended
+	in.flush(opts, outputPath)
+	failIfErr(err)
+}
+
+// add records the event in the given payload. Events that cannot be mapped to
+// a message are reported and skipped.
+func (in *ingester) add(subject string, payload []byte) {
+	var event map[string]interface{}
+	err := json.Unmarshal(payload, &event)
+	if err != nil {
+		slog.Warn("skipping event", "mode", "ingest", "subject", subject, "error", err.Error())
+		return
+	}
+	sender, receiver := eventField(event, *senderFieldFlag), eventField(event, *receiverFieldFlag)
+	if sender == "" || receiver == "" {
+		slog.Warn("skipping event", "mode", "ingest", "subject", subject, "error", fmt.Sprintf("missing %s or %s", *senderFieldFlag, *receiverFieldFlag))
+		return
+	}
+	label := subject
+	if *labelFieldFlag != "" {
+		label = eventField(event, *labelFieldFlag)
+	}
+
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	in.Recorder.Send(sender, receiver, label)
+	in.Changed = true
+}
+
+// eventField returns the value at the given dot-separated path in the event,
+// or "" if there is none.
+func eventField(event map[string]interface{}, path string) string {
+	var value interface{} = event
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// flush renders the diagram if it has changed. Errors are reported, but do not
+// end the ingestion.
+func (in *ingester) flush(opts *sequence.Options, outputPath string) {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+	if !in.Changed {
+		return
+	}
+	in.Changed = false
+
+	msg := catchFailure(func() {
+		var buf bytes.Buffer
+		_, err := in.Recorder.WriteTo(&buf)
+		failIfErr(err)
+		render(parse(&buf), opts, outputPath)
+	})
+	if msg != "" {
+		slog.Error(msg, "mode", "ingest")
+	}
+}
+
+// readLines reads one event per line until EOF. The label defaults to the
+// "type" field.
+func (in *ingester) readLines(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		subject := "event"
+		var event struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(line, &event) == nil && event.Type != "" {
+			subject = event.Type
+		}
+		in.add(subject, line)
+	}
+	return scanner.Err()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// NATS client
+
+// subscribeNATS receives the messages on the given subject (which may contain
+// wildcards) from a NATS server, until the connection breaks. Only the plain
+// text protocol is supported (no TLS, no authentication other than user and
+// password in the URL).
+func (in *ingester) subscribeNATS(serverURL, subject string) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "nats" {
+		return fmt.Errorf("ingest: unsupported URL scheme %q (expected nats://)", u.Scheme)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	//the server greets with INFO, which tells whether TLS is required
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("ingest: unexpected greeting from NATS server: %q", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if json.Unmarshal([]byte(line[5:]), &info) == nil && info.TLSRequired {
+		return fmt.Errorf("ingest: NATS server %s requires TLS, which is not supported", address)
+	}
+
+	connect := map[string]interface{}{"verbose": false, "pedantic": false, "name": "sequence-diagram"}
+	if u.User != nil {
+		connect["user"] = u.User.Username()
+		connect["pass"], _ = u.User.Password()
+	}
+	connectJSON, err := json.Marshal(connect)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "CONNECT %s\r\nSUB %s 1\r\nPING\r\n", connectJSON, subject)
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return fmt.Errorf("ingest: NATS server %s closed the connection", address)
+		}
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "MSG":
+			//MSG <subject> <sid> [<reply-to>] <size>
+			if len(fields) < 4 {
+				return fmt.Errorf("ingest: malformed message from NATS server: %q", line)
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return fmt.Errorf("ingest: malformed message from NATS server: %q", line)
+			}
+			payload := make([]byte, size+2) //including the trailing CRLF
+			_, err = io.ReadFull(r, payload)
+			if err != nil {
+				return err
+			}
+			in.add(fields[1], payload[:size])
+		case "PING":
+			_, err = io.WriteString(conn, "PONG\r\n")
+			if err != nil {
+				return err
+			}
+		case "-ERR":
+			return fmt.Errorf("ingest: NATS server reported error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		default:
+			//ignore PONG, +OK and INFO updates
+		}
+	}
+}
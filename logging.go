@@ -0,0 +1,151 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var logFormatFlag = flag.String("log-format", "plain", "format of warnings, errors and progress reports on stderr: plain (for humans), text (logfmt) or json")
+
+// setupLogging installs the default logger according to -log-format, -quiet
+// and -verbose. All messages on stderr (except for -timings) go through this
+// logger, so that long-running subcommands like "serve" and "watch" produce
+// logs that can be collected and parsed.
+func setupLogging() {
+	opts := &slog.HandlerOptions{Level: slog.LevelWarn}
+	switch {
+	case *quietFlag:
+		opts.Level = slog.LevelError
+	case *verboseFlag:
+		opts.Level = slog.LevelInfo
+	}
+
+	//errors in the flags are reported in the plain format
+	var handler slog.Handler = &plainHandler{Level: opts.Level, W: os.Stderr, Mutex: &sync.Mutex{}}
+	slog.SetDefault(slog.New(countingHandler{handler}))
+	if *quietFlag && *verboseFlag {
+		fail("-quiet and -verbose cannot be combined")
+	}
+
+	switch *logFormatFlag {
+	case "plain":
+		return
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		fail("unknown log format: %s", *logFormatFlag)
+	}
+	slog.SetDefault(slog.New(countingHandler{handler}))
+}
+
+// warningCount is the number of warnings reported so far, including those
+// suppressed by -quiet (for -warnings-as-errors). It is accessed atomically,
+// since warnings can be reported from multiple goroutines (e.g. in batch mode).
+var warningCount int64
+
+// countingHandler counts all warnings that go through the logger before
+// passing them on.
+type countingHandler struct {
+	slog.Handler
+}
+
+func (h countingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level == slog.LevelWarn || h.Handler.Enabled(ctx, level)
+}
+
+func (h countingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelWarn {
+		atomic.AddInt64(&warningCount, 1)
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h countingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return countingHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h countingHandler) WithGroup(name string) slog.Handler {
+	return countingHandler{h.Handler.WithGroup(name)}
+}
+
+// plainHandler formats log records as the program always did: one line per
+// record, with a "warning:" prefix for warnings, followed by the attributes
+// in key=value form.
+type plainHandler struct {
+	Level slog.Leveler
+	Attrs []slog.Attr
+	W     io.Writer
+	Mutex *sync.Mutex //shared by all handlers derived from the same handler
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.Level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var line strings.Builder
+	if r.Level == slog.LevelWarn {
+		line.WriteString("warning: ")
+	}
+	line.WriteString(r.Message)
+	write := func(attr slog.Attr) bool {
+		value := attr.Value.String()
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&line, " %s=%s", attr.Key, value)
+		return true
+	}
+	for _, attr := range h.Attrs {
+		write(attr)
+	}
+	r.Attrs(write)
+	line.WriteString("\n")
+
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+	_, err := io.WriteString(h.W, line.String())
+	return err
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	result := *h
+	result.Attrs = append(h.Attrs[:len(h.Attrs):len(h.Attrs)], attrs...)
+	return &result
+}
+
+func (h *plainHandler) WithGroup(name string) slog.Handler {
+	//groups are not used in this program
+	return h
+}
@@ -0,0 +1,611 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// runLSP runs a language server for the diagram language on stdin/stdout
+// (see https://microsoft.github.io/language-server-protocol/). It supports
+// diagnostics, completion of commands, actor names and message names, hover
+// information for actors and messages, and go-to-definition from any mention
+// of a message to the command that sent it (or from an actor to its first
+// mention).
+func runLSP(in io.Reader, out io.Writer) {
+	s := &lspServer{
+		W:         bufio.NewWriter(out),
+		Documents: make(map[string]*lspDocument),
+	}
+	r := bufio.NewReader(in)
+	for {
+		body, err := readLSPMessage(r)
+		if err == io.EOF {
+			return
+		}
+		failIfErr(err)
+
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if json.Unmarshal(body, &req) != nil {
+			continue
+		}
+		if req.Method == "exit" {
+			return
+		}
+		result, isRequest := s.handle(req.Method, req.Params)
+		if req.ID == nil {
+			continue //notifications do not get a response
+		}
+		if !isRequest {
+			s.send(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      req.ID,
+				"error":   map[string]interface{}{"code": -32601, "message": "method not found: " + req.Method},
+			})
+			continue
+		}
+		s.send(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}
+}
+
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if value := strings.TrimPrefix(line, "Content-Length:"); value != line {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %s", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+type lspServer struct {
+	W         *bufio.Writer
+	Documents map[string]*lspDocument //key = URI
+}
+
+func (s *lspServer) send(msg interface{}) {
+	body, err := json.Marshal(msg)
+	failIfErr(err)
+	fmt.Fprintf(s.W, "Content-Length: %d\r\n\r\n", len(body))
+	s.W.Write(body)
+	failIfErr(s.W.Flush())
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` //in UTF-16 code units
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+// handle processes a request or notification, and returns the result (for
+// requests) and whether the method is known.
+func (s *lspServer) handle(method string, params json.RawMessage) (interface{}, bool) {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, //full document on every change
+				"completionProvider": map[string]interface{}{},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "sequence-diagram"},
+		}, true
+	case "shutdown":
+		return nil, true
+	case "textDocument/didOpen", "textDocument/didChange":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if json.Unmarshal(params, &p) == nil {
+			text := p.TextDocument.Text
+			if len(p.ContentChanges) > 0 {
+				text = p.ContentChanges[len(p.ContentChanges)-1].Text
+			}
+			doc := analyzeDocument(text)
+			s.Documents[p.TextDocument.URI] = doc
+			s.publishDiagnostics(p.TextDocument.URI, doc.Diagnostics)
+		}
+		return nil, true
+	case "textDocument/didClose":
+		var p lspTextDocumentPosition
+		if json.Unmarshal(params, &p) == nil {
+			delete(s.Documents, p.TextDocument.URI)
+			s.publishDiagnostics(p.TextDocument.URI, nil)
+		}
+		return nil, true
+	case "textDocument/completion", "textDocument/hover", "textDocument/definition":
+		var p lspTextDocumentPosition
+		if json.Unmarshal(params, &p) != nil {
+			return nil, true
+		}
+		doc := s.Documents[p.TextDocument.URI]
+		if doc == nil || p.Position.Line < 0 || p.Position.Line >= len(doc.Lines) {
+			return nil, true
+		}
+		switch method {
+		case "textDocument/completion":
+			return doc.complete(p.Position), true
+		case "textDocument/hover":
+			return doc.hover(p.Position), true
+		default:
+			return doc.definition(p.TextDocument.URI, p.Position), true
+		}
+	default:
+		//other notifications (e.g. "initialized" or "$/cancelRequest") are ignored
+		return nil, strings.HasPrefix(method, "$/") || method == "initialized"
+	}
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+func (s *lspServer) publishDiagnostics(uri string, diagnostics []lspDiagnostic) {
+	if diagnostics == nil {
+		diagnostics = []lspDiagnostic{} //must be serialized as [], not null
+	}
+	s.send(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/publishDiagnostics",
+		"params":  map[string]interface{}{"uri": uri, "diagnostics": diagnostics},
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// document analysis
+
+// lspCommands contains all commands known to Parser.parseLine (plus
+// "newpage", which is handled by Parse), for completion.
+var lspCommands = []string{
+	"annotate", "before", "call", "call!", "constraint", "coregion", "delay", "divider", "end",
+	"hide-return", "label", "legend", "newpage", "option", "participant", "placement",
+	"receive", "return", "return!", "send", "send!", "skip", "spacing", "start",
+	"state", "stereotype", "stop", "style", "timeout", "timer", "together",
+}
+
+const (
+	tokenOther = iota
+	tokenCommand
+	tokenActor
+	tokenMessage
+)
+
+// lspToken is a word in a line of the document.
+type lspToken struct {
+	Text       string
+	Start, End int //byte offsets within the line
+	Role       int
+	Defines    bool              //for messages: whether the command sends the message
+	Message    *sequence.Message //for defining messages: the message that was sent (if parsing got this far)
+}
+
+type lspPage struct {
+	Parser    *sequence.Parser
+	FirstLine int
+	LastLine  int
+	Failed    bool //if true, some lines failed to parse (then the checks at the end of the page are skipped, since they mostly report consequences of these errors)
+}
+
+// lspDocument is the result of analyzeDocument.
+type lspDocument struct {
+	Lines       []string
+	Tokens      [][]lspToken //per line
+	PageOfLine  []int        //index into Pages
+	Pages       []*lspPage
+	Diagnostics []lspDiagnostic
+}
+
+// analyzeDocument parses the text like Parse, but reports errors with
+// their line number and keeps going with the next line after an error.
+func analyzeDocument(text string) *lspDocument {
+	doc := &lspDocument{Lines: strings.Split(text, "\n")}
+	doc.Tokens = make([][]lspToken, len(doc.Lines))
+	doc.PageOfLine = make([]int, len(doc.Lines))
+	page := &lspPage{Parser: sequence.NewParser()}
+	inLegend := false
+
+	for idx, line := range doc.Lines {
+		line = strings.TrimSuffix(line, "\r")
+		doc.Lines[idx] = line
+		doc.Tokens[idx], inLegend = tokenizeLine(line, inLegend)
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "newpage" {
+			doc.finishPage(page, idx-1)
+			page = &lspPage{Parser: sequence.NewParser(), FirstLine: idx + 1}
+			doc.PageOfLine[idx] = len(doc.Pages)
+			continue
+		}
+		doc.PageOfLine[idx] = len(doc.Pages)
+		if err := page.Parser.ParseLine(line + "\n"); err != nil {
+			doc.addDiagnostic(idx, err.Error())
+			page.Failed = true
+			continue
+		}
+		for tokenIdx, token := range doc.Tokens[idx] {
+			if token.Defines {
+				doc.Tokens[idx][tokenIdx].Message = page.Parser.Diagram.Messages[token.Text]
+			}
+		}
+	}
+	doc.finishPage(page, len(doc.Lines)-1)
+	return doc
+}
+
+func (doc *lspDocument) finishPage(page *lspPage, lastLine int) {
+	page.LastLine = lastLine
+	doc.Pages = append(doc.Pages, page)
+	if page.Failed {
+		return
+	}
+	err := page.Parser.Finish()
+	if err == nil {
+		return
+	}
+	//errors at the end of the input are reported on the last non-empty line
+	line := lastLine
+	for line > page.FirstLine && strings.TrimSpace(doc.Lines[line]) == "" {
+		line--
+	}
+	if line >= 0 {
+		doc.addDiagnostic(line, err.Error())
+	}
+}
+
+func (doc *lspDocument) addDiagnostic(line int, msg string) {
+	doc.Diagnostics = append(doc.Diagnostics, lspDiagnostic{
+		Range:    doc.lineRange(line, 0, len(doc.Lines[line])),
+		Severity: 1, //error
+		Source:   "sequence-diagram",
+		Message:  msg,
+	})
+}
+
+// tokenizeLine splits a line into tokens and determines the role of each
+// token, following the same rules as Parser.parseLine. The second return
+// value tells whether the next line is within a legend block.
+func tokenizeLine(line string, inLegend bool) ([]lspToken, bool) {
+	if sequence.IsComment(line) {
+		return nil, inLegend
+	}
+	if inLegend {
+		tokens := splitTokens(line, 0)
+		if len(tokens) == 1 && tokens[0].Text == "end" {
+			tokens[0].Role = tokenCommand
+			return tokens, false
+		}
+		if len(tokens) > 0 {
+			tokens[0].Role = tokenActor
+		}
+		return tokens, true
+	}
+
+	var result []lspToken
+	offset := 0
+	for _, command := range strings.SplitAfter(line, ";") {
+		tokens := splitTokens(command, offset)
+		offset += len(command)
+		if len(tokens) > 0 && strings.HasPrefix(tokens[0].Text, "@") {
+			result = append(result, tokens[0])
+			tokens = tokens[1:]
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		tokens[0].Role = tokenCommand
+		if tokens[0].Text == "legend" {
+			inLegend = true
+		}
+		args := tokens[1:]
+		mark := func(idx, role int) {
+			if idx < len(args) {
+				args[idx].Role = role
+			}
+		}
+		switch tokens[0].Text {
+		case "start", "stop", "label", "style", "participant", "stereotype", "state":
+			mark(0, tokenActor)
+		case "send", "call", "return", "send!", "call!", "return!":
+			mark(0, tokenActor)
+			mark(1, tokenMessage)
+			if len(args) > 1 {
+				args[1].Defines = true
+			}
+			if strings.HasSuffix(tokens[0].Text, "!") {
+				mark(2, tokenActor)
+			}
+		case "receive":
+			mark(0, tokenActor)
+			mark(1, tokenMessage)
+		case "timeout":
+			mark(0, tokenMessage)
+			mark(1, tokenActor)
+		case "hide-return", "placement":
+			mark(0, tokenMessage)
+		case "timer", "coregion":
+			mark(1, tokenActor)
+		case "constraint", "before":
+			//only the message name of "<message>.send" or "<message>.receive" is a reference
+			for idx := 0; idx < 2 && idx < len(args); idx++ {
+				if dot := strings.LastIndex(args[idx].Text, "."); dot > 0 {
+					args[idx].Text = args[idx].Text[:dot]
+					args[idx].End = args[idx].Start + dot
+					args[idx].Role = tokenMessage
+				}
+			}
+		}
+		result = append(result, tokens...)
+	}
+	return result, inLegend
+}
+
+// splitTokens splits the text into whitespace-separated tokens. The offset is
+// added to all token positions.
+func splitTokens(text string, offset int) []lspToken {
+	var tokens []lspToken
+	start := -1
+	for idx := 0; idx <= len(text); idx++ {
+		isSpace := idx == len(text) || strings.ContainsRune(" \t\r\n;", rune(text[idx]))
+		switch {
+		case isSpace && start >= 0:
+			tokens = append(tokens, lspToken{Text: text[start:idx], Start: offset + start, End: offset + idx})
+			start = -1
+		case !isSpace && start < 0:
+			start = idx
+		}
+	}
+	return tokens
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// positions
+
+// lineRange converts a range of byte offsets within a line into an lspRange.
+func (doc *lspDocument) lineRange(line, start, end int) lspRange {
+	return lspRange{
+		Start: lspPosition{line, utf16Length(doc.Lines[line][:start])},
+		End:   lspPosition{line, utf16Length(doc.Lines[line][:end])},
+	}
+}
+
+func utf16Length(text string) int {
+	length := 0
+	for _, r := range text {
+		length++
+		if r >= 0x10000 {
+			length++ //surrogate pair
+		}
+	}
+	return length
+}
+
+// byteOffset converts an LSP position into a byte offset within its line.
+func (doc *lspDocument) byteOffset(pos lspPosition) int {
+	line := doc.Lines[pos.Line]
+	units := 0
+	for idx, r := range line {
+		if units >= pos.Character {
+			return idx
+		}
+		units += utf16Length(string(r))
+	}
+	return len(line)
+}
+
+// tokenAt returns the token at the given position, or nil if there is none.
+func (doc *lspDocument) tokenAt(pos lspPosition) *lspToken {
+	offset := doc.byteOffset(pos)
+	for idx, token := range doc.Tokens[pos.Line] {
+		if token.Start <= offset && offset <= token.End {
+			return &doc.Tokens[pos.Line][idx]
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// language features
+
+// findDefinition returns the line and token of the command that sent the
+// message (the last one before the given line, since message names can be
+// reused), or of the first mention of an actor, within the same page.
+func (doc *lspDocument) findDefinition(token *lspToken, line int) (int, *lspToken) {
+	page := doc.Pages[doc.PageOfLine[line]]
+	switch token.Role {
+	case tokenMessage:
+		for l := line; l >= page.FirstLine; l-- {
+			for idx := len(doc.Tokens[l]) - 1; idx >= 0; idx-- {
+				other := &doc.Tokens[l][idx]
+				if other.Defines && other.Text == token.Text && (l < line || other.Start <= token.Start) {
+					return l, other
+				}
+			}
+		}
+	case tokenActor:
+		for l := page.FirstLine; l <= page.LastLine && l < len(doc.Lines); l++ {
+			for idx, other := range doc.Tokens[l] {
+				if other.Role == tokenActor && other.Text == token.Text {
+					return l, &doc.Tokens[l][idx]
+				}
+			}
+		}
+	}
+	return -1, nil
+}
+
+func (doc *lspDocument) definition(uri string, pos lspPosition) interface{} {
+	token := doc.tokenAt(pos)
+	if token == nil {
+		return nil
+	}
+	line, def := doc.findDefinition(token, pos.Line)
+	if def == nil {
+		return nil
+	}
+	return map[string]interface{}{"uri": uri, "range": doc.lineRange(line, def.Start, def.End)}
+}
+
+func (doc *lspDocument) hover(pos lspPosition) interface{} {
+	token := doc.tokenAt(pos)
+	if token == nil {
+		return nil
+	}
+	var text string
+	switch token.Role {
+	case tokenMessage:
+		_, def := doc.findDefinition(token, pos.Line)
+		if def == nil || def.Message == nil {
+			return nil
+		}
+		msg := def.Message
+		text = fmt.Sprintf("**%s** `%s` from %s to %s", msg.Kind, msg.Name, msg.SenderName, orUnknown(msg.ReceiverName))
+		if msg.Label != "" {
+			text += ": " + msg.Label
+		}
+		text += fmt.Sprintf("\n\nsent at t=%d", msg.SenderTime)
+		switch {
+		case msg.TimedOut:
+			text += fmt.Sprintf(", timed out at t=%d", msg.ReceiverTime)
+		case msg.ReceiverName != "":
+			text += fmt.Sprintf(", received at t=%d", msg.ReceiverTime)
+		}
+	case tokenActor:
+		actor := doc.Pages[doc.PageOfLine[pos.Line]].Parser.Diagram.Actors[token.Text]
+		if actor == nil {
+			return nil
+		}
+		text = fmt.Sprintf("**actor** `%s`", actor.Name)
+		if actor.Label != actor.Name {
+			text += ": " + actor.Label
+		}
+		text += fmt.Sprintf("\n\nactivities: %d", len(actor.Activities))
+	default:
+		return nil
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": text},
+		"range":    doc.lineRange(pos.Line, token.Start, token.End),
+	}
+}
+
+func orUnknown(name string) string {
+	if name == "" {
+		return "(not received yet)"
+	}
+	return name
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// complete offers commands at the start of a command, and the names of all
+// actors and messages in the current page everywhere else.
+func (doc *lspDocument) complete(pos lspPosition) interface{} {
+	offset := doc.byteOffset(pos)
+	prefix := doc.Lines[pos.Line][:offset]
+	if idx := strings.LastIndex(prefix, ";"); idx >= 0 {
+		prefix = prefix[idx+1:]
+	}
+	fields := strings.Fields(prefix)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		fields = fields[1:]
+	}
+	atCommand := len(fields) == 0 || (len(fields) == 1 && !strings.HasSuffix(prefix, " ") && !strings.HasSuffix(prefix, "\t"))
+
+	items := []lspCompletionItem{}
+	if atCommand {
+		for _, command := range lspCommands {
+			items = append(items, lspCompletionItem{Label: command, Kind: 14}) //Keyword
+		}
+		return items
+	}
+
+	page := doc.Pages[doc.PageOfLine[pos.Line]]
+	seen := make(map[string]bool)
+	for l := page.FirstLine; l <= page.LastLine && l < len(doc.Lines); l++ {
+		for _, token := range doc.Tokens[l] {
+			key := strconv.Itoa(token.Role) + " " + token.Text
+			if seen[key] || (l == pos.Line && token.Start <= offset && offset <= token.End) {
+				continue
+			}
+			switch token.Role {
+			case tokenActor:
+				items = append(items, lspCompletionItem{Label: token.Text, Kind: 6, Detail: "actor"}) //Variable
+			case tokenMessage:
+				items = append(items, lspCompletionItem{Label: token.Text, Kind: 23, Detail: "message"}) //Event
+			default:
+				continue
+			}
+			seen[key] = true
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
@@ -20,365 +20,354 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
 	"strings"
-)
-
-type Actor struct {
-	Name          string
-	Label         string
-	DisplayOrder  uint
-	Activities    []*Activity
-	BlockedByCall string //during parsing, contains name of not-yet-answered synchronous message
-	ActivityCount uint   //during parsing, counts number of running activities
-}
-
-type Activity struct {
-	StartTime uint
-	StopTime  uint
-	//layout parameters
-	Layer uint
-}
-
-type Message struct {
-	Kind         string //command name that generated the message (one of "send", "call", "return")
-	Label        string
-	SenderName   string
-	ReceiverName string
-	SenderTime   uint
-	ReceiverTime uint
-	//layout parameters
-	SenderLayer   uint
-	ReceiverLayer uint
-}
+	"sync/atomic"
+	"time"
 
-const (
-	HeaderHeight          = 50
-	SwimlaneStep          = 25  //per unit of time
-	SwimlaneWidth         = 200 //per actor
-	LabelWidth            = SwimlaneWidth / 2
-	LabelHeight           = 20
-	ActivityWidth         = 20
-	ActivityOffset        = ActivityWidth / 2
-	ArrowTipSize          = 10
-	MessageFontSize       = 12
-	MessageBaselineOffset = 3
+	"github.com/johan48191/sequence-diagram/sequence"
 )
 
-func main() {
-	actors, messages := parse()
-
-	/* enable this for debugging * /
-	for name, actor := range actors {
-		fmt.Fprintf(os.Stderr, "actor %s = %#v\n", name, actor)
-		for idx, activity := range actor.Activities {
-			fmt.Fprintf(os.Stderr, "activity %d = %#v\n", idx, activity)
-		}
-	}
-	for name, message := range messages {
-		fmt.Fprintf(os.Stderr, "message %s = %#v\n", name, message)
-	}
-	/* */
+var (
+	outputFlag     = flag.String("o", "", "output file (default: stdout)")
+	jobsFlag       = flag.Int("j", runtime.NumCPU(), "batch: number of files to render concurrently")
+	cpuProfileFlag = flag.String("cpuprofile", "", "write a CPU profile into this file")
+	memProfileFlag = flag.String("memprofile", "", "write a heap profile into this file when done")
+	timingsFlag    = flag.Bool("timings", false, "report the duration of each processing phase and element counts on stderr")
+	quietFlag      = flag.Bool("quiet", false, "do not report warnings (errors are still reported)")
+	verboseFlag    = flag.Bool("verbose", false, "report progress on stderr, e.g. which files were written")
+	strictFlag     = flag.Bool("warnings-as-errors", false, "exit with status 1 if any warnings were reported")
+)
 
-	maxTime := getMaxTime(actors)
-	width := len(actors) * SwimlaneWidth
-	height := HeaderHeight + SwimlaneStep*(maxTime+2)
-	fmt.Printf(`<svg version="1.1" baseProfile="full" xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`,
-		width, height)
+// timings collects the data for -timings (nil if not requested). It is
+// shared with Options.Timings, but also measures the phases outside of the
+// library functions (parsing and output).
+var timings *sequence.Timings
 
-	fmt.Printf(`
-		<defs>
-			<marker id="normal" viewBox="0 0 10 10" refX="1" refY="5" markerWidth="%d" markerHeight="%d" orient="auto">
-				<path d="M 0 0 L 10 5 L 0 5 L 10 5 L 0 10" fill="none" stroke="black" />
-			</marker>
-			<marker id="filled" viewBox="0 0 10 10" refX="1" refY="5" markerWidth="%d" markerHeight="%d" orient="auto">
-				<path d="M 0 0 L 10 5 L 0 10 z" fill="black" />
-			</marker>
-		</defs>
-	`, ArrowTipSize, ArrowTipSize, ArrowTipSize, ArrowTipSize)
+// startJS is set by the WebAssembly build (see wasm.go) and replaces the
+// command-line interface.
+var startJS func()
 
-	for _, actor := range actors {
-		actor.drawSwimLane(maxTime)
-		for _, activity := range actor.Activities {
-			activity.drawBox(actor.DisplayOrder)
-		}
-	}
-	for _, message := range messages {
-		message.drawArrow(actors[message.SenderName], actors[message.ReceiverName])
+func main() {
+	if startJS != nil {
+		startJS()
+		return
 	}
-
-	fmt.Println(`</svg>`)
-}
-
-////////////////////////////////////////////////////////////////////////////////
-// parsing
-
-func parse() (actors map[string]*Actor, messages map[string]*Message) {
-	actors = make(map[string]*Actor)
-	messages = make(map[string]*Message)
-
-	r := bufio.NewReader(os.Stdin)
-	var time uint = 1
-
-	loop := true
-	for loop {
-		line, err := r.ReadString('\n')
-		if err == io.EOF {
-			loop = false //break after this iteration
-		} else {
-			failIfErr(err)
-		}
-
-		fields := strings.Fields(line)
-		if len(fields) == 0 {
-			//advance time on every empty line
-			time++
-			continue
-		}
-
-		switch fields[0] {
-		case "start":
-			parseStart(fields[1:], time, actors)
-		case "stop":
-			parseStop(fields[1:], time, actors)
-		case "label":
-			parseLabel(fields[1:], actors)
-		case "send", "call", "return":
-			parseSend(fields[1:], fields[0], time, actors, messages)
-		case "receive":
-			parseReceive(fields[1:], time, actors, messages)
+	defer exitOnFailure()
+	opts := sequence.DefaultOptions()
+	opts.AddFlags(flag.CommandLine)
+	flag.Parse()
+	setupLogging()
+	stopProfiling := startProfiling(opts)
+	defer stopProfiling()
+	if flag.NArg() > 0 {
+		args := flag.Args()
+		switch args[0] {
+		case "verify":
+			if len(args) != 2 {
+				fail("usage: %s verify <protocol-file> < <diagram-file>", os.Args[0])
+			}
+			for _, diagram := range parse(os.Stdin) {
+				verify(args[1], diagram.Messages)
+			}
+		case "diff":
+			if len(args) != 3 {
+				fail("usage: %s diff <old-diagram-file> <new-diagram-file>", os.Args[0])
+			}
+			diffFiles(args[1], args[2], opts, *outputFlag)
+		case "stream":
+			if len(args) != 2 {
+				fail("usage: %s stream <diagram-file>", os.Args[0])
+			}
+			streamFile(args[1], opts, *outputFlag)
+		case "merge":
+			if len(args) < 3 {
+				fail("usage: %s merge <diagram-file> <diagram-file>...", os.Args[0])
+			}
+			render(combineFiles(args[1:]), opts, *outputFlag)
+		case "watch":
+			if len(args) != 2 {
+				fail("usage: %s watch -o <output-file> <diagram-file>", os.Args[0])
+			}
+			watchFile(args[1], opts, *outputFlag)
+		case "batch":
+			if len(args) < 2 {
+				fail("usage: %s batch <diagram-file-or-directory>...", os.Args[0])
+			}
+			renderBatch(args[1:], opts)
+		case "export-html":
+			if len(args) != 2 {
+				fail("usage: %s export-html [-o <output-file>] <diagram-file>", os.Args[0])
+			}
+			exportHTML(args[1], opts, *outputFlag)
+		case "build":
+			if len(args) != 3 {
+				fail("usage: %s build <source-directory> <output-directory>", os.Args[0])
+			}
+			buildSite(args[1], args[2], opts)
+		case "serve":
+			if len(args) != 1 {
+				fail("usage: %s serve [-listen <address>]", os.Args[0])
+			}
+			serve(opts)
+		case "md":
+			if len(args) != 2 {
+				fail("usage: %s md [-md-images inline|files] [-o <output-file>] <markdown-file>", os.Args[0])
+			}
+			renderMarkdown(args[1], opts, *outputFlag)
+		case "confluence":
+			if len(args) != 1 {
+				fail("usage: %s confluence -o <attachment-file> < <diagram-file>", os.Args[0])
+			}
+			exportConfluence(os.Stdin, opts, *outputFlag)
+		case "accesslog":
+			if len(args) < 2 {
+				fail("usage: %s accesslog <access-log-file>...", os.Args[0])
+			}
+			importAccessLogs(args[1:], opts, *outputFlag)
+		case "tail":
+			if len(args) != 2 {
+				fail("usage: %s tail -rules <rules-file> -o <output-file> <log-file>", os.Args[0])
+			}
+			tailLog(args[1], opts, *outputFlag)
+		case "ingest":
+			ingestEvents(args[1:], opts, *outputFlag)
+		case "fmt":
+			formatFiles(args[1:])
+		case "check":
+			checkFiles(args[1:])
+		case "theme":
+			if len(args) != 2 || args[1] != "export" {
+				fail("usage: %s theme export [-theme-file <file>]", os.Args[0])
+			}
+			failIfErr(sequence.ExportTheme(os.Stdout, opts))
+		case "completion":
+			if len(args) != 2 {
+				fail("usage: %s completion bash|zsh|fish", os.Args[0])
+			}
+			printCompletion(args[1])
+		case "man":
+			printManPage(os.Stdout)
+		case "lsp":
+			runLSP(os.Stdin, os.Stdout)
+		case "mdbook":
+			runMdBook(args[1:], opts)
+		case "hugo":
+			switch len(args) {
+			case 1:
+				renderHugoSite(".", opts)
+			case 2:
+				renderHugoSite(args[1], opts)
+			default:
+				fail("usage: %s hugo [<site-directory>]", os.Args[0])
+			}
 		default:
-			fail("unknown command: %s", fields[0])
+			fail("unknown subcommand: %s", args[0])
 		}
+		return
 	}
 
-	for _, actor := range actors {
-		if actor.ActivityCount > 0 {
-			fail("actor %s has %d unfinished activities", actor.Name, actor.ActivityCount)
-		}
+	if *followFlag {
+		followInput(os.Stdin, opts, *outputFlag)
+		return
 	}
-	for name, message := range messages {
-		if message.ReceiverName == "" {
-			fail("message %s was not received by anyone", name)
-		}
+	if *lenientFlag {
+		render(parsePagesLeniently(os.Stdin), opts, *outputFlag)
+		return
 	}
+	render(parse(os.Stdin), opts, *outputFlag)
+}
 
-	return
+// render applies the actor options (-merge, -only, -hide, -focus) and -redact
+// to the diagrams and writes them in the output format into the given file
+// (or stdout, see writeOutput).
+func render(diagrams []*sequence.Diagram, opts *sequence.Options, outputPath string) {
+	//report overlaps to the author (but not in "serve", "stream" etc., which
+	//do not go through here)
+	renderOpts := *opts
+	renderOpts.WarnOverlaps = true
+	writeOutput(outputPath, renderDiagrams(diagrams, &renderOpts))
 }
 
-func makeActor(name string, actors map[string]*Actor) *Actor {
-	actor, exists := actors[name]
-	if !exists {
-		actor = &Actor{Name: name, Label: name, DisplayOrder: uint(len(actors))}
-		actors[name] = actor
-	}
-	return actor
+// exportHTML implements the "export-html" subcommand (see ExportHTML).
+func exportHTML(path string, opts *sequence.Options, outputPath string) {
+	source, err := os.ReadFile(path)
+	failIfErr(err)
+	htmlOpts := *opts
+	htmlOpts.WarnOverlaps = true
+	pages, err := sequence.ExportHTML(source, &htmlOpts)
+	failIfErr(err)
+	writeOutput(outputPath, pages)
 }
 
-func parseStart(args []string, time uint, actors map[string]*Actor) {
-	if len(args) != 1 {
-		fail("wrong number of arguments for 'start': expected 1, got %d", len(args))
-	}
-	actor := makeActor(args[0], actors)
-	activity := &Activity{StartTime: time, Layer: actor.ActivityCount}
-	actor.Activities = append(actor.Activities, activity)
-	actor.ActivityCount++
+// parse is Parse for the subcommands, which fail on errors in the input.
+func parse(r io.Reader) []*sequence.Diagram {
+	defer timings.Measure("parse")()
+	diagrams, err := sequence.Parse(r)
+	failIfErr(err)
+	return diagrams
 }
 
-func parseStop(args []string, time uint, actors map[string]*Actor) {
-	if len(args) != 1 {
-		fail("wrong number of arguments for 'start': expected 1, got %d", len(args))
-	}
-	actor := makeActor(args[0], actors)
+// renderDiagrams is the part of render() before writing the output.
+func renderDiagrams(diagrams []*sequence.Diagram, opts *sequence.Options) []sequence.Page {
+	pages, err := sequence.Render(diagrams, opts)
+	failIfErr(err)
+	return pages
+}
 
-	var activityToStop *Activity
-	for _, a := range actor.Activities {
-		if a.StopTime == 0 {
-			activityToStop = a
-		}
-	}
-	if activityToStop == nil {
-		fail("cannot stop actor %s: not active", actor.Name)
+// writeOutput writes the pages into the given file (or stdout if empty). If
+// there are multiple pages, they are written into separate files (with the
+// page number inserted before the file extension), or onto stdout separated
+// by PageDelimiter.
+func writeOutput(outputPath string, pages []sequence.Page) {
+	defer timings.Measure("output")()
+	//all renderers write many small pieces, so output is always buffered
+	if outputPath == "" {
+		out := bufio.NewWriter(os.Stdout)
+		failIfErr(sequence.WritePages(out, pages))
+		failIfErr(out.Flush())
+		slog.Info("wrote output", "path", "stdout", "pages", len(pages))
+		return
 	}
 
-	activityToStop.StopTime = time
-	actor.ActivityCount--
+	for idx, page := range pages {
+		file, err := os.Create(pagePath(outputPath, idx, len(pages)))
+		failIfErr(err)
+		out := bufio.NewWriter(file)
+		failIfErr(page(out))
+		failIfErr(out.Flush())
+		failIfErr(file.Close())
+		slog.Info("wrote output", "path", file.Name())
+	}
 }
 
-func parseLabel(args []string, actors map[string]*Actor) {
-	if len(args) < 2 {
-		fail("wrong number of arguments for 'label': expected 2, got %d", len(args))
+// pagePath returns the path of the file into which writeOutput writes the
+// page with the given index.
+func pagePath(outputPath string, idx, count int) string {
+	if count == 1 {
+		return outputPath
 	}
-	actor := makeActor(args[0], actors)
-	actor.Label = strings.Join(args[1:], " ")
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(outputPath, ext), idx+1, ext)
 }
 
-func parseSend(args []string, kind string, time uint, actors map[string]*Actor, messages map[string]*Message) {
-	if len(args) < 3 {
-		fail("wrong number of arguments for '%s': expected 3, got %d", kind, len(args))
-	}
-	sender := makeActor(args[0], actors)
+////////////////////////////////////////////////////////////////////////////////
+// utilities
 
-	name := args[1]
-	if _, exists := messages[name]; exists {
-		fail("cannot send message %s multiple times", name)
-	}
-	if sender.BlockedByCall != "" {
-		fail("actor %s cannot send message %s while waiting for response to %s", sender.Name, name, sender.BlockedByCall)
-	}
+// Exit codes of the program (see also the man page).
+const (
+	ExitWarnings     = 1 //warnings were reported, and -warnings-as-errors is set
+	ExitInvalidInput = 2 //parse errors, invalid arguments and other problems with the input
+	ExitIOError      = 3 //errors while reading input or writing output
+)
 
-	if sender.ActivityCount == 0 {
-		fail("actor %s cannot send message %s while not active", sender.Name, name)
-	}
+// failure is the panic value used by fail() and failIfErr(). It is recovered
+// by exitOnFailure() in main(), or by catchFailure() where processing shall
+// continue after an error (e.g. for other files in batch mode).
+type failure struct {
+	Message  string
+	ExitCode int
+}
 
-	messages[name] = &Message{
-		Kind:        kind,
-		Label:       strings.Join(args[2:], " "),
-		SenderName:  sender.Name,
-		SenderTime:  time,
-		SenderLayer: sender.ActivityCount - 1,
-	}
-	switch kind {
-	case "call":
-		sender.BlockedByCall = name
-	case "return":
-		parseStop([]string{sender.Name}, time, actors)
+func fail(msg string, args ...interface{}) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
 	}
+	panic(failure{msg, ExitInvalidInput})
 }
 
-func parseReceive(args []string, time uint, actors map[string]*Actor, messages map[string]*Message) {
-	if len(args) != 2 {
-		fail("wrong number of arguments for 'stop': expected 2, got %d", len(args))
-	}
-	receiver := makeActor(args[0], actors)
-	name := args[1]
-	msg, exists := messages[name]
-	if !exists {
-		fail("cannot receive message %s: has not been sent yet", name)
+// warn reports a warning through the logger (see setupLogging).
+func warn(msg string, args ...interface{}) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
 	}
+	slog.Warn(msg)
+}
 
-	if receiver.BlockedByCall == "" {
-		if msg.Kind == "return" {
-			fail("actor %s cannot receive return message without having made a call", receiver.Name)
-		}
-	} else {
-		if msg.Kind != "return" {
-			fail("actor %s cannot receive message %s while waiting for response to %s",
-				receiver.Name, name, receiver.BlockedByCall)
-		}
-		called := messages[receiver.BlockedByCall].ReceiverName
-		if called != msg.SenderName {
-			fail("actor %s cannot receive response to message %s from actor %s (expected actor %s)",
-				receiver.Name, receiver.BlockedByCall, msg.SenderName, called,
-			)
+// failIfErr fails if there is an error. Errors about the input (see
+// InputError) exit with ExitInvalidInput, all others (e.g. from the operating
+// system) with ExitIOError.
+func failIfErr(err error) {
+	if err != nil {
+		code := ExitIOError
+		var inputErr *sequence.InputError
+		if errors.As(err, &inputErr) {
+			code = ExitInvalidInput
 		}
-		receiver.BlockedByCall = ""
+		panic(failure{err.Error(), code})
 	}
+}
 
-	if msg.Kind == "call" {
-		parseStart([]string{receiver.Name}, time, actors)
+// exitOnFailure reports a failure and exits with its exit code, or with
+// ExitWarnings if appropriate. Must be deferred.
+func exitOnFailure() {
+	if r := recover(); r != nil {
+		f, ok := r.(failure)
+		if !ok {
+			panic(r)
+		}
+		slog.Error(f.Message)
+		os.Exit(f.ExitCode)
 	}
-
-	if receiver.ActivityCount == 0 {
-		fail("actor %s cannot receive message %s while not active", receiver.Name, name)
+	if count := atomic.LoadInt64(&warningCount); *strictFlag && count > 0 {
+		slog.Error(fmt.Sprintf("%d warning(s) treated as errors", count))
+		os.Exit(ExitWarnings)
 	}
-
-	msg.ReceiverName = receiver.Name
-	msg.ReceiverTime = time
-	msg.ReceiverLayer = receiver.ActivityCount - 1
 }
 
-////////////////////////////////////////////////////////////////////////////////
-// layout calculations
-
-func getMaxTime(actors map[string]*Actor) (max uint) {
-	for _, actor := range actors {
-		for _, activity := range actor.Activities {
-			if max < activity.StopTime {
-				max = activity.StopTime
+// catchFailure runs the given function and returns the message of its failure
+// (or "" if it succeeded).
+func catchFailure(action func()) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(failure)
+			if !ok {
+				panic(r)
 			}
+			msg = f.Message
 		}
-	}
-	return
-}
-
-////////////////////////////////////////////////////////////////////////////////
-// rendering
-
-func (actor *Actor) drawSwimLane(maxTime uint) {
-	x := actor.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2
-	fmt.Printf(`<rect x="%d" y="%d" width="%d" height="%d" stroke="black" fill="white" />`,
-		x-LabelWidth/2, HeaderHeight-LabelHeight, LabelWidth, LabelHeight,
-	)
-	fmt.Printf(`<text x="%d" y="%g" font-size="%g" text-anchor="middle">%s</text>`,
-		x, HeaderHeight-0.25*LabelHeight, 0.7*LabelHeight, actor.Label,
-	)
-	fmt.Printf(`<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="black" stroke-dasharray="5,5" />`,
-		x, x, HeaderHeight, HeaderHeight+(maxTime+1)*SwimlaneStep,
-	)
-}
-
-func (activity *Activity) drawBox(actorDisplayOrder uint) {
-	x := actorDisplayOrder*SwimlaneWidth + SwimlaneWidth/2 + activity.Layer*ActivityOffset
-	yStart := HeaderHeight + SwimlaneStep*activity.StartTime
-	yStop := HeaderHeight + SwimlaneStep*activity.StopTime
-	fmt.Printf(`<rect x="%d" y="%d" width="%d" height="%d" stroke="black" fill="white" />`,
-		x-ActivityWidth/2, yStart, ActivityWidth, yStop-yStart,
-	)
+	}()
+	action()
+	return ""
 }
 
-func (message *Message) drawArrow(sender *Actor, receiver *Actor) {
-	x1 := sender.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2 + message.SenderLayer*ActivityOffset
-	x2 := receiver.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2 + message.ReceiverLayer*ActivityOffset
-	y1 := HeaderHeight + SwimlaneStep*message.SenderTime
-	y2 := HeaderHeight + SwimlaneStep*message.ReceiverTime
-	var xText uint
-	if sender.DisplayOrder < receiver.DisplayOrder {
-		x1 += ActivityWidth / 2
-		x2 -= ActivityWidth / 2
-		x2 -= ArrowTipSize
-		xText = sender.DisplayOrder*SwimlaneWidth + SwimlaneWidth
-	} else {
-		x1 -= ActivityWidth / 2
-		x2 += ActivityWidth / 2
-		x2 += ArrowTipSize
-		xText = sender.DisplayOrder * SwimlaneWidth
-	}
-
-	opts := ""
-	if message.Kind == "return" {
-		opts += `stroke-dasharray="5,5"`
+// startProfiling implements -cpuprofile, -memprofile and -timings. The
+// returned function must be called when the program is done.
+func startProfiling(opts *sequence.Options) func() {
+	if *timingsFlag {
+		timings = sequence.NewTimings()
+		opts.Timings = timings
 	}
-	marker := "normal"
-	if message.Kind == "call" {
-		marker = "filled"
+	if *cpuProfileFlag != "" {
+		file, err := os.Create(*cpuProfileFlag)
+		failIfErr(err)
+		failIfErr(pprof.StartCPUProfile(file))
 	}
+	start := time.Now()
 
-	fmt.Printf(`<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="black" marker-end="url(#%s)" %s/>`,
-		x1, x2, y1, y2, marker, opts,
-	)
-	//TODO: use <textPath> for asynchronous messages
-	fmt.Printf(`<text x="%d" y="%d" font-size="%d" text-anchor="middle">%s</text>`,
-		xText, y1-MessageBaselineOffset, MessageFontSize, message.Label,
-	)
-}
-
-////////////////////////////////////////////////////////////////////////////////
-// utilities
-
-func fail(msg string, args ...interface{}) {
-	if len(args) > 0 {
-		msg = fmt.Sprintf(msg, args...)
-	}
-	fmt.Fprintln(os.Stderr, msg)
-	os.Exit(1)
-}
-
-func failIfErr(err error) {
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+	return func() {
+		if *cpuProfileFlag != "" {
+			pprof.StopCPUProfile()
+		}
+		if *memProfileFlag != "" {
+			file, err := os.Create(*memProfileFlag)
+			failIfErr(err)
+			runtime.GC() //get up-to-date statistics
+			failIfErr(pprof.WriteHeapProfile(file))
+			failIfErr(file.Close())
+		}
+		if timings != nil {
+			timings.Phases = append(timings.Phases, "total")
+			timings.Durations["total"] = time.Since(start)
+			timings.Print(os.Stderr)
+		}
 	}
 }
@@ -0,0 +1,162 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+var mdImagesFlag = flag.String("md-images", "inline", "md: how to embed the rendered diagrams: inline (as data URIs) or files (SVG files next to the Markdown output)")
+
+// MarkdownInfoString is the info string of fenced code blocks in Markdown
+// files that are rendered by the "md" subcommand.
+const MarkdownInfoString = "sequence"
+
+// markdownFence is a fenced code block in a Markdown file.
+type markdownFence struct {
+	Marker   string //e.g. "```" or "~~~~"; the closing fence must be at least this long
+	Line     int    //line number of the opening fence
+	Sequence bool   //whether the info string is MarkdownInfoString
+	Body     []string
+}
+
+// parseOpeningFence returns the fence if the line opens a fenced code block,
+// or nil otherwise.
+func parseOpeningFence(line string, lineNo int) *markdownFence {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return nil //indented code block
+	}
+	for _, char := range []string{"`", "~"} {
+		marker := trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, char))]
+		if len(marker) < 3 {
+			continue
+		}
+		info := strings.Fields(trimmed[len(marker):])
+		return &markdownFence{
+			Marker:   marker,
+			Line:     lineNo,
+			Sequence: len(info) > 0 && info[0] == MarkdownInfoString,
+		}
+	}
+	return nil
+}
+
+func (fence markdownFence) isClosedBy(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, fence.Marker) && strings.Trim(line, fence.Marker[:1]) == ""
+}
+
+// renderMarkdown copies the given Markdown file into the output file (or
+// stdout), replacing the diagrams with images (see rewriteMarkdown). With
+// "-md-images files", the images are written into SVG files next to the
+// output file (or next to the input file when writing to stdout), named after
+// that file.
+func renderMarkdown(path string, opts *sequence.Options, outputPath string) {
+	switch *mdImagesFlag {
+	case "inline", "files":
+	default:
+		fail("unknown value for -md-images: %s", *mdImagesFlag)
+	}
+	imageBase := path
+	if outputPath != "" {
+		imageBase = outputPath
+	}
+	imageBase = strings.TrimSuffix(imageBase, filepath.Ext(imageBase))
+
+	input, err := os.ReadFile(path)
+	failIfErr(err)
+	output := rewriteMarkdown(string(input), path, opts, func(number int, svg []byte) string {
+		if *mdImagesFlag == "inline" {
+			return dataURI(svg)
+		}
+		imagePath := fmt.Sprintf("%s-%d.svg", imageBase, number)
+		failIfErr(os.WriteFile(imagePath, svg, 0666))
+		return filepath.Base(imagePath)
+	})
+
+	if outputPath == "" {
+		_, err = os.Stdout.Write([]byte(output))
+		failIfErr(err)
+		return
+	}
+	failIfErr(os.WriteFile(outputPath, []byte(output), 0666))
+}
+
+func dataURI(svg []byte) string {
+	return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svg)
+}
+
+// rewriteMarkdown replaces each fenced code block with the info string
+// "sequence" (but not those nested in other code blocks) by an image of the
+// diagram (one image per page). The image target is chosen by the embed
+// callback, which receives the image number (counting from 1) and the SVG.
+// The name of the Markdown document is used in error messages.
+func rewriteMarkdown(input, name string, opts *sequence.Options, embed func(number int, svg []byte) string) string {
+	svgOpts := *opts
+	svgOpts.Format = "svg"
+
+	var out bytes.Buffer
+	var fence *markdownFence
+	imageCount := 0
+	lines := strings.SplitAfter(input, "\n")
+	for idx, line := range lines {
+		switch {
+		case fence == nil:
+			fence = parseOpeningFence(line, idx+1)
+			if fence == nil || !fence.Sequence {
+				out.WriteString(line)
+			}
+		case !fence.Sequence:
+			out.WriteString(line)
+			if fence.isClosedBy(line) {
+				fence = nil
+			}
+		case fence.isClosedBy(line):
+			var pages []sequence.Page
+			msg := catchFailure(func() {
+				pages = renderDiagrams(parse(strings.NewReader(strings.Join(fence.Body, ""))), &svgOpts)
+			})
+			if msg != "" {
+				fail("%s:%d: %s", name, fence.Line, msg)
+			}
+			for _, page := range pages {
+				imageCount++
+				var svg bytes.Buffer
+				failIfErr(page(&svg))
+				fmt.Fprintf(&out, "![sequence diagram %d](%s)\n", imageCount, embed(imageCount, svg.Bytes()))
+			}
+			fence = nil
+		default:
+			fence.Body = append(fence.Body, line)
+		}
+	}
+	if fence != nil && fence.Sequence {
+		fail("%s:%d: unterminated code block", name, fence.Line)
+	}
+	return out.String()
+}
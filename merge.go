@@ -0,0 +1,49 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import "github.com/johan48191/sequence-diagram/sequence"
+
+// combineFiles merges the diagrams from several input files into one. If the
+// files contain multiple pages, the n-th pages of all files are merged with
+// each other.
+func combineFiles(paths []string) []*sequence.Diagram {
+	var inputs [][]*sequence.Diagram
+	for _, path := range paths {
+		diagrams := parseFile(path)
+		if len(inputs) > 0 && len(diagrams) != len(inputs[0]) {
+			fail("cannot merge %s and %s: different number of pages (%d vs. %d)",
+				paths[0], path, len(inputs[0]), len(diagrams),
+			)
+		}
+		inputs = append(inputs, diagrams)
+	}
+
+	result := make([]*sequence.Diagram, len(inputs[0]))
+	for idx := range result {
+		parts := make([]*sequence.Diagram, len(inputs))
+		for fileIdx, diagrams := range inputs {
+			parts[fileIdx] = diagrams[idx]
+		}
+		combined, err := sequence.Combine(parts)
+		failIfErr(err)
+		result[idx] = combined
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package otelexporter
+
+import (
+	"errors"
+	"net/url"
+
+	"go.opentelemetry.io/collector/config/configopaque"
+)
+
+// Config is the configuration of the exporter, e.g.
+//
+//	exporters:
+//	  sequencediagram:
+//	    directory: /var/lib/diagrams
+//	    endpoint: http://localhost:8080
+//	    token: ${env:SEQUENCE_DIAGRAM_TOKEN}
+type Config struct {
+	//Directory receives one file per trace, named after the trace ID.
+	Directory string `mapstructure:"directory"`
+	//Endpoint is the base URL of a "sequence-diagram serve" instance. If set,
+	//the diagrams are rendered by its /render API and written as SVG files;
+	//otherwise their sources are written.
+	Endpoint string `mapstructure:"endpoint"`
+	//Token is sent as "Authorization: Bearer <token>" to the endpoint, for
+	//servers that require API tokens (see -auth-tokens-file).
+	Token configopaque.String `mapstructure:"token"`
+	//Headers are added to each request to the endpoint.
+	Headers map[string]configopaque.String `mapstructure:"headers"`
+	//RootCaller is the actor that calls the service of the root span.
+	RootCaller string `mapstructure:"root_caller"`
+}
+
+// Validate implements the component.ConfigValidator interface.
+func (cfg *Config) Validate() error {
+	if cfg.Directory == "" {
+		return errors.New("directory must be set")
+	}
+	if cfg.Endpoint != "" {
+		if _, err := url.Parse(cfg.Endpoint); err != nil {
+			return err
+		}
+	} else if cfg.Token != "" || len(cfg.Headers) > 0 {
+		return errors.New("token and headers require an endpoint")
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package otelexporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/johan48191/sequence-diagram/record"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+type diagramExporter struct {
+	Config *Config
+	Client *http.Client
+}
+
+func (e *diagramExporter) pushTraces(ctx context.Context, td ptrace.Traces) error {
+	traces := make(map[string][]record.Span)
+	resourceSpans := td.ResourceSpans()
+	for i := 0; i < resourceSpans.Len(); i++ {
+		service := "unknown"
+		if value, exists := resourceSpans.At(i).Resource().Attributes().Get("service.name"); exists {
+			service = value.AsString()
+		}
+		scopeSpans := resourceSpans.At(i).ScopeSpans()
+		for j := 0; j < scopeSpans.Len(); j++ {
+			spans := scopeSpans.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				span := spans.At(k)
+				s := record.Span{
+					ID:      span.SpanID().String(),
+					Service: service,
+					Name:    span.Name(),
+					Start:   span.StartTimestamp().AsTime(),
+					End:     span.EndTimestamp().AsTime(),
+					Status:  statusLabel(span.Status()),
+				}
+				if !span.ParentSpanID().IsEmpty() {
+					s.ParentID = span.ParentSpanID().String()
+				}
+				traceID := span.TraceID().String()
+				traces[traceID] = append(traces[traceID], s)
+			}
+		}
+	}
+
+	var errs []error
+	for traceID, spans := range traces {
+		err := e.writeTrace(ctx, traceID, spans)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("trace %s: %w", traceID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func statusLabel(status ptrace.Status) string {
+	switch status.Code() {
+	case ptrace.StatusCodeOk:
+		return "OK"
+	case ptrace.StatusCodeError:
+		if status.Message() == "" {
+			return "Error"
+		}
+		return "Error: " + status.Message()
+	default:
+		return ""
+	}
+}
+
+// writeTrace writes the diagram source of the trace, or the SVG rendered by
+// the server if an endpoint is configured.
+func (e *diagramExporter) writeTrace(ctx context.Context, traceID string, spans []record.Span) error {
+	rec := record.New()
+	rec.RecordTrace(spans, e.Config.RootCaller)
+	var source bytes.Buffer
+	_, err := rec.WriteTo(&source)
+	if err != nil {
+		return err
+	}
+	if e.Config.Endpoint == "" {
+		return os.WriteFile(filepath.Join(e.Config.Directory, traceID+".txt"), source.Bytes(), 0666)
+	}
+
+	url := strings.TrimSuffix(e.Config.Endpoint, "/") + "/render?format=svg"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &source)
+	if err != nil {
+		return err
+	}
+	for key, value := range e.Config.Headers {
+		req.Header.Set(key, string(value))
+	}
+	if e.Config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+string(e.Config.Token))
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	svg, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s returned %s: %s", url, resp.Status, bytes.TrimSpace(svg))
+	}
+	return os.WriteFile(filepath.Join(e.Config.Directory, traceID+".svg"), svg, 0666)
+}
@@ -0,0 +1,54 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+// Package otelexporter is an OpenTelemetry collector exporter that turns each
+// trace into a sequence diagram of the calls between its services (see
+// record.RecordTrace). Since spans of the same trace may arrive in different
+// batches, the pipeline should group them with the groupbytrace processor.
+//
+// This is a separate module, since it depends on the collector libraries.
+package otelexporter
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/exporter"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+)
+
+var componentType = component.MustNewType("sequencediagram")
+
+// NewFactory creates the factory for the exporter.
+func NewFactory() exporter.Factory {
+	return exporter.NewFactory(
+		componentType,
+		createDefaultConfig,
+		exporter.WithTraces(createTracesExporter, component.StabilityLevelAlpha),
+	)
+}
+
+func createDefaultConfig() component.Config {
+	return &Config{RootCaller: "client"}
+}
+
+func createTracesExporter(ctx context.Context, set exporter.Settings, cfg component.Config) (exporter.Traces, error) {
+	e := &diagramExporter{Config: cfg.(*Config), Client: http.DefaultClient}
+	return exporterhelper.NewTraces(ctx, set, cfg, e.pushTraces)
+}
@@ -0,0 +1,164 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// handlePlayground serves a page where the input language can be tried out
+// in the browser: a text area for the source, a live preview that is
+// rendered by the /render endpoint, a panel for errors, and buttons for
+// downloading the source and the rendered outputs. The page does not contain
+// anything secret, so it is only subject to -allow-networks. If the server
+// requires API tokens, the token can be entered in the page.
+func (s *renderServer) handlePlayground(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, playgroundHTML)
+}
+
+const playgroundHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sequence diagram playground</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; flex-direction: column; height: 100vh; }
+header { padding: 0.5em 1em; border-bottom: 1px solid #ddd; display: flex; gap: 0.5em; align-items: center; flex-wrap: wrap; }
+header h1 { font-size: 1.2em; margin: 0 1em 0 0; }
+main { flex: 1; display: flex; min-height: 0; }
+textarea { width: 35%; border: none; border-right: 1px solid #ddd; padding: 1em; font-family: monospace; font-size: 14px; resize: none; }
+#preview { flex: 1; overflow: auto; padding: 1em; }
+#errors { padding: 0.5em 1em; background: #fdd; color: #900; font-family: monospace; white-space: pre-wrap; display: none; }
+#errors.visible { display: block; }
+</style>
+</head>
+<body>
+<header>
+<h1>Sequence diagram playground</h1>
+<button type="button" data-download="source">Download source</button>
+<button type="button" data-download="svg">Download SVG</button>
+<button type="button" data-download="html">Download HTML</button>
+<label>API token <input type="password" id="token" size="16" placeholder="(if required)"></label>
+</header>
+<div id="errors"></div>
+<main>
+<textarea id="source" spellcheck="false">start user
+start server
+send user request GET /
+
+receive server request
+
+send server response 200 OK
+
+receive user response
+
+stop user
+stop server
+</textarea>
+<div id="preview"></div>
+</main>
+<script>
+(function() {
+  var source = document.getElementById("source");
+  var preview = document.getElementById("preview");
+  var errors = document.getElementById("errors");
+  var token = document.getElementById("token");
+  token.value = localStorage.getItem("sequence-diagram-token") || "";
+  if (localStorage.getItem("sequence-diagram-source")) {
+    source.value = localStorage.getItem("sequence-diagram-source");
+  }
+
+  //render the source in the given format; resolves to the output, or rejects with the error message
+  function render(format) {
+    var headers = {"Content-Type": "text/plain"};
+    if (token.value) {
+      headers["Authorization"] = "Bearer " + token.value;
+    }
+    return fetch("render?format=" + format, {method: "POST", headers: headers, body: source.value}).then(function(response) {
+      return response.text().then(function(text) {
+        if (!response.ok) {
+          throw new Error(text.trim() || response.statusText);
+        }
+        return text;
+      });
+    });
+  }
+
+  var timer = null, generation = 0;
+  function update() {
+    localStorage.setItem("sequence-diagram-source", source.value);
+    localStorage.setItem("sequence-diagram-token", token.value);
+    var current = ++generation;
+    render("svg").then(function(svg) {
+      if (current !== generation) return; //a newer render was started meanwhile
+      preview.innerHTML = svg;
+      errors.classList.remove("visible");
+    }, function(err) {
+      if (current !== generation) return;
+      //keep the last good preview, so that it does not flicker while typing
+      errors.textContent = err.message;
+      errors.classList.add("visible");
+    });
+  }
+  function scheduleUpdate() {
+    clearTimeout(timer);
+    timer = setTimeout(update, 300);
+  }
+  source.addEventListener("input", scheduleUpdate);
+  token.addEventListener("change", update);
+  update();
+
+  function download(name, type, content) {
+    var a = document.createElement("a");
+    a.href = URL.createObjectURL(new Blob([content], {type: type}));
+    a.download = name;
+    a.click();
+    URL.revokeObjectURL(a.href);
+  }
+  var types = {svg: "image/svg+xml", html: "text/html"};
+  document.querySelectorAll("button[data-download]").forEach(function(button) {
+    button.addEventListener("click", function() {
+      var format = button.dataset.download;
+      if (format === "source") {
+        download("diagram.seq", "text/plain", source.value);
+        return;
+      }
+      render(format).then(function(output) {
+        download("diagram." + format, types[format], output);
+      }, function(err) {
+        errors.textContent = err.message;
+        errors.classList.add("visible");
+      });
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,196 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+// Package grpcrecord provides gRPC interceptors that record calls with a
+// record.Recorder. Because it depends on google.golang.org/grpc, this package
+// is a separate module, so that the sequence-diagram module itself does not
+// require gRPC.
+//
+// Unary RPCs are recorded as calls with the full method name as label, and the
+// status code as the label of the return. For streaming RPCs, each message on
+// the stream is recorded as a separate message (labelled with its type), so
+// the opening call is usually shown as asynchronous (see record.WriteTo).
+package grpcrecord
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/johan48191/sequence-diagram/record"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//same meaning as the HTTP headers used by record.Transport
+	callerKey   = "x-sequence-diagram-caller"
+	recorderKey = "x-sequence-diagram-recorder"
+)
+
+// UnaryClientInterceptor records unary RPCs as calls from the caller to the
+// service at the target of the client connection.
+func UnaryClientInterceptor(rec *record.Recorder, caller string, services record.Services) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, callerKey, caller, recorderKey, rec.ID())
+		call := rec.Call(caller, services.Lookup(cc.Target()), methodLabel(method))
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		call.Return(statusLabel(err))
+		return err
+	}
+}
+
+// StreamClientInterceptor records streaming RPCs like UnaryClientInterceptor,
+// plus each message that is sent or received on the stream.
+func StreamClientInterceptor(rec *record.Recorder, caller string, services record.Services) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, callerKey, caller, recorderKey, rec.ID())
+		server := services.Lookup(cc.Target())
+		call := rec.Call(caller, server, methodLabel(method))
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			call.Return(statusLabel(err))
+			return nil, err
+		}
+		return &clientStream{stream, rec, call, caller, server}, nil
+	}
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	rec    *record.Recorder
+	call   *record.Call
+	caller string
+	server string
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.rec.Send(s.caller, s.server, messageLabel(m))
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch err {
+	case nil:
+		s.rec.Send(s.server, s.caller, messageLabel(m))
+	case io.EOF:
+		s.call.Return(statusLabel(nil))
+	default:
+		s.call.Return(statusLabel(err))
+	}
+	return err
+}
+
+// UnaryServerInterceptor records unary RPCs as calls to the given service.
+// The caller is taken from the metadata set by UnaryClientInterceptor, or else
+// found by looking up the peer address in the services. RPCs that have
+// already been recorded by a client interceptor with the same Recorder are not
+// recorded again.
+func UnaryServerInterceptor(rec *record.Recorder, service string, services record.Services) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		caller, recorded := findCaller(ctx, rec, services)
+		if recorded {
+			return handler(ctx, req)
+		}
+		call := rec.Call(caller, service, methodLabel(info.FullMethod))
+		resp, err := handler(ctx, req)
+		call.Return(statusLabel(err))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records streaming RPCs like UnaryServerInterceptor,
+// plus each message that is sent or received on the stream.
+func StreamServerInterceptor(rec *record.Recorder, service string, services record.Services) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		caller, recorded := findCaller(ss.Context(), rec, services)
+		if recorded {
+			return handler(srv, ss)
+		}
+		call := rec.Call(caller, service, methodLabel(info.FullMethod))
+		err := handler(srv, &serverStream{ss, rec, caller, service})
+		call.Return(statusLabel(err))
+		return err
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	rec     *record.Recorder
+	caller  string
+	service string
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.rec.Send(s.service, s.caller, messageLabel(m))
+	}
+	return err
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.rec.Send(s.caller, s.service, messageLabel(m))
+	}
+	return err
+}
+
+// findCaller returns the name of the caller of an incoming RPC, and whether
+// the RPC has already been recorded by the same Recorder.
+func findCaller(ctx context.Context, rec *record.Recorder, services record.Services) (caller string, recorded bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(recorderKey); len(values) > 0 && values[0] == rec.ID() {
+		return "", true
+	}
+	if values := md.Get(callerKey); len(values) > 0 && values[0] != "" {
+		return values[0], false
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		return services.Lookup(p.Addr.String()), false
+	}
+	return "client", false
+}
+
+// methodLabel turns "/package.Service/Method" into "package.Service/Method".
+func methodLabel(method string) string {
+	return strings.TrimPrefix(method, "/")
+}
+
+// messageLabel returns the type name of a stream message (e.g. "pb.Item").
+func messageLabel(m interface{}) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", m), "*")
+}
+
+// statusLabel describes the outcome of an RPC, e.g. "OK" or "NotFound: no such user".
+func statusLabel(err error) string {
+	if err == nil {
+		return codes.OK.String()
+	}
+	s := status.Convert(err)
+	return s.Code().String() + ": " + s.Message()
+}
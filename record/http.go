@@ -0,0 +1,162 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package record
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Services maps network addresses to service names. Keys can be either "host"
+// or "host:port" (where the latter takes precedence). Addresses that are not
+// listed are shown as they are.
+type Services map[string]string
+
+// Lookup returns the service name for the given address.
+func (s Services) Lookup(address string) string {
+	if name, exists := s.find(address); exists {
+		return name
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		//for remote addresses, the port is usually ephemeral and not helpful
+		return host
+	}
+	return address
+}
+
+// find returns the service name for the given address, if it is listed.
+func (s Services) find(address string) (string, bool) {
+	if name, exists := s[address]; exists {
+		return name, true
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if name, exists := s[host]; exists {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+const (
+	//set by Transport to tell Middleware who the caller is
+	callerHeader = "X-Sequence-Diagram-Caller"
+	//set by Transport to tell Middleware that the request has already been
+	//recorded by the same Recorder
+	recorderHeader = "X-Sequence-Diagram-Recorder"
+)
+
+// Transport is an http.RoundTripper that records each request as a call from
+// the Caller to the service that the request is sent to.
+//
+// Requests to services that are listed in Services carry headers that tell
+// the Middleware of the service who the caller is. Requests to other hosts
+// (e.g. third-party APIs) are recorded, but sent unchanged.
+type Transport struct {
+	Recorder *Recorder
+	Caller   string            //service name of the client
+	Services Services          //to find the service name of the server
+	Base     http.RoundTripper //nil = http.DefaultTransport
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if _, known := t.Services.find(req.URL.Host); known {
+		//RoundTrip must not modify the original request
+		req = req.Clone(req.Context())
+		req.Header.Set(callerHeader, t.Caller)
+		req.Header.Set(recorderHeader, t.Recorder.ID())
+	}
+
+	call := t.Recorder.Call(t.Caller, t.Services.Lookup(req.URL.Host), requestLabel(req))
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		call.Return("error: " + err.Error())
+	} else {
+		call.Return(resp.Status)
+	}
+	return resp, err
+}
+
+// Middleware wraps an http.Handler, and records each request as a call to the
+// given service. The caller is taken from the header set by Transport, or
+// else found by looking up the remote address in the services.
+//
+// Requests sent through a Transport with the same Recorder are not recorded
+// again.
+func Middleware(r *Recorder, service string, services Services, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(recorderHeader) == r.ID() {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		caller := req.Header.Get(callerHeader)
+		if caller == "" {
+			caller = services.Lookup(req.RemoteAddr)
+		}
+		call := r.Call(caller, service, requestLabel(req))
+		sw := &statusWriter{ResponseWriter: w, Status: http.StatusOK}
+		defer func() {
+			call.Return(strconv.Itoa(sw.Status) + " " + http.StatusText(sw.Status))
+		}()
+		next.ServeHTTP(sw, req)
+	})
+}
+
+func requestLabel(req *http.Request) string {
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	return req.Method + " " + path
+}
+
+// statusWriter is an http.ResponseWriter that remembers the response status.
+type statusWriter struct {
+	http.ResponseWriter
+	Status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.Status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements the http.Flusher interface if the original ResponseWriter
+// does, so that streaming handlers keep working behind the Middleware.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the original ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
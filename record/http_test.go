@@ -0,0 +1,169 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package record
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServicesLookup(t *testing.T) {
+	services := Services{"api.local": "api", "db.local:5432": "db", "10.0.0.1:8080": "worker"}
+	testCases := []struct {
+		Address string
+		Name    string
+	}{
+		{"api.local", "api"},
+		{"api.local:443", "api"},
+		{"db.local:5432", "db"},
+		{"db.local:5433", "db.local"},
+		{"10.0.0.1:8080", "worker"},
+		{"10.0.0.1:41234", "10.0.0.1"},
+		{"example.com", "example.com"},
+	}
+	for _, tc := range testCases {
+		if name := services.Lookup(tc.Address); name != tc.Name {
+			t.Errorf("expected Lookup(%q) = %q, got %q", tc.Address, tc.Name, name)
+		}
+	}
+}
+
+func TestTransportAndMiddleware(t *testing.T) {
+	//the handler reports which recorder headers it received
+	var receivedHeaders []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedHeaders = append(receivedHeaders, r.Header.Get(callerHeader)+"|"+r.Header.Get(recorderHeader))
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	testCases := []struct {
+		Name    string
+		Service string //in Services, or empty for an unknown host
+		Shared  bool   //if true, client and server use the same Recorder
+		Path    string
+		Headers string   //as received by the handler
+		Client  []string //labels recorded by the client-side Recorder
+		Server  []string //labels recorded by the server-side Recorder
+	}{
+		{
+			Name: "known service, separate recorders", Service: "api", Path: "/x",
+			Headers: "app|1",
+			Client:  []string{"app -> api: GET /x", "api -> app: 200 OK"},
+			Server:  []string{"app -> api: GET /x", "api -> app: 200 OK"},
+		},
+		{
+			Name: "known service, shared recorder", Service: "api", Shared: true, Path: "/missing",
+			Headers: "app|1",
+			Client:  []string{"app -> api: GET /missing", "api -> app: 404 Not Found"},
+		},
+		{
+			Name: "unknown host gets no headers", Path: "/x",
+			Headers: "|",
+			Client:  []string{"app -> 127.0.0.1: GET /x", "127.0.0.1 -> app: 200 OK"},
+			Server:  []string{"127.0.0.1 -> api: GET /x", "api -> 127.0.0.1: 200 OK"},
+		},
+	}
+	for _, tc := range testCases {
+		receivedHeaders = nil
+		clientRecorder := New()
+		clientRecorder.id = "1"
+		serverRecorder := New()
+		serverRecorder.id = "2"
+		if tc.Shared {
+			serverRecorder = clientRecorder
+		}
+		server := httptest.NewServer(Middleware(serverRecorder, "api", nil, handler))
+		services := Services{}
+		if tc.Service != "" {
+			services[strings.TrimPrefix(server.URL, "http://")] = tc.Service
+		}
+		client := &http.Client{Transport: &Transport{Recorder: clientRecorder, Caller: "app", Services: services}}
+
+		resp, err := client.Get(server.URL + tc.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		server.Close()
+
+		if strings.Join(receivedHeaders, ",") != tc.Headers {
+			t.Errorf("%s: expected headers %q, got %q", tc.Name, tc.Headers, receivedHeaders)
+		}
+		if actual := recordedLabels(clientRecorder); strings.Join(actual, "\n") != strings.Join(tc.Client, "\n") {
+			t.Errorf("%s: expected client to record %q, got %q", tc.Name, tc.Client, actual)
+		}
+		if !tc.Shared {
+			if actual := recordedLabels(serverRecorder); strings.Join(actual, "\n") != strings.Join(tc.Server, "\n") {
+				t.Errorf("%s: expected server to record %q, got %q", tc.Name, tc.Server, actual)
+			}
+		}
+	}
+}
+
+func TestTransportDoesNotModifyRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	services := Services{strings.TrimPrefix(server.URL, "http://"): "api"}
+	transport := &Transport{Recorder: New(), Caller: "app", Services: services}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(req.Header) != 0 {
+		t.Errorf("expected the original request to stay unchanged, got headers %v", req.Header)
+	}
+}
+
+func TestMiddlewareForwardsFlush(t *testing.T) {
+	handler := Middleware(New(), "api", nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("cannot flush: %v", err)
+		}
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("ResponseWriter does not implement http.Flusher")
+		}
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !w.Flushed {
+		t.Error("response was not flushed")
+	}
+}
+
+// recordedLabels returns the recorded events like "sender -> receiver: label".
+func recordedLabels(r *Recorder) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var result []string
+	for _, e := range r.events {
+		result = append(result, e.Sender+" -> "+e.Receiver+": "+e.Label)
+	}
+	return result
+}
@@ -0,0 +1,324 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+// Package record collects interactions between services at runtime, and writes
+// them as input for the sequence-diagram renderer. This is mostly intended for
+// integration tests: when all services in a test are instrumented (e.g. with
+// Transport and Middleware for HTTP), the test run yields a diagram of what
+// actually happened.
+package record
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Recorder collects interactions. It is safe for concurrent use. The recorded
+// interactions are kept in memory until WriteTo is called.
+type Recorder struct {
+	mutex  sync.Mutex
+	events []*event
+	actors []string          //in order of first appearance
+	labels map[string]string //key = actor name
+	id     string            //see ID()
+}
+
+type event struct {
+	Kind     string //"call", "return", "send", "post" (send without receive) or "receive"
+	Sender   string //empty for receives
+	Receiver string //for posts, only known once they are received
+	Label    string
+	Call     *event //only for returns: the call that is being returned from
+	Message  *event //only for receives: the post that is being received
+	Index    int    //position in Recorder.events
+	Returned bool   //only for calls
+	Received bool   //only for posts
+}
+
+// Call is a call that is waiting for its return.
+type Call struct {
+	recorder *Recorder
+	event    *event
+}
+
+// Message is a message that has been posted, but not yet received.
+type Message struct {
+	recorder *Recorder
+	event    *event
+}
+
+// New creates an empty Recorder.
+func New() *Recorder {
+	return &Recorder{labels: make(map[string]string)}
+}
+
+// Label sets the label that is shown for the given actor instead of its name.
+func (r *Recorder) Label(actor, label string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.addActor(actor)
+	r.labels[actor] = label
+}
+
+// Send records an asynchronous message.
+func (r *Recorder) Send(sender, receiver, label string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.addEvent(&event{Kind: "send", Sender: sender, Receiver: receiver, Label: label})
+}
+
+// Post records the sending of an asynchronous message, whose receipt is
+// recorded separately by calling Receive() on the result. Messages that are
+// never received are not written.
+func (r *Recorder) Post(sender, label string) *Message {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	e := &event{Kind: "post", Sender: sender, Label: label}
+	r.addEvent(e)
+	return &Message{recorder: r, event: e}
+}
+
+// Receive records the receipt of the message. Only the first Receive() call
+// has an effect.
+func (m *Message) Receive(receiver string) {
+	r := m.recorder
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if m.event.Received {
+		return
+	}
+	m.event.Received = true
+	m.event.Receiver = receiver
+	r.addEvent(&event{Kind: "receive", Receiver: receiver, Message: m.event})
+}
+
+// Call records a call. The caller is expected to call Return() on the result
+// when the response has been received.
+func (r *Recorder) Call(caller, callee, label string) *Call {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	e := &event{Kind: "call", Sender: caller, Receiver: callee, Label: label}
+	r.addEvent(e)
+	return &Call{recorder: r, event: e}
+}
+
+// Return records the response to the call. Only the first Return() call has
+// an effect.
+func (c *Call) Return(label string) {
+	r := c.recorder
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if c.event.Returned {
+		return
+	}
+	c.event.Returned = true
+	r.addEvent(&event{Kind: "return", Sender: c.event.Receiver, Receiver: c.event.Sender, Label: label, Call: c.event})
+}
+
+func (r *Recorder) addActor(name string) {
+	if _, exists := r.labels[name]; !exists {
+		r.labels[name] = name
+		r.actors = append(r.actors, name)
+	}
+}
+
+func (r *Recorder) addEvent(e *event) {
+	if e.Sender != "" {
+		r.addActor(e.Sender)
+	}
+	if e.Receiver != "" {
+		r.addActor(e.Receiver)
+	}
+	e.Index = len(r.events)
+	r.events = append(r.events, e)
+}
+
+var lastRecorderID uint64
+
+// ID returns a string that identifies this Recorder within the process. It is
+// sent along with requests by client-side recorders (e.g. Transport), so that
+// server-side recorders (e.g. Middleware) do not record the same request again.
+func (r *Recorder) ID() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.id == "" {
+		r.id = strconv.FormatUint(atomic.AddUint64(&lastRecorderID, 1), 10)
+	}
+	return r.id
+}
+
+// WriteFile writes the recorded interactions into the given file (see WriteTo).
+func (r *Recorder) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = r.WriteTo(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// WriteTo writes the recorded interactions in the input format of the
+// sequence-diagram renderer.
+//
+// The input format does not allow an actor to do anything else while it waits
+// for the response to a call. Calls that overlap with other interactions of
+// the caller (e.g. because the caller sent concurrent requests), as well as
+// calls that never returned, are therefore written as a pair of asynchronous
+// messages instead.
+func (r *Recorder) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	names := make(map[string]string, len(r.actors))
+	used := make(map[string]bool, len(r.actors))
+	for _, actor := range r.actors {
+		names[actor] = actorName(actor, used)
+		used[names[actor]] = true
+	}
+	synchronous := r.findSynchronousCalls()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	cw.printf("option auto-tick\n")
+	for _, actor := range r.actors {
+		if label := r.labels[actor]; label != names[actor] {
+			cw.printf("label %s %s\n", names[actor], escape(label))
+		}
+	}
+	cw.printf("together\n")
+	for _, actor := range r.actors {
+		cw.printf("start %s\n", names[actor])
+	}
+	cw.printf("end\n")
+
+	for idx, e := range r.events {
+		kind := e.Kind
+		switch {
+		case kind == "call" && !synchronous[e]:
+			kind = "send"
+		case kind == "return" && !synchronous[e.Call]:
+			kind = "send"
+		case kind == "post":
+			if !e.Received {
+				continue
+			}
+			kind = "send"
+		case kind == "receive":
+			cw.printf("receive %s m%d\n", names[e.Receiver], e.Message.Index+1)
+			continue
+		}
+		label := e.Label
+		if strings.TrimSpace(label) == "" {
+			label = kind
+		}
+		cw.printf("%s %s m%d %s\n", kind, names[e.Sender], idx+1, escape(label))
+		if e.Kind != "post" {
+			cw.printf("receive %s m%d\n", names[e.Receiver], idx+1)
+		}
+	}
+
+	cw.printf("together\n")
+	for _, actor := range r.actors {
+		cw.printf("stop %s\n", names[actor])
+	}
+	cw.printf("end\n")
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.count, cw.err
+}
+
+// findSynchronousCalls returns the calls that can be written as such, i.e.
+// those that have returned, and whose caller did not take part in any other
+// interaction while waiting for the return.
+func (r *Recorder) findSynchronousCalls() map[*event]bool {
+	//indexes of all events that each actor takes part in (in ascending order)
+	participation := make(map[string][]int)
+	for _, e := range r.events {
+		if e.Kind != "receive" {
+			participation[e.Sender] = append(participation[e.Sender], e.Index)
+		}
+		if e.Kind != "post" && e.Receiver != e.Sender {
+			participation[e.Receiver] = append(participation[e.Receiver], e.Index)
+		}
+	}
+
+	result := make(map[*event]bool)
+	for _, e := range r.events {
+		if e.Kind != "return" || e.Sender == e.Receiver {
+			continue
+		}
+		//between the call and the return, the caller must not appear
+		call := e.Call
+		indexes := participation[call.Sender]
+		first := sort.SearchInts(indexes, call.Index+1)
+		if indexes[first] == e.Index {
+			result[call] = true
+		}
+	}
+	return result
+}
+
+// actorName makes an actor name that can be used in the input format, i.e.
+// one without whitespace or semicolons, and that is distinct from the names
+// already in use.
+func actorName(actor string, used map[string]bool) string {
+	name := strings.Join(strings.Fields(strings.ReplaceAll(actor, ";", "_")), "_")
+	if name == "" || strings.HasPrefix(name, "#") {
+		name = "actor" + name
+	}
+	candidate := name
+	for idx := 2; used[candidate]; idx++ {
+		candidate = fmt.Sprintf("%s_%d", name, idx)
+	}
+	return candidate
+}
+
+// escape makes a free-form text suitable for use in the input format.
+func escape(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	return strings.ReplaceAll(text, ";", `\;`)
+}
+
+// countingWriter remembers the first write error, so that the individual
+// writes need not be checked.
+type countingWriter struct {
+	w     *bufio.Writer
+	count int64
+	err   error
+}
+
+func (cw *countingWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.count += int64(n)
+	cw.err = err
+}
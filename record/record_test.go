@@ -0,0 +1,150 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package record
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Record   func(r *Recorder)
+		Expected []string //without the lines that start and stop the actors
+	}{
+		{
+			Name: "synchronous call",
+			Record: func(r *Recorder) {
+				r.Call("client", "server", "GET /x").Return("200 OK")
+			},
+			Expected: []string{
+				"call client m1 GET /x",
+				"receive server m1",
+				"return server m2 200 OK",
+				"receive client m2",
+			},
+		},
+		{
+			Name: "overlapping calls become messages",
+			Record: func(r *Recorder) {
+				c1 := r.Call("client", "a", "one")
+				c2 := r.Call("client", "b", "two")
+				c2.Return("r2")
+				c1.Return("r1")
+			},
+			Expected: []string{
+				"send client m1 one",
+				"receive a m1",
+				"call client m2 two",
+				"receive b m2",
+				"return b m3 r2",
+				"receive client m3",
+				"send a m4 r1",
+				"receive client m4",
+			},
+		},
+		{
+			Name: "call without return becomes a message",
+			Record: func(r *Recorder) {
+				r.Call("client", "server", "hanging")
+			},
+			Expected: []string{
+				"send client m1 hanging",
+				"receive server m1",
+			},
+		},
+		{
+			Name: "second return is ignored",
+			Record: func(r *Recorder) {
+				c := r.Call("client", "server", "x")
+				c.Return("first")
+				c.Return("second")
+			},
+			Expected: []string{
+				"call client m1 x",
+				"receive server m1",
+				"return server m2 first",
+				"receive client m2",
+			},
+		},
+		{
+			Name: "posted messages are received later, or not at all",
+			Record: func(r *Recorder) {
+				m := r.Post("producer", "job")
+				r.Post("producer", "lost")
+				r.Send("producer", "log", "done")
+				m.Receive("worker")
+				m.Receive("other worker")
+			},
+			Expected: []string{
+				"send producer m1 job",
+				"send producer m3 done",
+				"receive log m3",
+				"receive worker m1",
+			},
+		},
+		{
+			Name: "names and labels are escaped",
+			Record: func(r *Recorder) {
+				r.Send("my service", "db;1", "a;b \n c")
+				r.Send("#x", "my_service", "")
+			},
+			Expected: []string{
+				"label my_service my service",
+				`label db_1 db\;1`,
+				"label actor#x #x",
+				"label my_service_2 my_service",
+				`send my_service m1 a\;b c`,
+				"receive db_1 m1",
+				"send actor#x m2 send",
+				"receive my_service_2 m2",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		r := New()
+		tc.Record(r)
+		var buf strings.Builder
+		n, err := r.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(buf.Len()) {
+			t.Errorf("%s: WriteTo returned %d, but wrote %d bytes", tc.Name, n, buf.Len())
+		}
+		var lines []string
+		inTogether := false
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			switch {
+			case line == "option auto-tick":
+			case line == "together":
+				inTogether = true
+			case line == "end":
+				inTogether = false
+			case !inTogether:
+				lines = append(lines, line)
+			}
+		}
+		if strings.Join(lines, "\n") != strings.Join(tc.Expected, "\n") {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tc.Name, strings.Join(tc.Expected, "\n"), strings.Join(lines, "\n"))
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+// Package sdtest turns Go tests into sequence diagrams. A test creates a
+// Recorder, records the interactions that it exercises (either explicitly, or
+// by passing Recorder.Recorder to the instrumentation in package record), and
+// when the test completes, the diagram is written into the test's artifact
+// directory (see "go help testflag", option -artifacts):
+//
+//	func TestLogin(t *testing.T) {
+//		rec := sdtest.New(t)
+//		rec.Call("client", "server", "Login")
+//		rec.Call("server", "db", "SELECT user")
+//		rec.Return("1 row")
+//		rec.Return("session token")
+//	}
+//
+// The source is written into "sequence-diagram.txt", and rendered (with the
+// default options, see package sequence) into "sequence-diagram.svg". If the
+// source contains multiple pages, they are written into
+// "sequence-diagram-1.svg", "sequence-diagram-2.svg" and so on.
+package sdtest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/johan48191/sequence-diagram/record"
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// Recorder records the interactions in a single test.
+type Recorder struct {
+	*record.Recorder
+	t     testing.TB
+	mutex sync.Mutex
+	calls []*record.Call //calls without return, innermost last
+}
+
+// New creates a Recorder for the given test. The diagram is written when the
+// test completes.
+func New(t testing.TB) *Recorder {
+	t.Helper()
+	rec := &Recorder{Recorder: record.New(), t: t}
+	//request the directory now, so that it is removed (if it is temporary)
+	//only after the diagram has been written
+	dir := t.ArtifactDir()
+	t.Cleanup(func() { rec.write(dir) })
+	return rec
+}
+
+// Call records a call from the caller to the callee. It stays open until the
+// matching Return.
+func (rec *Recorder) Call(caller, callee, label string) {
+	call := rec.Recorder.Call(caller, callee, label)
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	rec.calls = append(rec.calls, call)
+}
+
+// Return records the return of the innermost open call.
+func (rec *Recorder) Return(label string) {
+	rec.t.Helper()
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	if len(rec.calls) == 0 {
+		rec.t.Fatalf("sdtest: Return(%q) without open call", label)
+	}
+	call := rec.calls[len(rec.calls)-1]
+	rec.calls = rec.calls[:len(rec.calls)-1]
+	call.Return(label)
+}
+
+func (rec *Recorder) write(dir string) {
+	var buf bytes.Buffer
+	_, err := rec.WriteTo(&buf)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "sequence-diagram.txt"), buf.Bytes(), 0666)
+	}
+	if err != nil {
+		rec.t.Errorf("sdtest: cannot write diagram: %s", err.Error())
+		return
+	}
+
+	diagrams, err := sequence.Parse(&buf)
+	var pages []sequence.Page
+	if err == nil {
+		pages, err = sequence.Render(diagrams, sequence.DefaultOptions())
+	}
+	for idx, page := range pages {
+		if err != nil {
+			break
+		}
+		name := "sequence-diagram.svg"
+		if len(pages) > 1 {
+			name = fmt.Sprintf("sequence-diagram-%d.svg", idx+1)
+		}
+		err = writePage(filepath.Join(dir, name), page)
+	}
+	if err != nil {
+		rec.t.Errorf("sdtest: cannot render diagram: %s", err.Error())
+	}
+}
+
+func writePage(path string, page sequence.Page) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(file)
+	err = page(out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	return err
+}
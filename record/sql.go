@@ -0,0 +1,408 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package record
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Driver wraps a database/sql driver, and records each statement as a call
+// from the Caller to the Database. The labels show the SQL text (shortened,
+// and with string and number literals redacted), but never the arguments or
+// the database's error messages. Use it like:
+//
+//	sql.Register("recorded-postgres", &record.Driver{
+//		Recorder: rec, Caller: "app", Database: "postgres", Base: &pq.Driver{},
+//	})
+//	db, err := sql.Open("recorded-postgres", dsn)
+type Driver struct {
+	Recorder *Recorder
+	Caller   string
+	Database string
+	Base     driver.Driver
+}
+
+// Open implements the driver.Driver interface.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.Base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{Conn: c, d: d}, nil
+}
+
+// Connector wraps a driver.Connector like Driver wraps a driver.Driver, for
+// use with sql.OpenDB.
+type Connector struct {
+	Recorder *Recorder
+	Caller   string
+	Database string
+	Base     driver.Connector
+}
+
+// Connect implements the driver.Connector interface.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Base.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{Conn: conn, d: c.driver()}, nil
+}
+
+// Driver implements the driver.Connector interface.
+func (c *Connector) Driver() driver.Driver {
+	return c.driver()
+}
+
+func (c *Connector) driver() *Driver {
+	return &Driver{Recorder: c.Recorder, Caller: c.Caller, Database: c.Database, Base: c.Base.Driver()}
+}
+
+// record records a statement once it has completed. (Recording it before
+// would be wrong when the driver answers with driver.ErrSkip, since database/sql
+// then retries in a different way.)
+func (d *Driver) record(query, result string, err error) {
+	if err != nil {
+		result = errorLabel(err)
+	}
+	d.Recorder.Call(d.Caller, d.Database, queryLabel(query)).Return(result)
+}
+
+// errorLabel describes a failed statement. Error messages of databases often
+// contain the values of the statement (e.g. in the detail of a unique
+// constraint violation), so only the SQLSTATE code is shown if the driver's
+// error has one (like the errors of pgx and lib/pq).
+func errorLabel(err error) string {
+	var withState interface{ SQLState() string }
+	if errors.As(err, &withState) && withState.SQLState() != "" {
+		return "error: SQLSTATE " + withState.SQLState()
+	}
+	return "error"
+}
+
+// MaxQueryLabelLength is the number of characters beyond which SQL texts are
+// shortened in message labels.
+const MaxQueryLabelLength = 80
+
+// queryLabel shortens the SQL text, and replaces literals by '?': strings
+// (including PostgreSQL's dollar-quoted strings) and numbers. Placeholders
+// like $1 and numbers within identifiers are kept.
+func queryLabel(query string) string {
+	var buf strings.Builder
+	runes := []rune(query)
+	for idx := 0; idx < len(runes); idx++ {
+		r := runes[idx]
+		switch {
+		case r == '\'':
+			end := indexRunes(runes, idx+1, []rune("'"))
+			//an escaped quote ('') continues the literal
+			for end+1 < len(runes) && runes[end+1] == '\'' {
+				end = indexRunes(runes, end+2, []rune("'"))
+			}
+			buf.WriteString("'?'")
+			idx = end
+		case r == '$' && dollarTag(runes[idx:]) != nil:
+			tag := dollarTag(runes[idx:])
+			end := indexRunes(runes, idx+len(tag), tag)
+			buf.WriteString("'?'")
+			idx = end + len(tag) - 1
+		case r == '$' || isIdentifierRune(r):
+			//keywords, identifiers and placeholders (including their digits)
+			for idx < len(runes) && (runes[idx] == '$' || isIdentifierRune(runes[idx]) || unicode.IsDigit(runes[idx])) {
+				buf.WriteRune(runes[idx])
+				idx++
+			}
+			idx--
+		case unicode.IsDigit(r) || (r == '.' && idx+1 < len(runes) && unicode.IsDigit(runes[idx+1])):
+			for idx < len(runes) && (unicode.IsDigit(runes[idx]) || runes[idx] == '.') {
+				idx++
+			}
+			//exponent, e.g. 1.5e-3
+			if idx+1 < len(runes) && (runes[idx] == 'e' || runes[idx] == 'E') {
+				next := idx + 1
+				if runes[next] == '+' || runes[next] == '-' {
+					next++
+				}
+				if next < len(runes) && unicode.IsDigit(runes[next]) {
+					idx = next
+					for idx < len(runes) && unicode.IsDigit(runes[idx]) {
+						idx++
+					}
+				}
+			}
+			idx--
+			buf.WriteRune('?')
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	label := strings.Join(strings.Fields(buf.String()), " ")
+	if labelRunes := []rune(label); len(labelRunes) > MaxQueryLabelLength {
+		label = strings.TrimSpace(string(labelRunes[:MaxQueryLabelLength])) + "..."
+	}
+	return label
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// dollarTag returns the delimiter of the dollar-quoted string at the start of
+// the input (e.g. "$$" or "$body$"), or nil if there is none.
+func dollarTag(runes []rune) []rune {
+	for idx := 1; idx < len(runes); idx++ {
+		switch {
+		case runes[idx] == '$':
+			return runes[:idx+1]
+		case runes[idx] == '_' || unicode.IsLetter(runes[idx]) || (idx > 1 && unicode.IsDigit(runes[idx])):
+			continue
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// indexRunes returns the position of the first occurrence of sub in runes at
+// or after the given start, or len(runes) if there is none.
+func indexRunes(runes []rune, start int, sub []rune) int {
+	for idx := start; idx+len(sub) <= len(runes); idx++ {
+		if string(runes[idx:idx+len(sub)]) == string(sub) {
+			return idx
+		}
+	}
+	return len(runes)
+}
+
+func resultLabel(result driver.Result, err error) string {
+	if err != nil {
+		return ""
+	}
+	if count, err := result.RowsAffected(); err == nil {
+		return fmt.Sprintf("%d rows affected", count)
+	}
+	return "OK"
+}
+
+func rowsLabel(rows driver.Rows, err error) string {
+	if err != nil {
+		return ""
+	}
+	return "rows (" + strings.Join(rows.Columns(), ", ") + ")"
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// connections
+
+// sqlConn forwards the optional interfaces of driver.Conn to the wrapped
+// connection, and answers with driver.ErrSkip where it does not implement
+// them, so that database/sql falls back to the basic interface.
+type sqlConn struct {
+	driver.Conn
+	d *Driver
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{Stmt: s, d: c.d, query: query}, nil
+}
+
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	p, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	s, err := p.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{Stmt: s, d: c.d, query: query}, nil
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	result, err := e.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.d.record(query, resultLabel(result, err), err)
+	}
+	return result, err
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.d.record(query, rowsLabel(rows, err), err)
+	}
+	return rows, err
+}
+
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	//Conn.Begin is deprecated, but must be forwarded for drivers without BeginTx
+	tx, err := c.Conn.Begin()
+	c.d.record("BEGIN", "OK", err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, d: c.d}, nil
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	b, ok := c.Conn.(driver.ConnBeginTx)
+	if !ok {
+		//same restriction as in database/sql for drivers without BeginTx
+		if opts.Isolation != 0 || opts.ReadOnly {
+			return nil, errors.New("driver does not support non-default isolation level or read-only transactions")
+		}
+		return c.Begin()
+	}
+	tx, err := b.BeginTx(ctx, opts)
+	c.d.record("BEGIN", "OK", err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, d: c.d}, nil
+}
+
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *sqlConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// statements and transactions
+
+type sqlStmt struct {
+	driver.Stmt
+	d     *Driver
+	query string
+}
+
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	//Stmt.Exec is deprecated, but must be forwarded for drivers without ExecContext
+	result, err := s.Stmt.Exec(args)
+	s.d.record(s.query, resultLabel(result, err), err)
+	return result, err
+}
+
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.Stmt.Query(args)
+	s.d.record(s.query, rowsLabel(rows, err), err)
+	return rows, err
+}
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(values)
+	}
+	result, err := e.ExecContext(ctx, args)
+	s.d.record(s.query, resultLabel(result, err), err)
+	return result, err
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(values)
+	}
+	rows, err := q.QueryContext(ctx, args)
+	s.d.record(s.query, rowsLabel(rows, err), err)
+	return rows, err
+}
+
+func (s *sqlStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for idx, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("driver does not support named parameters (got %s)", arg.Name)
+		}
+		values[idx] = arg.Value
+	}
+	return values, nil
+}
+
+type sqlTx struct {
+	driver.Tx
+	d *Driver
+}
+
+func (tx *sqlTx) Commit() error {
+	err := tx.Tx.Commit()
+	tx.d.record("COMMIT", "OK", err)
+	return err
+}
+
+func (tx *sqlTx) Rollback() error {
+	err := tx.Tx.Rollback()
+	tx.d.record("ROLLBACK", "OK", err)
+	return err
+}
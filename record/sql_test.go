@@ -0,0 +1,79 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package record
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestQueryLabel(t *testing.T) {
+	testCases := []struct {
+		Query string
+		Label string
+	}{
+		{"SELECT * FROM users", "SELECT * FROM users"},
+		{"SELECT *\n\tFROM users\n  WHERE id = $1", "SELECT * FROM users WHERE id = $1"},
+		{"SELECT * FROM users WHERE name = 'alice'", "SELECT * FROM users WHERE name = '?'"},
+		{"SELECT 'it''s', 'x'", "SELECT '?', '?'"},
+		{"SELECT 'unterminated", "SELECT '?'"},
+		{"SELECT * FROM t1 WHERE id = 42 AND score > 1.5e-3 AND x < .5", "SELECT * FROM t1 WHERE id = ? AND score > ? AND x < ?"},
+		{"SELECT col2, $12 FROM t_3", "SELECT col2, $12 FROM t_3"},
+		{"SELECT $$secret$$, $tag$more $$ secret$tag$", "SELECT '?', '?'"},
+		{"SELECT $$unterminated", "SELECT '?'"},
+		{"INSERT INTO städte VALUES ('Köln', 1e6)", "INSERT INTO städte VALUES ('?', ?)"},
+		{"SELECT " + strings.Repeat("ä", 100), "SELECT " + strings.Repeat("ä", MaxQueryLabelLength-len("SELECT ")) + "..."},
+	}
+	for _, tc := range testCases {
+		if label := queryLabel(tc.Query); label != tc.Label {
+			t.Errorf("expected queryLabel(%q) = %q, got %q", tc.Query, tc.Label, label)
+		}
+	}
+}
+
+type sqlStateError struct {
+	State string
+}
+
+func (e sqlStateError) Error() string {
+	return `duplicate key value violates unique constraint: Key (email)=(alice@example.com) already exists`
+}
+
+func (e sqlStateError) SQLState() string {
+	return e.State
+}
+
+func TestErrorLabel(t *testing.T) {
+	testCases := []struct {
+		Error error
+		Label string
+	}{
+		{errors.New("Key (email)=(alice@example.com) already exists"), "error"},
+		{sqlStateError{"23505"}, "error: SQLSTATE 23505"},
+		{fmt.Errorf("wrapped: %w", sqlStateError{"40001"}), "error: SQLSTATE 40001"},
+		{sqlStateError{""}, "error"},
+	}
+	for _, tc := range testCases {
+		if label := errorLabel(tc.Error); label != tc.Label {
+			t.Errorf("expected errorLabel(%q) = %q, got %q", tc.Error, tc.Label, label)
+		}
+	}
+}
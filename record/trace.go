@@ -0,0 +1,110 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package record
+
+import (
+	"sort"
+	"time"
+)
+
+// Span is the part of a trace span (e.g. from OpenTelemetry) that is needed
+// for RecordTrace.
+type Span struct {
+	ID       string
+	ParentID string //empty for the root span
+	Service  string
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Status   string //label of the return, e.g. "OK" (empty = "return")
+}
+
+// RecordTrace records the calls between services in a completed trace. Each
+// span whose parent belongs to a different service is a call from the
+// parent's service to the span's service. Spans without parent (or whose
+// parent is not part of the given spans) are calls from the rootCaller. Spans
+// whose parent belongs to the same service are not shown.
+func (r *Recorder) RecordTrace(spans []Span, rootCaller string) {
+	byID := make(map[string]*Span, len(spans))
+	for idx := range spans {
+		byID[spans[idx].ID] = &spans[idx]
+	}
+	depth := make(map[*Span]int, len(spans))
+	var depthOf func(s *Span) int
+	depthOf = func(s *Span) int {
+		d, exists := depth[s]
+		if !exists {
+			depth[s] = 0 //guards against cycles
+			if parent := byID[s.ParentID]; parent != nil {
+				d = depthOf(parent) + 1
+			}
+			depth[s] = d
+		}
+		return d
+	}
+
+	//each call starts at the start of its span and returns at its end
+	type spanEvent struct {
+		Time   time.Time
+		IsCall bool
+		Depth  int
+		Span   *Span
+		Caller string
+	}
+	var events []spanEvent
+	for idx := range spans {
+		s := &spans[idx]
+		caller := rootCaller
+		if parent := byID[s.ParentID]; parent != nil {
+			caller = parent.Service
+		}
+		if caller == s.Service {
+			continue
+		}
+		d := depthOf(s)
+		events = append(events,
+			spanEvent{Time: s.Start, IsCall: true, Depth: d, Span: s, Caller: caller},
+			spanEvent{Time: s.End, IsCall: false, Depth: d, Span: s, Caller: caller},
+		)
+	}
+	//on equal timestamps, returns come before calls, outer calls before inner
+	//calls, and inner returns before outer returns
+	sort.SliceStable(events, func(i, j int) bool {
+		e1, e2 := events[i], events[j]
+		switch {
+		case !e1.Time.Equal(e2.Time):
+			return e1.Time.Before(e2.Time)
+		case e1.IsCall != e2.IsCall:
+			return !e1.IsCall
+		case e1.IsCall:
+			return e1.Depth < e2.Depth
+		default:
+			return e1.Depth > e2.Depth
+		}
+	})
+
+	calls := make(map[*Span]*Call, len(spans))
+	for _, e := range events {
+		if e.IsCall {
+			calls[e.Span] = r.Call(e.Caller, e.Span.Service, e.Span.Name)
+		} else if call := calls[e.Span]; call != nil {
+			call.Return(e.Span.Status)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// repoPublisher keeps a checkout of the git repository given by -repo-url,
+// and renders all diagram files in it into a static website (see buildSite)
+// whenever the repository changes. The "serve" subcommand triggers it from a
+// webhook and/or every -repo-poll-interval, and serves the website below
+// /site/.
+//
+// Below -repo-dir, the checkout is kept in "checkout", and the website in
+// "site". Each build renders into a new directory first, so that a failed
+// build leaves the previous website in place.
+type repoPublisher struct {
+	URL     string
+	Branch  string //empty = the remote's default branch
+	Dir     string
+	Secret  []byte //from -webhook-secret-file
+	Options *sequence.Options
+	trigger chan struct{} //buffered, so that triggers during a build are coalesced into one more build
+	mutex   sync.Mutex
+	status  publishStatus
+}
+
+// publishStatus describes the outcome of the last build of a repoPublisher.
+type publishStatus struct {
+	Commit   string    `json:"commit,omitempty"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+	Running  bool      `json:"running"`
+}
+
+func newRepoPublisher(opts *sequence.Options) *repoPublisher {
+	if *repoDirFlag == "" {
+		fail("-repo-url requires -repo-dir")
+	}
+	p := &repoPublisher{
+		URL:     *repoURLFlag,
+		Branch:  *repoBranchFlag,
+		Dir:     *repoDirFlag,
+		Options: opts,
+		trigger: make(chan struct{}, 1),
+	}
+	if *webhookSecretFileFlag != "" {
+		secret, err := os.ReadFile(*webhookSecretFileFlag)
+		failIfErr(err)
+		p.Secret = bytes.TrimSpace(secret)
+		if len(p.Secret) == 0 {
+			fail("-webhook-secret-file: %s is empty", *webhookSecretFileFlag)
+		}
+	}
+	failIfErr(os.MkdirAll(p.SiteDir(), 0777))
+	return p
+}
+
+func (p *repoPublisher) SiteDir() string {
+	return filepath.Join(p.Dir, "site")
+}
+
+// Trigger schedules a build, unless one is already scheduled.
+func (p *repoPublisher) Trigger() {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// run builds once at startup, and then whenever triggered by Trigger() or by
+// -repo-poll-interval. It does not return.
+func (p *repoPublisher) run() {
+	p.Trigger()
+	var poll <-chan time.Time
+	if *repoPollIntervalFlag > 0 {
+		poll = time.NewTicker(*repoPollIntervalFlag).C
+	}
+	for {
+		select {
+		case <-p.trigger:
+		case <-poll:
+		}
+		p.build()
+	}
+}
+
+func (p *repoPublisher) build() {
+	p.mutex.Lock()
+	p.status.Running = true
+	p.mutex.Unlock()
+
+	var commit string
+	msg := catchFailure(func() {
+		commit = p.update()
+		//render into a fresh directory, and swap it in only on success
+		newSiteDir := p.SiteDir() + ".new"
+		oldSiteDir := p.SiteDir() + ".old"
+		failIfErr(os.RemoveAll(newSiteDir))
+		siteOpts := *p.Options
+		siteOpts.Format = "svg"
+		buildSite(filepath.Join(p.Dir, "checkout"), newSiteDir, &siteOpts)
+		failIfErr(os.RemoveAll(oldSiteDir))
+		failIfErr(os.Rename(p.SiteDir(), oldSiteDir))
+		failIfErr(os.Rename(newSiteDir, p.SiteDir()))
+		failIfErr(os.RemoveAll(oldSiteDir))
+	})
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.status = publishStatus{Commit: commit, Finished: time.Now().UTC(), Error: msg}
+	if msg == "" {
+		slog.Info("published repository", "mode", "repo", "commit", commit)
+	} else {
+		slog.Error("could not publish repository", "mode", "repo", "commit", commit, "error", msg)
+	}
+}
+
+// update clones the repository, or updates the existing checkout to the
+// latest commit of the branch. Returns the commit ID.
+func (p *repoPublisher) update() string {
+	checkoutDir := filepath.Join(p.Dir, "checkout")
+	if _, err := os.Stat(filepath.Join(checkoutDir, ".git")); os.IsNotExist(err) {
+		args := []string{"clone", "--quiet", "--depth", "1"}
+		if p.Branch != "" {
+			args = append(args, "--branch", p.Branch)
+		}
+		runGit(append(args, "--", p.URL, checkoutDir)...)
+	} else {
+		ref := p.Branch
+		if ref == "" {
+			ref = "HEAD"
+		}
+		runGit("-C", checkoutDir, "fetch", "--quiet", "--depth", "1", "origin", ref)
+		runGit("-C", checkoutDir, "reset", "--quiet", "--hard", "FETCH_HEAD")
+		runGit("-C", checkoutDir, "clean", "--quiet", "-d", "--force")
+	}
+	return runGit("-C", checkoutDir, "rev-parse", "HEAD")
+}
+
+// runGit runs git with the given arguments, and returns its output. It fails
+// with the error output of git if git fails.
+func runGit(args ...string) string {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		fail("git %s: %s: %s", args[0], err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// HTTP API
+
+// handleWebhook implements the /hooks/repo endpoint. A POST (e.g. from a push
+// webhook of GitHub, GitLab or Gitea) triggers a build; the request body is
+// not interpreted. A GET returns the status of the last build as JSON.
+func (s *renderServer) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		s.Publisher.Trigger()
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "build triggered")
+	case "GET":
+		s.Publisher.mutex.Lock()
+		status := s.Publisher.status
+		s.Publisher.mutex.Unlock()
+		writeJSON(w, status)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// checkWebhook is the check for the /hooks/repo endpoint. Since webhooks
+// cannot send API tokens, they authenticate with the -webhook-secret-file
+// instead: either as HMAC signature of the body in the X-Hub-Signature-256
+// header (GitHub, Gitea), or verbatim in the X-Gitlab-Token header. Without a
+// webhook secret, the usual serverAuth applies.
+func (s *renderServer) checkWebhook(r *http.Request) (int, string) {
+	secret := s.Publisher.Secret
+	if len(secret) == 0 {
+		return s.Auth.check(r)
+	}
+	if code, msg := s.Auth.checkNetwork(r); code != 0 {
+		return code, msg
+	}
+	if r.Method != "POST" {
+		//the build status can only be read with an API token
+		return s.Auth.check(r)
+	}
+
+	if token := r.Header.Get("X-Gitlab-Token"); token != "" {
+		if subtle.ConstantTimeCompare([]byte(token), secret) == 1 {
+			return 0, ""
+		}
+		return http.StatusUnauthorized, "invalid webhook token"
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, *maxInputSizeFlag))
+	if err != nil {
+		return http.StatusRequestEntityTooLarge, err.Error()
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get("X-Hub-Signature-256")), []byte(expected)) {
+		return http.StatusUnauthorized, "missing or invalid webhook signature"
+	}
+	return 0, ""
+}
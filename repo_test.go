@@ -0,0 +1,78 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+func TestCheckWebhook(t *testing.T) {
+	const body = `{"ref":"refs/heads/main"}`
+	sign := func(secret, body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	auth := &serverAuth{Tokens: [][]byte{[]byte("token")}}
+	withSecret := newTestServer(sequence.DefaultOptions())
+	withSecret.Auth = auth
+	withSecret.Publisher = &repoPublisher{Secret: []byte("s3cret")}
+	withoutSecret := newTestServer(sequence.DefaultOptions())
+	withoutSecret.Auth = auth
+	withoutSecret.Publisher = &repoPublisher{}
+
+	testCases := []struct {
+		Name   string
+		Server *renderServer
+		Method string
+		Body   string
+		Header http.Header
+		Status int
+	}{
+		{"valid signature", withSecret, "POST", body, http.Header{"X-Hub-Signature-256": {sign("s3cret", body)}}, 0},
+		{"signature with other secret", withSecret, "POST", body, http.Header{"X-Hub-Signature-256": {sign("other", body)}}, http.StatusUnauthorized},
+		{"signature of other body", withSecret, "POST", body + " ", http.Header{"X-Hub-Signature-256": {sign("s3cret", body)}}, http.StatusUnauthorized},
+		{"signature without prefix", withSecret, "POST", body, http.Header{"X-Hub-Signature-256": {strings.TrimPrefix(sign("s3cret", body), "sha256=")}}, http.StatusUnauthorized},
+		{"missing signature", withSecret, "POST", body, nil, http.StatusUnauthorized},
+		{"API token instead of signature", withSecret, "POST", body, http.Header{"Authorization": {"Bearer token"}}, http.StatusUnauthorized},
+		{"valid GitLab token", withSecret, "POST", body, http.Header{"X-Gitlab-Token": {"s3cret"}}, 0},
+		{"invalid GitLab token", withSecret, "POST", body, http.Header{"X-Gitlab-Token": {"s3cre"}}, http.StatusUnauthorized},
+		{"status with API token", withSecret, "GET", "", http.Header{"Authorization": {"Bearer token"}}, 0},
+		{"status with webhook secret", withSecret, "GET", "", http.Header{"X-Gitlab-Token": {"s3cret"}}, http.StatusUnauthorized},
+		{"no secret: API token", withoutSecret, "POST", body, http.Header{"Authorization": {"Bearer token"}}, 0},
+		{"no secret: signature is not enough", withoutSecret, "POST", body, http.Header{"X-Hub-Signature-256": {sign("", body)}}, http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest(tc.Method, "/hooks/repo", strings.NewReader(tc.Body))
+		for key, values := range tc.Header {
+			r.Header[key] = values
+		}
+		if status, msg := tc.Server.checkWebhook(r); status != tc.Status {
+			t.Errorf("%s: expected status %d, got %d (%s)", tc.Name, tc.Status, status, msg)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	CommunicationMargin     = 100 //around the circle of actors
+	CommunicationMinRadius  = 150
+	CommunicationArrowSize  = 30 //length of the direction arrow next to the message labels
+	CommunicationLabelShift = 12 //distance of the direction arrow from the edge
+)
+
+// renderCommunication writes a UML communication diagram: one node per actor
+// (arranged on a circle in display order), and one edge per pair of actors
+// that exchange messages. The edges are annotated with the numbered messages,
+// grouped by direction.
+func renderCommunication(w io.Writer, diagram *Diagram, opts *Options) {
+	actors := sortedActors(diagram.Actors)
+	radius := math.Max(CommunicationMinRadius, float64(len(actors))*LabelWidth/math.Pi)
+	center := radius + CommunicationMargin
+	size := uint(2 * center)
+
+	type point struct{ X, Y float64 }
+	positions := make(map[string]point, len(actors))
+	for idx, actor := range actors {
+		//start at the top, then go clockwise
+		angle := 2*math.Pi*float64(idx)/float64(len(actors)) - math.Pi/2
+		positions[actor.Name] = point{center + radius*math.Cos(angle), center + radius*math.Sin(angle)}
+	}
+
+	//group the numbered messages by direction
+	type direction struct{ From, To string }
+	labels := make(map[direction][]string)
+	var directions []direction
+	var number int
+	for _, msg := range sortedMessages(diagram.Messages) {
+		if !msg.isDrawn(opts) || diagram.Actors[msg.SenderName] == nil || diagram.Actors[msg.ReceiverName] == nil {
+			continue
+		}
+		number++
+		d := direction{msg.SenderName, msg.ReceiverName}
+		if _, exists := labels[d]; !exists {
+			directions = append(directions, d)
+		}
+		labels[d] = append(labels[d], fmt.Sprintf("%d: %s", number, escapeText(strings.Join(labelLines(msg.Label), " "), opts)))
+	}
+
+	writeSVGHeader(w, size, size)
+	writeThemeStyle(w, opts.Theme)
+
+	//edges (one per pair of actors, drawn once even if used in both directions)
+	drawn := make(map[direction]bool)
+	for _, d := range directions {
+		key := d
+		if key.From > key.To {
+			key = direction{key.To, key.From}
+		}
+		if drawn[key] || d.From == d.To {
+			continue
+		}
+		drawn[key] = true
+		p1, p2 := positions[d.From], positions[d.To]
+		fmt.Fprintf(w, `<line x1="%.1f" x2="%.1f" y1="%.1f" y2="%.1f" stroke="black" />`, p1.X, p2.X, p1.Y, p2.Y)
+	}
+
+	//direction arrows and labels, on the right-hand side of the edge (as seen in the direction of the messages)
+	lineHeight := float64(MessageFontSize + MessageBaselineOffset)
+	for _, d := range directions {
+		p1, p2 := positions[d.From], positions[d.To]
+		dx, dy := p2.X-p1.X, p2.Y-p1.Y
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			//message to self: labels go above the node
+			p := positions[d.From]
+			for idx, label := range labels[d] {
+				fmt.Fprintf(w, `<text x="%.1f" y="%.1f" font-size="%d" text-anchor="middle">%s</text>`,
+					p.X, p.Y-LabelHeight-float64(len(labels[d])-1-idx)*lineHeight, MessageFontSize, label,
+				)
+			}
+			continue
+		}
+		ux, uy := dx/length, dy/length //unit vector along the edge
+		nx, ny := -uy, ux              //unit normal
+		mx, my := (p1.X+p2.X)/2+nx*CommunicationLabelShift, (p1.Y+p2.Y)/2+ny*CommunicationLabelShift
+		fmt.Fprintf(w, `<line x1="%.1f" x2="%.1f" y1="%.1f" y2="%.1f" stroke="black" marker-end="url(#filled)" />`,
+			mx-ux*CommunicationArrowSize/2, mx+ux*(CommunicationArrowSize/2-ArrowTipSize),
+			my-uy*CommunicationArrowSize/2, my+uy*(CommunicationArrowSize/2-ArrowTipSize),
+		)
+
+		//stack the labels away from the edge
+		y := my + lineHeight
+		if ny < 0 {
+			y = my - MessageBaselineOffset - float64(len(labels[d])-1)*lineHeight
+		}
+		anchor := "middle"
+		switch {
+		case nx > 0.5:
+			anchor = "start"
+		case nx < -0.5:
+			anchor = "end"
+		}
+		for idx, label := range labels[d] {
+			fmt.Fprintf(w, `<text x="%.1f" y="%.1f" font-size="%d" text-anchor="%s">%s</text>`,
+				mx+nx*MessageBaselineOffset, y+float64(idx)*lineHeight, MessageFontSize, anchor, label,
+			)
+		}
+	}
+
+	//nodes
+	for _, actor := range actors {
+		p := positions[actor.Name]
+		fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%d" height="%d" stroke="black" fill="white" />`,
+			p.X-LabelWidth/2, p.Y-LabelHeight/2, LabelWidth, LabelHeight,
+		)
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" font-size="%g" text-anchor="middle">%s</text>`,
+			p.X, p.Y+0.25*LabelHeight, 0.7*LabelHeight, escapeText(actor.Label, opts),
+		)
+	}
+
+	fmt.Fprintln(w, `</svg>`)
+}
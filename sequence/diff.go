@@ -0,0 +1,169 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// messageKey identifies corresponding messages in two versions of a diagram.
+func messageKey(msg *Message) string {
+	return fmt.Sprintf("%s %s %s %s", msg.Kind, msg.SenderName, msg.ReceiverName, msg.Label)
+}
+
+// Diff matches the messages of both diagrams by kind, actors and
+// label (using a longest common subsequence in send order). Unmatched messages
+// of the new diagram are colored as added. Unmatched messages of the old
+// diagram are inserted into the new diagram as removed, right after the
+// preceding matched message. Returns a description of all changes.
+func Diff(oldDiagram, newDiagram *Diagram) (changes []string, err error) {
+	defer catchError(&err)
+	oldMessages, newMessages := sortedMessages(oldDiagram.Messages), sortedMessages(newDiagram.Messages)
+
+	//lcs[i][j] = length of LCS of oldMessages[i:] and newMessages[j:]
+	lcs := make([][]int, len(oldMessages)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newMessages)+1)
+	}
+	for i := len(oldMessages) - 1; i >= 0; i-- {
+		for j := len(newMessages) - 1; j >= 0; j-- {
+			switch {
+			case messageKey(oldMessages[i]) == messageKey(newMessages[j]):
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	removed := make(map[uint][]*Message) //key = time in new diagram after which the message is inserted
+	var anchor uint
+	i, j := 0, 0
+	for i < len(oldMessages) || j < len(newMessages) {
+		switch {
+		case i < len(oldMessages) && j < len(newMessages) && messageKey(oldMessages[i]) == messageKey(newMessages[j]):
+			anchor = newMessages[j].SenderTime
+			i++
+			j++
+		case j < len(newMessages) && (i == len(oldMessages) || lcs[i][j+1] >= lcs[i+1][j]):
+			newMessages[j].Color = "green"
+			changes = append(changes, "+ "+describeMessage(newMessages[j]))
+			j++
+		default:
+			removed[anchor] = append(removed[anchor], oldMessages[i])
+			changes = append(changes, "- "+describeMessage(oldMessages[i]))
+			i++
+		}
+	}
+
+	//insert removed messages starting with the latest anchor, so that the
+	//insertion does not shift the anchors that remain to be processed
+	anchors := make([]uint, 0, len(removed))
+	for t := range removed {
+		anchors = append(anchors, t)
+	}
+	sort.Slice(anchors, func(i, j int) bool { return anchors[i] > anchors[j] })
+	endpoint := func(name string) string {
+		if isGate(name) {
+			return name
+		}
+		return makeActor(name, newDiagram.Actors).Name
+	}
+	for _, t := range anchors {
+		newDiagram.insertTime(t, uint(len(removed[t])))
+		for idx, oldMsg := range removed[t] {
+			msg := &Message{
+				Kind:         oldMsg.Kind,
+				Name:         oldMsg.Name,
+				Label:        oldMsg.Label,
+				SenderName:   endpoint(oldMsg.SenderName),
+				ReceiverName: endpoint(oldMsg.ReceiverName),
+				SenderTime:   t + uint(idx) + 1,
+				ReceiverTime: t + uint(idx) + 1,
+				TimedOut:     oldMsg.TimedOut,
+				Color:        "red",
+			}
+			newDiagram.Messages[uniqueMessageName(msg.Name, newDiagram.Messages)] = msg
+		}
+	}
+
+	return changes, nil
+}
+
+func describeMessage(msg *Message) string {
+	return fmt.Sprintf("%s %s -> %s: %s", msg.Kind, msg.SenderName, msg.ReceiverName, msg.Label)
+}
+
+// insertTime inserts `count` units of time after the given point in time, by
+// moving all later events.
+func (diagram *Diagram) insertTime(after, count uint) {
+	shift := func(t *uint) {
+		if *t > after {
+			*t += count
+		}
+	}
+	for _, actor := range diagram.Actors {
+		for _, activity := range actor.Activities {
+			shift(&activity.StartTime)
+			shift(&activity.StopTime)
+		}
+	}
+	for _, msg := range diagram.Messages {
+		shift(&msg.SenderTime)
+		shift(&msg.ReceiverTime)
+	}
+	for _, gap := range diagram.Gaps {
+		shift(&gap.StartTime)
+		shift(&gap.StopTime)
+	}
+	for _, timer := range diagram.Timers {
+		shift(&timer.SetTime)
+		shift(&timer.StopTime)
+	}
+	for _, state := range diagram.States {
+		shift(&state.Time)
+	}
+	for _, coregion := range diagram.Coregions {
+		shift(&coregion.StartTime)
+		shift(&coregion.StopTime)
+	}
+	for _, annotation := range diagram.Annotations {
+		shift(&annotation.Time)
+	}
+	for _, divider := range diagram.Dividers {
+		shift(&divider.Time)
+	}
+
+	timestamps := make(map[uint]time.Duration, len(diagram.Timestamps))
+	for t, ts := range diagram.Timestamps {
+		shift(&t)
+		timestamps[t] = ts
+	}
+	diagram.Timestamps = timestamps
+	spacings := make(map[uint]uint, len(diagram.Spacings))
+	for t, spacing := range diagram.Spacings {
+		shift(&t)
+		spacings[t] = spacing
+	}
+	diagram.Spacings = spacings
+}
@@ -0,0 +1,147 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// messageCounts counts the messages between each pair of actors, by sender
+// and receiver name. Messages from or to the diagram border are not counted.
+func messageCounts(diagram *Diagram) map[[2]string]uint {
+	counts := make(map[[2]string]uint)
+	for _, msg := range diagram.Messages {
+		if diagram.Actors[msg.SenderName] == nil || diagram.Actors[msg.ReceiverName] == nil {
+			continue
+		}
+		counts[[2]string{msg.SenderName, msg.ReceiverName}]++
+	}
+	return counts
+}
+
+// renderDOT writes a Graphviz digraph with one node per actor and one edge
+// per pair of sender and receiver, weighted by the number of messages.
+func renderDOT(w io.Writer, diagram *Diagram) {
+	counts := messageCounts(diagram)
+	edges := make([][2]string, 0, len(counts))
+	for edge := range counts {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i][0] != edges[j][0] {
+			return edges[i][0] < edges[j][0]
+		}
+		return edges[i][1] < edges[j][1]
+	})
+
+	fmt.Fprintln(w, "digraph {")
+	if diagram.Title != "" {
+		fmt.Fprintf(w, "    label=%s;\n", strconv.Quote(diagram.Title))
+	}
+	fmt.Fprintln(w, "    node [shape=box];")
+	for _, actor := range sortedActors(diagram.Actors) {
+		fmt.Fprintf(w, "    %s [label=%s];\n", strconv.Quote(actor.Name), strconv.Quote(actor.Label))
+	}
+	for _, edge := range edges {
+		count := counts[edge]
+		fmt.Fprintf(w, "    %s -> %s [weight=%d, label=\"%d\", penwidth=%d];\n",
+			strconv.Quote(edge[0]), strconv.Quote(edge[1]), count, count, dotPenWidth(count),
+		)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// dotPenWidth makes edges with more messages thicker, but not too thick.
+func dotPenWidth(count uint) uint {
+	if count > 5 {
+		return 5
+	}
+	return count
+}
+
+// renderCSV writes a matrix of message counts, with one row per sender and
+// one column per receiver (both in display order).
+func renderCSV(w io.Writer, diagram *Diagram) {
+	counts := messageCounts(diagram)
+	actors := sortedActors(diagram.Actors)
+
+	out := csv.NewWriter(w)
+	header := []string{"sender/receiver"}
+	for _, actor := range actors {
+		header = append(header, actor.Name)
+	}
+	failIfErr(out.Write(header))
+	for _, sender := range actors {
+		row := []string{sender.Name}
+		for _, receiver := range actors {
+			row = append(row, strconv.FormatUint(uint64(counts[[2]string{sender.Name, receiver.Name}]), 10))
+		}
+		failIfErr(out.Write(row))
+	}
+	out.Flush()
+	failIfErr(out.Error())
+}
+
+// renderEvents writes a tab-separated list of all message events in
+// chronological order. Each message yields one event when it is sent (with
+// the message kind) and one when it is received (kind "receive") or times out
+// (kind "timeout").
+func renderEvents(w io.Writer, diagram *Diagram) {
+	type event struct {
+		Time    uint
+		Kind    string
+		Message *Message
+	}
+	var events []event
+	for _, msg := range sortedMessages(diagram.Messages) {
+		events = append(events, event{msg.SenderTime, msg.Kind, msg})
+		switch {
+		case msg.TimedOut:
+			events = append(events, event{msg.ReceiverTime, "timeout", msg})
+		case msg.ReceiverName != "":
+			events = append(events, event{msg.ReceiverTime, "receive", msg})
+		}
+	}
+	//within the same tick, sends come before receives and timeouts; otherwise,
+	//the stable sort keeps the events in the order of sortedMessages
+	isSend := func(e event) bool { return e.Kind != "receive" && e.Kind != "timeout" }
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Time != events[j].Time {
+			return events[i].Time < events[j].Time
+		}
+		return isSend(events[i]) && !isSend(events[j])
+	})
+
+	fmt.Fprintln(w, "tick\tkind\tsender\treceiver\tlabel")
+	for _, e := range events {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			e.Time, e.Kind, e.Message.SenderName, e.Message.ReceiverName, tsvText(e.Message.Label),
+		)
+	}
+}
+
+// tsvText replaces characters that would break the tab-separated format.
+func tsvText(text string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(text)
+}
@@ -0,0 +1,691 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LeftBorder and RightBorder are used as SenderName or ReceiverName of
+// messages whose endpoint lies on the left or right border of the diagram
+// instead of on a lifeline (e.g. messages to actors hidden with -hide).
+const (
+	LeftBorder  = "["
+	RightBorder = "]"
+)
+
+// Environment can be used in the input instead of LeftBorder, for messages
+// from and to the world outside of the modeled system (user interactions,
+// external webhooks etc.).
+const Environment = "ENV"
+
+// isGate returns whether the name refers to the diagram border instead of an
+// actor. In the input, messages can be sent from and received at the border
+// (a "gate" in UML terms, see -frame).
+func isGate(name string) bool {
+	return name == LeftBorder || name == RightBorder
+}
+
+// gateName resolves the pseudo-actor names that refer to the diagram border in
+// the input. It returns "" for names of actual actors.
+func gateName(name string) string {
+	switch name {
+	case LeftBorder, RightBorder:
+		return name
+	case Environment:
+		return LeftBorder
+	default:
+		return ""
+	}
+}
+
+// splitList parses a comma-separated list of names, as given to -only and -hide.
+func splitList(list string) map[string]bool {
+	result := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+// filterDiagrams applies -only and -hide to all diagrams.
+func filterDiagrams(diagrams []*Diagram, opts *Options) {
+	only, hide := splitList(opts.Only), splitList(opts.Hide)
+	if len(only) == 0 && len(hide) == 0 {
+		return
+	}
+	switch opts.HiddenMessages {
+	case "drop", "border":
+	default:
+		fail("unknown value for -hidden-messages: %s", opts.HiddenMessages)
+	}
+
+	//every given actor must appear in at least one of the diagrams
+	for _, names := range []map[string]bool{only, hide} {
+		for name := range names {
+			found := false
+			for _, diagram := range diagrams {
+				if _, exists := diagram.Actors[name]; exists {
+					found = true
+				}
+			}
+			if !found {
+				fail("unknown actor: %s", name)
+			}
+		}
+	}
+
+	for _, diagram := range diagrams {
+		diagram.filterActors(func(name string) bool {
+			return (len(only) == 0 || only[name]) && !hide[name]
+		}, opts.HiddenMessages == "border")
+	}
+}
+
+// filterActors projects the diagram onto the actors for which isVisible
+// returns true. Messages between hidden actors are removed. Messages between
+// a visible and a hidden actor are either removed as well, or (if toBorder is
+// set) redirected to the diagram border on the side of the hidden actor.
+func (diagram *Diagram) filterActors(isVisible func(name string) bool, toBorder bool) {
+	isShown := func(name string) bool {
+		return isGate(name) || isVisible(name)
+	}
+	isOnLifeline := func(name string) bool {
+		return !isGate(name) && isVisible(name)
+	}
+	//which border to use for an endpoint at the hidden actor, as seen from the other endpoint
+	borderFor := func(hiddenName, otherName string) string {
+		hidden, other := diagram.Actors[hiddenName], diagram.Actors[otherName]
+		if other == nil {
+			//the other endpoint is already on a border
+			if otherName == LeftBorder {
+				return RightBorder
+			}
+			return LeftBorder
+		}
+		if hidden.DisplayOrder < other.DisplayOrder {
+			return LeftBorder
+		}
+		return RightBorder
+	}
+
+	if toBorder {
+		for _, msg := range diagram.Messages {
+			senderShown, receiverShown := isShown(msg.SenderName), isShown(msg.ReceiverName)
+			switch {
+			case senderShown && !receiverShown:
+				msg.ReceiverName = borderFor(msg.ReceiverName, msg.SenderName)
+				msg.ReceiverLayer = 0
+			case !senderShown && receiverShown:
+				msg.SenderName = borderFor(msg.SenderName, msg.ReceiverName)
+				msg.SenderLayer = 0
+			}
+		}
+	}
+	diagram.removeMessages(func(msg *Message) bool {
+		return !isShown(msg.SenderName) || !isShown(msg.ReceiverName)
+	})
+
+	var timers []*Timer
+	for _, timer := range diagram.Timers {
+		if isVisible(timer.ActorName) {
+			timers = append(timers, timer)
+		}
+	}
+	diagram.Timers = timers
+
+	var states []*StateInvariant
+	for _, state := range diagram.States {
+		if isVisible(state.ActorName) {
+			states = append(states, state)
+		}
+	}
+	diagram.States = states
+	var coregions []*Coregion
+	for _, coregion := range diagram.Coregions {
+		if isVisible(coregion.ActorName) {
+			coregions = append(coregions, coregion)
+		}
+	}
+	diagram.Coregions = coregions
+
+	var constraints []*Constraint
+	for _, constraint := range diagram.Constraints {
+		//both ends must be on a lifeline to be measured
+		if isOnLifeline(constraint.From.ActorName()) && isOnLifeline(constraint.To.ActorName()) {
+			constraints = append(constraints, constraint)
+		}
+	}
+	diagram.Constraints = constraints
+	var orderings []*GeneralOrdering
+	for _, ordering := range diagram.Orderings {
+		if isOnLifeline(ordering.Before.ActorName()) && isOnLifeline(ordering.After.ActorName()) {
+			orderings = append(orderings, ordering)
+		}
+	}
+	diagram.Orderings = orderings
+
+	var legend []*LegendEntry
+	for _, entry := range diagram.Legend {
+		if isVisible(entry.ActorName) {
+			legend = append(legend, entry)
+		}
+	}
+	diagram.Legend = legend
+
+	for name := range diagram.Actors {
+		if !isVisible(name) {
+			delete(diagram.Actors, name)
+		}
+	}
+	diagram.compactDisplayOrder()
+}
+
+// removeMessages removes all messages for which drop returns true, along with
+// all constraints and orderings that refer to them.
+func (diagram *Diagram) removeMessages(drop func(*Message) bool) {
+	dropped := make(map[*Message]bool)
+	for name, msg := range diagram.Messages {
+		if drop(msg) {
+			dropped[msg] = true
+			delete(diagram.Messages, name)
+		}
+	}
+
+	var constraints []*Constraint
+	for _, constraint := range diagram.Constraints {
+		if !dropped[constraint.From.Message] && !dropped[constraint.To.Message] {
+			constraints = append(constraints, constraint)
+		}
+	}
+	diagram.Constraints = constraints
+
+	var orderings []*GeneralOrdering
+	for _, ordering := range diagram.Orderings {
+		if !dropped[ordering.Before.Message] && !dropped[ordering.After.Message] {
+			orderings = append(orderings, ordering)
+		}
+	}
+	diagram.Orderings = orderings
+}
+
+// compactDisplayOrder closes the gaps in the display order that are left
+// behind when actors are removed.
+func (diagram *Diagram) compactDisplayOrder() {
+	for idx, actor := range sortedActors(diagram.Actors) {
+		actor.DisplayOrder = uint(idx)
+	}
+}
+
+// MergeGroup is a set of actors that is rendered as a single composite actor
+// (see -merge).
+type MergeGroup struct {
+	Members []string
+	Target  string
+}
+
+// mergeFlagValue collects the arguments of all -merge options.
+type mergeFlagValue []MergeGroup
+
+func (groups *mergeFlagValue) String() string {
+	var parts []string
+	for _, group := range *groups {
+		parts = append(parts, strings.Join(group.Members, ",")+"="+group.Target)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (groups *mergeFlagValue) Set(value string) error {
+	idx := strings.LastIndex(value, "=")
+	if idx < 0 {
+		return fmt.Errorf(`expected "actor1,actor2,...=name", got %q`, value)
+	}
+	group := MergeGroup{Target: strings.TrimSpace(value[idx+1:])}
+	for name := range splitList(value[:idx]) {
+		group.Members = append(group.Members, name)
+	}
+	sort.Strings(group.Members)
+	if len(group.Members) == 0 || group.Target == "" {
+		return fmt.Errorf(`expected "actor1,actor2,...=name", got %q`, value)
+	}
+	*groups = append(*groups, group)
+	return nil
+}
+
+// mergeActorGroups applies all -merge options to all diagrams.
+func mergeActorGroups(diagrams []*Diagram, groups []MergeGroup) {
+	merged := make(map[string]bool)
+	for _, group := range groups {
+		for _, name := range group.Members {
+			if merged[name] {
+				fail("actor %s appears in multiple -merge groups", name)
+			}
+			merged[name] = true
+			found := false
+			for _, diagram := range diagrams {
+				if _, exists := diagram.Actors[name]; exists {
+					found = true
+				}
+			}
+			if !found {
+				fail("unknown actor: %s", name)
+			}
+		}
+	}
+
+	for _, diagram := range diagrams {
+		for _, group := range groups {
+			diagram.mergeActors(group)
+		}
+	}
+}
+
+// mergeActors replaces the members of the group with a single actor that
+// takes the place of the leftmost member. Messages between members are
+// removed, and the activities of all members are stacked on the new lifeline.
+func (diagram *Diagram) mergeActors(group MergeGroup) {
+	isMember := make(map[string]bool)
+	var members []*Actor
+	for _, name := range group.Members {
+		if actor, exists := diagram.Actors[name]; exists {
+			isMember[name] = true
+			members = append(members, actor)
+		}
+	}
+	if len(members) == 0 {
+		return
+	}
+	if _, exists := diagram.Actors[group.Target]; exists && !isMember[group.Target] {
+		fail("cannot merge actors into %s: an actor with this name already exists", group.Target)
+	}
+
+	composite := &Actor{Name: group.Target, Label: group.Target, DisplayOrder: members[0].DisplayOrder, Lifeline: members[0].Lifeline, HeadShape: members[0].HeadShape, Stereotype: members[0].Stereotype, Multiplicity: members[0].Multiplicity}
+	for _, member := range members {
+		if member.DisplayOrder < composite.DisplayOrder {
+			composite.DisplayOrder = member.DisplayOrder
+		}
+		if member.Name == group.Target {
+			composite.Label = member.Label
+		}
+		composite.Activities = append(composite.Activities, member.Activities...)
+		delete(diagram.Actors, member.Name)
+	}
+	diagram.Actors[composite.Name] = composite
+	composite.restackActivities()
+	lane := newLaneIndex(composite.Activities)
+
+	diagram.removeMessages(func(msg *Message) bool {
+		return isMember[msg.SenderName] && isMember[msg.ReceiverName]
+	})
+	for _, msg := range diagram.Messages {
+		switch {
+		case isMember[msg.SenderName]:
+			msg.SenderName = composite.Name
+			msg.SenderLayer = lane.layerAt(msg.SenderTime)
+		case isMember[msg.ReceiverName]:
+			msg.ReceiverName = composite.Name
+			msg.ReceiverLayer = lane.layerAt(msg.ReceiverTime)
+		}
+	}
+
+	for _, timer := range diagram.Timers {
+		if isMember[timer.ActorName] {
+			timer.ActorName = composite.Name
+		}
+	}
+	for _, state := range diagram.States {
+		if isMember[state.ActorName] {
+			state.ActorName = composite.Name
+		}
+	}
+	for _, coregion := range diagram.Coregions {
+		if isMember[coregion.ActorName] {
+			coregion.ActorName = composite.Name
+		}
+	}
+
+	//the composite actor keeps only the first legend entry of its members
+	var legend []*LegendEntry
+	hasEntry := false
+	for _, entry := range diagram.Legend {
+		if isMember[entry.ActorName] {
+			if hasEntry {
+				continue
+			}
+			entry.ActorName = composite.Name
+			hasEntry = true
+		}
+		legend = append(legend, entry)
+	}
+	diagram.Legend = legend
+
+	diagram.compactDisplayOrder()
+}
+
+// restackActivities recomputes the layers of the actor's activities, such
+// that each activity is drawn on top of all activities that are still running
+// when it starts.
+func (actor *Actor) restackActivities() {
+	activities := actor.Activities
+	sort.SliceStable(activities, func(i, j int) bool {
+		if activities[i].StartTime != activities[j].StartTime {
+			return activities[i].StartTime < activities[j].StartTime
+		}
+		return activities[i].StopTime > activities[j].StopTime
+	})
+
+	//sweep over the activities in order of StartTime; since each activity is
+	//stacked on top of all running ones, the layers in use are always 0..N-1
+	//for some N, so a count per layer is enough to find the topmost one
+	var running runningActivities
+	var countPerLayer []int
+	for _, activity := range activities {
+		for running.Len() > 0 && running[0].StopTime <= activity.StartTime {
+			countPerLayer[heap.Pop(&running).(*Activity).Layer]--
+		}
+		for len(countPerLayer) > 0 && countPerLayer[len(countPerLayer)-1] == 0 {
+			countPerLayer = countPerLayer[:len(countPerLayer)-1]
+		}
+		activity.Layer = uint(len(countPerLayer))
+		countPerLayer = append(countPerLayer, 1)
+		heap.Push(&running, activity)
+	}
+}
+
+// focusDiagrams implements -focus: for each given actor, each diagram that
+// contains it is replaced by a view showing only that actor, its direct
+// neighbors, and the messages exchanged between the actor and its neighbors.
+func focusDiagrams(diagrams []*Diagram, focus string) []*Diagram {
+	names := splitList(focus)
+	if len(names) == 0 {
+		return diagrams
+	}
+	//keep the order in which the actors were given
+	var order []string
+	for _, name := range strings.Split(focus, ",") {
+		name = strings.TrimSpace(name)
+		if names[name] {
+			order = append(order, name)
+			delete(names, name)
+		}
+	}
+
+	var result []*Diagram
+	for _, name := range order {
+		found := false
+		for _, diagram := range diagrams {
+			if _, exists := diagram.Actors[name]; exists {
+				found = true
+				result = append(result, diagram.focus(name))
+			}
+		}
+		if !found {
+			fail("unknown actor: %s", name)
+		}
+	}
+	return result
+}
+
+// focus returns a copy of the diagram that is projected onto the given actor
+// and its direct neighbors.
+func (diagram *Diagram) focus(name string) *Diagram {
+	result := diagram.Clone()
+	result.removeMessages(func(msg *Message) bool {
+		return msg.SenderName != name && msg.ReceiverName != name
+	})
+	neighbors := map[string]bool{name: true}
+	for _, msg := range result.Messages {
+		neighbors[msg.SenderName] = true
+		neighbors[msg.ReceiverName] = true
+	}
+	result.filterActors(func(name string) bool { return neighbors[name] }, false)
+
+	label := result.Actors[name].Label
+	if result.Title == "" {
+		result.Title = label
+	} else {
+		result.Title += ": " + label
+	}
+	return result
+}
+
+// Clone returns a deep copy of the diagram.
+func (diagram *Diagram) Clone() *Diagram {
+	result := &Diagram{
+		Title:      diagram.Title,
+		Actors:     make(map[string]*Actor, len(diagram.Actors)),
+		Messages:   make(map[string]*Message, len(diagram.Messages)),
+		Timestamps: make(map[uint]time.Duration, len(diagram.Timestamps)),
+		Spacings:   make(map[uint]uint, len(diagram.Spacings)),
+	}
+	for name, actor := range diagram.Actors {
+		copied := &Actor{Name: actor.Name, Label: actor.Label, DisplayOrder: actor.DisplayOrder, ActivityCount: actor.ActivityCount, Lifeline: actor.Lifeline, Declared: actor.Declared, HeadShape: actor.HeadShape, Stereotype: actor.Stereotype, Multiplicity: actor.Multiplicity}
+		for _, activity := range actor.Activities {
+			a := *activity
+			copied.Activities = append(copied.Activities, &a)
+		}
+		result.Actors[name] = copied
+	}
+	copiedMessages := make(map[*Message]*Message, len(diagram.Messages))
+	for name, msg := range diagram.Messages {
+		m := *msg
+		copiedMessages[msg] = &m
+		result.Messages[name] = &m
+	}
+	for name, actor := range diagram.Actors {
+		if actor.BlockedByCall != nil {
+			result.Actors[name].BlockedByCall = copiedMessages[actor.BlockedByCall]
+		}
+	}
+	for _, gap := range diagram.Gaps {
+		g := *gap
+		result.Gaps = append(result.Gaps, &g)
+	}
+	for _, constraint := range diagram.Constraints {
+		c := *constraint
+		c.From.Message = copiedMessages[c.From.Message]
+		c.To.Message = copiedMessages[c.To.Message]
+		result.Constraints = append(result.Constraints, &c)
+	}
+	for _, ordering := range diagram.Orderings {
+		o := *ordering
+		o.Before.Message = copiedMessages[o.Before.Message]
+		o.After.Message = copiedMessages[o.After.Message]
+		result.Orderings = append(result.Orderings, &o)
+	}
+	for _, timer := range diagram.Timers {
+		t := *timer
+		result.Timers = append(result.Timers, &t)
+	}
+	for _, state := range diagram.States {
+		s := *state
+		result.States = append(result.States, &s)
+	}
+	for _, coregion := range diagram.Coregions {
+		c := *coregion
+		result.Coregions = append(result.Coregions, &c)
+	}
+	for _, annotation := range diagram.Annotations {
+		a := *annotation
+		result.Annotations = append(result.Annotations, &a)
+	}
+	for _, divider := range diagram.Dividers {
+		d := *divider
+		result.Dividers = append(result.Dividers, &d)
+	}
+	for _, entry := range diagram.Legend {
+		e := *entry
+		result.Legend = append(result.Legend, &e)
+	}
+	for t, ts := range diagram.Timestamps {
+		result.Timestamps[t] = ts
+	}
+	for t, spacing := range diagram.Spacings {
+		result.Spacings[t] = spacing
+	}
+	return result
+}
+
+// splitPhases implements -split-phases: each diagram with dividers is followed
+// by one diagram per phase, i.e. per span of time before, between or after
+// the dividers.
+func splitPhases(diagrams []*Diagram) []*Diagram {
+	var result []*Diagram
+	for _, diagram := range diagrams {
+		result = append(result, diagram)
+		if len(diagram.Dividers) == 0 {
+			continue
+		}
+		dividers := append([]*Divider(nil), diagram.Dividers...)
+		sort.SliceStable(dividers, func(i, j int) bool { return dividers[i].Time < dividers[j].Time })
+
+		//the phase before the first divider is only rendered if something happens in it
+		maxTime := diagram.lastEventTime()
+		if dividers[0].Time > 0 {
+			phase := diagram.crop(0, dividers[0].Time)
+			if len(phase.Actors) > 0 {
+				result = append(result, phase)
+			}
+		}
+		for idx, divider := range dividers {
+			end := maxTime + 1
+			if idx+1 < len(dividers) {
+				end = dividers[idx+1].Time
+			}
+			phase := diagram.crop(divider.Time, end)
+			if divider.Label != "" {
+				if phase.Title == "" {
+					phase.Title = divider.Label
+				} else {
+					phase.Title += ": " + divider.Label
+				}
+			}
+			result = append(result, phase)
+		}
+	}
+	return result
+}
+
+// crop returns a copy of the diagram that only shows the span of time [start,
+// end), and only the actors that are active or exchange messages during that
+// time. Activities that extend beyond the span are cut off, and messages that
+// are not sent and received within the span are removed. Dividers are
+// removed as well.
+func (diagram *Diagram) crop(start, end uint) *Diagram {
+	result := diagram.Clone()
+	isInside := func(t uint) bool { return t >= start && t < end }
+	clip := func(t uint) uint {
+		switch {
+		case t < start:
+			return start
+		case t >= end:
+			return end - 1
+		default:
+			return t
+		}
+	}
+
+	result.removeMessages(func(msg *Message) bool {
+		return !isInside(msg.SenderTime) || !isInside(msg.ReceiverTime)
+	})
+	isActive := make(map[string]bool)
+	for _, msg := range result.Messages {
+		isActive[msg.SenderName] = true
+		isActive[msg.ReceiverName] = true
+	}
+	for name, actor := range result.Actors {
+		var activities []*Activity
+		for _, activity := range actor.Activities {
+			if activity.StopTime < start || activity.StartTime >= end {
+				continue
+			}
+			activity.StartTime, activity.StopTime = clip(activity.StartTime), clip(activity.StopTime)
+			activities = append(activities, activity)
+			isActive[name] = true
+		}
+		actor.Activities = activities
+	}
+
+	var gaps []*Gap
+	for _, gap := range result.Gaps {
+		if isInside(gap.StartTime) && gap.StopTime <= end {
+			gaps = append(gaps, gap)
+		}
+	}
+	result.Gaps = gaps
+	var timers []*Timer
+	for _, timer := range result.Timers {
+		if isInside(timer.SetTime) {
+			if timer.StopTime != 0 {
+				timer.StopTime = clip(timer.StopTime)
+			}
+			timers = append(timers, timer)
+		}
+	}
+	result.Timers = timers
+	var states []*StateInvariant
+	for _, state := range result.States {
+		if isInside(state.Time) {
+			states = append(states, state)
+			isActive[state.ActorName] = true
+		}
+	}
+	result.States = states
+	var coregions []*Coregion
+	for _, coregion := range result.Coregions {
+		if coregion.StopTime >= start && coregion.StartTime < end {
+			coregion.StartTime, coregion.StopTime = clip(coregion.StartTime), clip(coregion.StopTime)
+			coregions = append(coregions, coregion)
+		}
+	}
+	result.Coregions = coregions
+	var annotations []*Annotation
+	for _, annotation := range result.Annotations {
+		if isInside(annotation.Time) {
+			annotations = append(annotations, annotation)
+		}
+	}
+	result.Annotations = annotations
+	result.Dividers = nil
+	for t := range result.Timestamps {
+		if !isInside(t) {
+			delete(result.Timestamps, t)
+		}
+	}
+	for t := range result.Spacings {
+		if !isInside(t) {
+			delete(result.Spacings, t)
+		}
+	}
+
+	mapping := make(map[uint]uint, end-start)
+	for t := start; t < end; t++ {
+		mapping[t] = t - start
+	}
+	result.remapTime(mapping)
+	result.filterActors(func(name string) bool { return isActive[name] }, false)
+	return result
+}
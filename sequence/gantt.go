@@ -0,0 +1,94 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// renderGantt writes a Mermaid Gantt chart with one section per actor and one
+// task per activity. Since activities are measured in logical time, each unit
+// of time is rendered as one second.
+func renderGantt(w io.Writer, diagram *Diagram) {
+	actors := sortedActors(diagram.Actors)
+	calls := diagram.callsByActivity()
+
+	fmt.Fprintf(w, "gantt\n    dateFormat X\n    axisFormat %%s\n")
+	for _, actor := range actors {
+		if len(actor.Activities) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "    section %s\n", ganttText(actor.Label))
+		for idx, activity := range actor.Activities {
+			fmt.Fprintf(w, "    %s : %d, %d\n",
+				ganttText(activityName(calls, activity, idx)), activity.StartTime, activity.StopTime,
+			)
+		}
+	}
+}
+
+// activityKey identifies an activity by its actor, start time and layer.
+type activityKey struct {
+	ActorName string
+	StartTime uint
+	Layer     uint
+}
+
+// callsByActivity finds the calls that started activities (i.e. that were
+// received by the activity's actor at its start time, on its layer). If
+// multiple calls match one activity, the first one in sortedMessages() wins.
+func (diagram *Diagram) callsByActivity() map[*Activity]*Message {
+	calls := make(map[activityKey]*Message)
+	for _, msg := range sortedMessages(diagram.Messages) {
+		key := activityKey{msg.ReceiverName, msg.ReceiverTime, msg.ReceiverLayer}
+		if _, exists := calls[key]; !exists && msg.Kind == "call" && !msg.TimedOut {
+			calls[key] = msg
+		}
+	}
+
+	result := make(map[*Activity]*Message)
+	for _, actor := range diagram.Actors {
+		for _, activity := range actor.Activities {
+			if msg, exists := calls[activityKey{actor.Name, activity.StartTime, activity.Layer}]; exists {
+				result[activity] = msg
+			}
+		}
+	}
+	return result
+}
+
+// activityName describes an activity by its label, or else by the call that
+// started it, if any.
+func activityName(calls map[*Activity]*Message, activity *Activity, idx int) string {
+	if activity.Label != "" {
+		return activity.Label
+	}
+	if msg, exists := calls[activity]; exists {
+		return msg.Label
+	}
+	return fmt.Sprintf("activity %d", idx+1)
+}
+
+// ganttText removes characters that have a special meaning in Mermaid task
+// names and section titles.
+func ganttText(text string) string {
+	return strings.NewReplacer(":", " ", "#", " ", ";", " ").Replace(text)
+}
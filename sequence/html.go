@@ -0,0 +1,241 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// messageAnchor returns the HTML id of the message with the given key in
+// Diagram.Messages, e.g. "msg-login". In the "html" output format, a message
+// can be linked to as "diagram.html#msg-login".
+func messageAnchor(name string) string {
+	//the keys of messages that reuse a name contain "#" (see uniqueMessageName)
+	return "msg-" + strings.ReplaceAll(name, "#", "-")
+}
+
+// htmlIndexEntry is a row in the message index of renderHTML.
+type htmlIndexEntry struct {
+	Anchor       string
+	Number       int
+	Kind         string
+	SenderName   string
+	Sender       string //label of the sender
+	ReceiverName string
+	Receiver     string //label of the receiver
+	Label        string
+}
+
+// renderHTML writes a standalone HTML page with the diagram as inline SVG,
+// followed by an index of all messages that links to the message arrows.
+// Each message arrow is an anchor (see messageAnchor), so that documents can
+// deep-link to a specific step in the diagram. The diagram is not split into
+// pages (-max-height and -max-width are ignored), since anchors must be unique
+// within the page.
+func renderHTML(w io.Writer, diagram *Diagram, opts *Options) {
+	renderHTMLPage(w, diagram, opts, nil)
+}
+
+// ExportHTML renders each diagram in the source into a self-contained HTML
+// page like the "html" output format, but with scripts for zooming, panning
+// and highlighting, and with the source of the diagram, so that the page can
+// be explored offline (e.g. as an attachment to an email or a ticket).
+func ExportHTML(source []byte, opts *Options) (pages []Page, err error) {
+	defer catchError(&err)
+	diagrams := transform(parsePages(bytes.NewReader(source)), opts)
+	if opts.Redact {
+		source = nil //would defeat the purpose of -redact
+	}
+
+	var result []pageWriter
+	for _, diagram := range diagrams {
+		diagram := diagram
+		result = append(result, func(w io.Writer) {
+			renderHTMLPage(w, diagram, opts, &interactiveHTML{Source: string(source)})
+		})
+	}
+	return exportPages(result), nil
+}
+
+// interactiveHTML contains the parts of the page that are only rendered by
+// ExportHTML.
+type interactiveHTML struct {
+	Source string //empty if the source shall not be included
+}
+
+func renderHTMLPage(w io.Writer, diagram *Diagram, opts *Options, interactive *interactiveHTML) {
+	svgOpts := *opts
+	svgOpts.MessageAnchors = true
+	body := renderSVGBody(diagram, &svgOpts)
+	if opts.WarnOverlaps {
+		warnOverlaps(diagram, body.Layout)
+	}
+	var svg bytes.Buffer
+	writeSVGHeader(&svg, body.Width, body.Height)
+	svg.Write(body.Content)
+	fmt.Fprintln(&svg, `</svg>`)
+
+	actorLabel := func(name string) string {
+		if actor, exists := diagram.Actors[name]; exists {
+			return actor.Label
+		}
+		return "(border)"
+	}
+	var index []htmlIndexEntry
+	for _, name := range sortedMessageNames(diagram.Messages) {
+		msg := diagram.Messages[name]
+		if !msg.isDrawn(opts) {
+			continue
+		}
+		index = append(index, htmlIndexEntry{
+			Anchor:       messageAnchor(name),
+			Number:       len(index) + 1,
+			Kind:         msg.Kind,
+			SenderName:   msg.SenderName,
+			Sender:       actorLabel(msg.SenderName),
+			ReceiverName: msg.ReceiverName,
+			Receiver:     actorLabel(msg.ReceiverName),
+			Label:        strings.Join(labelLines(msg.Label), " "),
+		})
+	}
+
+	title := diagram.Title
+	if title == "" {
+		title = "Sequence diagram"
+	}
+	failIfErr(htmlTemplate.Execute(w, map[string]interface{}{
+		"Title":       title,
+		"SVG":         template.HTML(svg.String()),
+		"Index":       index,
+		"Interactive": interactive,
+	}))
+}
+
+var htmlTemplate = template.Must(template.New("diagram.html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.diagram { overflow-x: auto; }
+g:target line, g:target path { stroke: #d33; stroke-width: 3; }
+g:target text { fill: #d33; font-weight: bold; }
+{{- if .Interactive }}
+g.highlight line, g.highlight path { stroke: #d33; stroke-width: 3; }
+g.highlight text { fill: #d33; font-weight: bold; }
+.diagram svg { cursor: grab; border: 1px solid #ddd; }
+.toolbar { margin-bottom: 0.5em; }
+td a[data-actor] { color: inherit; }
+pre { background: #f6f6f6; padding: 1em; overflow-x: auto; }
+{{- end }}
+table { border-collapse: collapse; margin-top: 2em; }
+td, th { padding: 0.2em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+{{- if .Interactive }}
+<div class="toolbar">
+<button type="button" data-zoom="0.8">+</button>
+<button type="button" data-zoom="1.25">&minus;</button>
+<button type="button" data-zoom="0">reset</button>
+<small>scroll to zoom, drag to pan, click an actor in the table to highlight its messages</small>
+</div>
+{{- end }}
+<div class="diagram">
+{{ .SVG }}
+</div>
+<table>
+<tr><th>#</th><th>From</th><th>To</th><th>Kind</th><th>Message</th></tr>
+{{- range .Index }}
+<tr data-message="{{ .Anchor }}"><td><a href="#{{ .Anchor }}">{{ .Number }}</a></td><td><a href="#" data-actor="{{ .SenderName }}">{{ .Sender }}</a></td><td><a href="#" data-actor="{{ .ReceiverName }}">{{ .Receiver }}</a></td><td>{{ .Kind }}</td><td><a href="#{{ .Anchor }}">{{ .Label }}</a></td></tr>
+{{- end }}
+</table>
+{{- with .Interactive }}
+{{- if .Source }}
+<h2>Source</h2>
+<pre>{{ .Source }}</pre>
+{{- end }}
+<script>
+(function() {
+  var svg = document.querySelector(".diagram svg");
+  var width = svg.width.baseVal.value, height = svg.height.baseVal.value;
+  var view;
+  function setView(v) {
+    view = v;
+    svg.setAttribute("viewBox", v.x + " " + v.y + " " + v.w + " " + v.h);
+  }
+  function reset() { setView({x: 0, y: 0, w: width, h: height}); }
+  //zoom by the factor f around the point (px, py) in diagram coordinates
+  function zoom(f, px, py) {
+    setView({x: px - (px - view.x) * f, y: py - (py - view.y) * f, w: view.w * f, h: view.h * f});
+  }
+  reset();
+
+  svg.addEventListener("wheel", function(e) {
+    e.preventDefault();
+    var r = svg.getBoundingClientRect();
+    zoom(e.deltaY < 0 ? 0.8 : 1.25, view.x + (e.clientX - r.left) / r.width * view.w, view.y + (e.clientY - r.top) / r.height * view.h);
+  }, {passive: false});
+  var drag = null;
+  svg.addEventListener("mousedown", function(e) { drag = {x: e.clientX, y: e.clientY}; e.preventDefault(); });
+  window.addEventListener("mouseup", function() { drag = null; });
+  window.addEventListener("mousemove", function(e) {
+    if (!drag) return;
+    var r = svg.getBoundingClientRect();
+    setView({x: view.x - (e.clientX - drag.x) / r.width * view.w, y: view.y - (e.clientY - drag.y) / r.height * view.h, w: view.w, h: view.h});
+    drag = {x: e.clientX, y: e.clientY};
+  });
+  svg.addEventListener("dblclick", reset);
+  document.querySelectorAll("button[data-zoom]").forEach(function(button) {
+    button.addEventListener("click", function() {
+      var f = parseFloat(button.dataset.zoom);
+      if (f) { zoom(f, view.x + view.w / 2, view.y + view.h / 2); } else { reset(); }
+    });
+  });
+
+  //highlight the message arrows for which test returns true
+  function highlight(test) {
+    svg.querySelectorAll("g[data-from]").forEach(function(g) { g.classList.toggle("highlight", test(g)); });
+  }
+  var selectedActor = null;
+  document.querySelectorAll("a[data-actor]").forEach(function(a) {
+    a.addEventListener("click", function(e) {
+      e.preventDefault();
+      selectedActor = selectedActor === a.dataset.actor ? null : a.dataset.actor;
+      highlight(function(g) { return g.dataset.from === selectedActor || g.dataset.to === selectedActor; });
+    });
+  });
+  document.querySelectorAll("tr[data-message]").forEach(function(tr) {
+    tr.addEventListener("mouseenter", function() { highlight(function(g) { return g.id === tr.dataset.message; }); });
+    tr.addEventListener("mouseleave", function() {
+      highlight(function(g) { return g.dataset.from === selectedActor || g.dataset.to === selectedActor; });
+    });
+  });
+})();
+</script>
+{{- end }}
+</body>
+</html>
+`))
@@ -0,0 +1,133 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	CheckpointLines = 1000 //number of lines between two parser checkpoints
+	MaxCheckpoints  = 16
+)
+
+// parseCheckpoint is a snapshot of the parser state after a prefix of the input.
+type parseCheckpoint struct {
+	Offset   int //length of the prefix, in bytes
+	Line     int //number of lines in the prefix
+	LastLine int //number of the last non-empty line in the prefix
+	State    *Parser
+}
+
+// IncrementalParser parses successive versions of the same input. When only
+// the tail of the input changes, parsing resumes from the last checkpoint
+// within the unchanged prefix instead of starting over (see
+// BenchmarkIncrementalParser). Only parsing is incremental: the layout and
+// the output are always computed for the whole diagram, and the "serve"
+// subcommand does not use this at all, since it parses each request on its
+// own.
+type IncrementalParser struct {
+	Input       []byte //previous version of the input
+	Checkpoints []parseCheckpoint
+}
+
+// Clone returns a deep copy of the parser state.
+func (p *Parser) Clone() *Parser {
+	result := *p
+	result.Diagram = p.Diagram.Clone()
+	result.Pending = append([]autoReceive(nil), p.Pending...)
+	//timers are cloned in order, so running timers can be found by their index
+	timerIndex := make(map[*Timer]int, len(p.Diagram.Timers))
+	for idx, timer := range p.Diagram.Timers {
+		timerIndex[timer] = idx
+	}
+	result.RunningTimers = make(map[string]*Timer, len(p.RunningTimers))
+	for key, timer := range p.RunningTimers {
+		result.RunningTimers[key] = result.Diagram.Timers[timerIndex[timer]]
+	}
+	return &result
+}
+
+// Parse returns the diagrams for the given version of the input. Inputs with
+// multiple pages are always parsed completely.
+func (ip *IncrementalParser) Parse(input []byte) (diagrams []*Diagram, err error) {
+	defer catchError(&err)
+	return ip.parse(input), nil
+}
+
+// Reset discards all checkpoints, so that the next version of the input is
+// parsed from scratch.
+func (ip *IncrementalParser) Reset() {
+	ip.Input, ip.Checkpoints = nil, nil
+}
+
+func (ip *IncrementalParser) parse(input []byte) []*Diagram {
+	//like Parse, accept byte order marks, UTF-16 and CRLF line endings
+	input = []byte(decodeInputBytes(input))
+	if bytes.Contains(input, []byte("newpage")) {
+		ip.Reset()
+		return parsePages(bytes.NewReader(input))
+	}
+
+	//discard checkpoints after the first change
+	common := 0
+	for common < len(input) && common < len(ip.Input) && input[common] == ip.Input[common] {
+		common++
+	}
+	for len(ip.Checkpoints) > 0 && ip.Checkpoints[len(ip.Checkpoints)-1].Offset > common {
+		ip.Checkpoints = ip.Checkpoints[:len(ip.Checkpoints)-1]
+	}
+	ip.Input = append(ip.Input[:0], input...)
+
+	p, offset, lineNo, lastLine := NewParser(), 0, 0, 1
+	if len(ip.Checkpoints) > 0 {
+		checkpoint := ip.Checkpoints[len(ip.Checkpoints)-1]
+		p, offset = checkpoint.State.Clone(), checkpoint.Offset
+		lineNo, lastLine = checkpoint.Line, checkpoint.LastLine
+	}
+
+	//since edits usually happen near the end, checkpoints are only taken
+	//within the last lines (taking a checkpoint requires a full copy of the
+	//parser state)
+	lines := strings.SplitAfter(string(input[offset:]), "\n")
+	firstCheckpoint := len(lines) - MaxCheckpoints*CheckpointLines
+	for idx, line := range lines {
+		lineNo++
+		if strings.TrimSpace(line) != "" {
+			lastLine = lineNo
+		}
+		if msg := catchFailure(func() { p.parseLine(line) }); msg != "" {
+			fail(ParseError{Line: lineNo, Message: msg}.String())
+		}
+		offset += len(line)
+		//only checkpoint after complete lines, since the last line may still be extended
+		if idx >= firstCheckpoint && (idx+1)%CheckpointLines == 0 && strings.HasSuffix(line, "\n") {
+			ip.Checkpoints = append(ip.Checkpoints, parseCheckpoint{Offset: offset, Line: lineNo, LastLine: lastLine, State: p.Clone()})
+			if len(ip.Checkpoints) > MaxCheckpoints {
+				ip.Checkpoints = ip.Checkpoints[1:]
+			}
+		}
+	}
+	//errors at the end of the input are reported on the last non-empty line
+	if msg := catchFailure(p.finish); msg != "" {
+		fail(ParseError{Line: lastLine, Message: msg}.String())
+	}
+	return []*Diagram{p.Diagram}
+}
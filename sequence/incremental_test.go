@@ -0,0 +1,151 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// watchTestBlock is repeated to build the inputs for TestIncrementalParser,
+// with "N" replaced by the number of the repetition. Its lines leave different
+// parts of the parser state open: a running timer, a pending auto-receive (in
+// a "together" block) and a legend.
+var watchTestBlock = []string{
+	"timer set a tN",
+	"together",
+	"send! a sN b x",
+	"send a mN y",
+	"state b busy",
+	"end",
+	"receive b mN",
+	"timer expire a tN",
+	"legend",
+	"a client",
+	"end",
+}
+
+// watchTestInput returns an input of the given number of blocks, padded with
+// comments such that the line before the first checkpoint is the given line
+// of watchTestBlock.
+func watchTestInput(blocks, lineInBlock int) []string {
+	lines := []string{"option auto-tick", "start a", "start b"}
+	for (CheckpointLines-1-len(lines))%len(watchTestBlock) != lineInBlock {
+		lines = append(lines, "# padding")
+	}
+	for idx := 0; idx < blocks; idx++ {
+		for _, line := range watchTestBlock {
+			lines = append(lines, strings.ReplaceAll(line, "N", strconv.Itoa(idx)))
+		}
+	}
+	return append(lines, "stop a", "stop b")
+}
+
+func TestIncrementalParser(t *testing.T) {
+	//each edit is applied to the input of the previous one
+	edits := []struct {
+		Name string
+		Edit func(lines []string) []string
+	}{
+		{"append before the end", func(lines []string) []string {
+			return append(lines[:len(lines)-2:len(lines)-2], "send b late z", "receive a late", "stop a", "stop b")
+		}},
+		{"change the last line", func(lines []string) []string {
+			return append(lines[:len(lines)-1:len(lines)-1], "stop b # done")
+		}},
+		{"change the line after the checkpoint", func(lines []string) []string {
+			return replaceLine(lines, CheckpointLines, "# changed")
+		}},
+		{"change the line before the checkpoint", func(lines []string) []string {
+			return replaceLine(lines, CheckpointLines-2, "# changed")
+		}},
+		{"truncate at the checkpoint", func(lines []string) []string {
+			return lines[:CheckpointLines:CheckpointLines]
+		}},
+		{"restore after the checkpoint", func(lines []string) []string {
+			return watchTestInput(200, 0)
+		}},
+		{"change the first line", func(lines []string) []string {
+			return replaceLine(lines, 0, "option auto-tick # again")
+		}},
+	}
+
+	for lineInBlock, construct := range watchTestBlock {
+		t.Run(fmt.Sprintf("checkpoint after %q", construct), func(t *testing.T) {
+			ip := &IncrementalParser{}
+			lines := watchTestInput(200, lineInBlock)
+			checkIncrementalParse(t, "initial", ip, lines)
+			if len(ip.Checkpoints) == 0 {
+				t.Fatal("no checkpoints were taken")
+			}
+			for _, edit := range edits {
+				lines = edit.Edit(lines)
+				checkIncrementalParse(t, edit.Name, ip, lines)
+			}
+		})
+	}
+}
+
+func replaceLine(lines []string, idx int, line string) []string {
+	result := append([]string(nil), lines...)
+	result[idx] = line
+	return result
+}
+
+// checkIncrementalParse checks that the incremental parser yields the same
+// diagrams or errors as a full parse.
+func checkIncrementalParse(t *testing.T, name string, ip *IncrementalParser, lines []string) {
+	t.Helper()
+	input := strings.Join(lines, "\n") + "\n"
+	var expected, actual []*Diagram
+	expectedMsg := catchFailure(func() { expected = parsePages(strings.NewReader(input)) })
+	actualMsg := catchFailure(func() { actual = ip.parse([]byte(input)) })
+	switch {
+	case actualMsg != expectedMsg:
+		t.Errorf("%s: expected error %q, got %q", name, expectedMsg, actualMsg)
+	case !reflect.DeepEqual(actual, expected):
+		t.Errorf("%s: incremental parse differs from full parse", name)
+	}
+}
+
+// BenchmarkIncrementalParser measures a refresh in the "watch" subcommand
+// after appending to a large input, with and without the checkpoints.
+func BenchmarkIncrementalParser(b *testing.B) {
+	lines := watchTestInput(2000, 0)
+	before := []byte(strings.Join(lines, "\n") + "\n")
+	after := []byte(strings.Join(append(lines[:len(lines)-2:len(lines)-2], "send a late z", "receive b late", "stop a", "stop b"), "\n") + "\n")
+
+	b.Run("full", func(b *testing.B) {
+		for b.Loop() {
+			(&IncrementalParser{}).parse(after)
+		}
+	})
+	b.Run("incremental", func(b *testing.B) {
+		ip := &IncrementalParser{}
+		for b.Loop() {
+			b.StopTimer()
+			ip.parse(before)
+			b.StartTimer()
+			ip.parse(after)
+		}
+	})
+}
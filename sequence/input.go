@@ -0,0 +1,140 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// DecodeInput returns a reader for the diagram source in the given input,
+// which is converted to UTF-8 with "\n" line endings. The input may start with
+// a byte order mark. Input encoded in UTF-16 is recognized by its byte order
+// mark. Windows-authored files often have one, and always have CRLF line
+// endings, both of which would otherwise end up in the first command or the
+// last argument of each line.
+func DecodeInput(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	bom, _ := br.Peek(3)
+	var decoded io.Reader = br
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}):
+		br.Discard(3)
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}):
+		br.Discard(2)
+		decoded = &utf16Reader{R: br, Order: binary.LittleEndian}
+	case bytes.HasPrefix(bom, []byte{0xFE, 0xFF}):
+		br.Discard(2)
+		decoded = &utf16Reader{R: br, Order: binary.BigEndian}
+	}
+	return &lineEndingReader{R: bufio.NewReader(decoded)}
+}
+
+// decodeInputBytes is like DecodeInput, but for input that has been read
+// completely already.
+func decodeInputBytes(input []byte) string {
+	decoded, err := io.ReadAll(DecodeInput(bytes.NewReader(input)))
+	failIfErr(err)
+	return string(decoded)
+}
+
+// checkEncoding fails with a helpful message if the line is not valid UTF-8.
+// NUL characters are valid UTF-8, but never appear in diagram sources unless
+// these are actually encoded in UTF-16.
+func checkEncoding(line string) {
+	if strings.IndexByte(line, 0) >= 0 {
+		fail("input contains NUL characters (UTF-16 input needs to start with a byte order mark)")
+	}
+	if !utf8.ValidString(line) {
+		fail("input is not valid UTF-8: %q", line)
+	}
+}
+
+// utf16Reader converts UTF-16 into UTF-8.
+type utf16Reader struct {
+	R       *bufio.Reader
+	Order   binary.ByteOrder
+	Pending []byte //converted text that did not fit into the caller's buffer
+}
+
+func (u *utf16Reader) Read(buf []byte) (int, error) {
+	var err error
+	for len(u.Pending) < len(buf) && err == nil {
+		var r rune
+		r, err = u.readRune()
+		if err == nil {
+			u.Pending = utf8.AppendRune(u.Pending, r)
+		}
+	}
+	n := copy(buf, u.Pending)
+	u.Pending = u.Pending[n:]
+	if n > 0 {
+		return n, nil //the error (if any) is returned again by the next call
+	}
+	return 0, err
+}
+
+func (u *utf16Reader) readRune() (rune, error) {
+	r1, err := u.readUnit()
+	if err != nil || !utf16.IsSurrogate(r1) {
+		return r1, err
+	}
+	r2, err := u.readUnit()
+	if err != nil {
+		return 0, err
+	}
+	return utf16.DecodeRune(r1, r2), nil
+}
+
+func (u *utf16Reader) readUnit() (rune, error) {
+	var unit [2]byte
+	_, err := io.ReadFull(u.R, unit[:])
+	if err == io.ErrUnexpectedEOF {
+		return 0, errors.New("UTF-16 input ends in the middle of a character")
+	}
+	return rune(u.Order.Uint16(unit[:])), err
+}
+
+// lineEndingReader converts CRLF line endings into LF.
+type lineEndingReader struct {
+	R       *bufio.Reader
+	Pending []byte
+}
+
+func (l *lineEndingReader) Read(buf []byte) (int, error) {
+	if len(l.Pending) == 0 {
+		line, err := l.R.ReadBytes('\n')
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			line = append(line[:len(line)-2], '\n')
+		}
+		if len(line) == 0 {
+			return 0, err
+		}
+		l.Pending = line
+	}
+	n := copy(buf, l.Pending)
+	l.Pending = l.Pending[n:]
+	return n, nil
+}
@@ -0,0 +1,126 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import "sort"
+
+// This file contains the data structures for questions of the form "what
+// overlaps with this point or interval on the same lifeline". Layout passes
+// should use them instead of comparing all pairs of elements, since
+// machine-generated diagrams easily contain tens of thousands of activities on
+// a single lifeline.
+
+// laneIndex is a segment tree over the activities of one actor. It answers
+// layerAt() queries in O(log n).
+type laneIndex struct {
+	//distinct start and stop times of all activities, in ascending order; the
+	//leaves of the tree alternate between these points and the open intervals
+	//between them (leaf 2*i = Times[i], leaf 2*i+1 = between Times[i] and Times[i+1])
+	Times []uint
+	//for each node, 1 + the maximum layer of all activities that cover the
+	//node's whole range (0 = no activity)
+	Nodes  []uint
+	Leaves int
+}
+
+func newLaneIndex(activities []*Activity) *laneIndex {
+	idx := &laneIndex{}
+	seen := make(map[uint]bool)
+	for _, activity := range activities {
+		for _, t := range []uint{activity.StartTime, activity.StopTime} {
+			if !seen[t] {
+				seen[t] = true
+				idx.Times = append(idx.Times, t)
+			}
+		}
+	}
+	sort.Slice(idx.Times, func(i, j int) bool { return idx.Times[i] < idx.Times[j] })
+
+	idx.Leaves = 2 * len(idx.Times)
+	idx.Nodes = make([]uint, 2*idx.Leaves)
+	for _, activity := range activities {
+		//range update on the half-open leaf range [lo, hi)
+		lo := 2*idx.position(activity.StartTime) + idx.Leaves
+		hi := 2*idx.position(activity.StopTime) + 1 + idx.Leaves
+		for ; lo < hi; lo, hi = lo/2, hi/2 {
+			if lo%2 == 1 {
+				idx.cover(lo, activity.Layer+1)
+				lo++
+			}
+			if hi%2 == 1 {
+				hi--
+				idx.cover(hi, activity.Layer+1)
+			}
+		}
+	}
+	return idx
+}
+
+// position returns the index of the given time in idx.Times. The time must be
+// one of the indexed times.
+func (idx *laneIndex) position(t uint) int {
+	return sort.Search(len(idx.Times), func(i int) bool { return idx.Times[i] >= t })
+}
+
+func (idx *laneIndex) cover(node int, value uint) {
+	if idx.Nodes[node] < value {
+		idx.Nodes[node] = value
+	}
+}
+
+// layerAt returns the layer of the topmost activity that is running at the
+// given time, or 0 if none is running.
+func (idx *laneIndex) layerAt(t uint) uint {
+	pos := idx.position(t)
+	var leaf int
+	switch {
+	case pos < len(idx.Times) && idx.Times[pos] == t:
+		leaf = 2 * pos
+	case pos == 0 || pos == len(idx.Times):
+		return 0 //before the first or after the last activity
+	default:
+		leaf = 2*(pos-1) + 1
+	}
+
+	var result uint
+	for node := leaf + idx.Leaves; node > 0; node /= 2 {
+		if idx.Nodes[node] > result {
+			result = idx.Nodes[node]
+		}
+	}
+	if result == 0 {
+		return 0
+	}
+	return result - 1
+}
+
+// runningActivities is a min-heap of activities ordered by StopTime, used by
+// sweeps over the activities of one actor in order of StartTime.
+type runningActivities []*Activity
+
+func (h runningActivities) Len() int            { return len(h) }
+func (h runningActivities) Less(i, j int) bool  { return h[i].StopTime < h[j].StopTime }
+func (h runningActivities) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runningActivities) Push(x interface{}) { *h = append(*h, x.(*Activity)) }
+func (h *runningActivities) Pop() interface{} {
+	old := *h
+	activity := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return activity
+}
@@ -0,0 +1,87 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// benchmarkDiagram returns a diagram with 50k messages: calls from one caller
+// to two workers w0 and w1 (alternating), and their returns. Each call
+// starts an activity on the worker.
+func benchmarkDiagram(b *testing.B) *Diagram {
+	b.Helper()
+	var buf strings.Builder
+	buf.WriteString("start c\nstart w0\nstart w1\n\n")
+	for i := 0; i < 25000; i++ {
+		worker := fmt.Sprintf("w%d", i%2)
+		fmt.Fprintf(&buf, "call c m%d x\nreceive %s m%d\n\nreturn %s r%d y\nreceive c r%d\n\n", i, worker, i, worker, i, i)
+	}
+	buf.WriteString("stop c\nstop w0\nstop w1\n")
+	diagrams := parsePages(strings.NewReader(buf.String()))
+	if len(diagrams) != 1 || len(diagrams[0].Messages) != 50000 {
+		b.Fatal("unexpected benchmark diagram")
+	}
+	return diagrams[0]
+}
+
+// mergedActivities returns the activities of both workers in benchmarkDiagram
+// on one lifeline, as -merge w0,w1=W would put them.
+func mergedActivities(diagram *Diagram) []*Activity {
+	var activities []*Activity
+	for _, name := range []string{"w0", "w1"} {
+		activities = append(activities, diagram.Actors[name].Activities...)
+	}
+	return activities
+}
+
+func BenchmarkRestackActivities(b *testing.B) {
+	activities := mergedActivities(benchmarkDiagram(b))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		actor := &Actor{Name: "W", Activities: append([]*Activity(nil), activities...)}
+		actor.restackActivities()
+	}
+}
+
+func BenchmarkLayerAt(b *testing.B) {
+	diagram := benchmarkDiagram(b)
+	actor := &Actor{Name: "W", Activities: mergedActivities(diagram)}
+	actor.restackActivities()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lane := newLaneIndex(actor.Activities)
+		for _, msg := range diagram.Messages {
+			lane.layerAt(msg.ReceiverTime)
+		}
+	}
+}
+
+func BenchmarkMergeActors(b *testing.B) {
+	source := benchmarkDiagram(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		diagram := source.Clone()
+		b.StartTimer()
+		diagram.mergeActors(MergeGroup{Members: []string{"w0", "w1"}, Target: "W"})
+	}
+}
@@ -0,0 +1,83 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+// Limits restricts the size of diagrams while they are parsed, so that
+// untrusted input (e.g. in the "serve" subcommand) cannot use up all memory
+// or CPU time before the diagram is complete. Zero values mean no limit.
+type Limits struct {
+	Actors     int
+	Messages   int
+	Ticks      uint
+	LineLength int  //in bytes; also limits the length of labels
+	Nesting    uint //activities of the same actor
+	Spacing    uint //argument of the "spacing" command
+}
+
+// MaxServeSpacing is the limit for "spacing" in the "serve" subcommand. Along
+// with -max-ticks, it limits the height of diagrams.
+const MaxServeSpacing = 1000
+
+// exceeded fails with the given message, and makes parseRecovering stop at
+// this error. Continuing after exceeding a limit would defeat its purpose.
+func (p *Parser) exceeded(msg string, args ...interface{}) {
+	p.Aborted = true
+	fail(msg, args...)
+}
+
+// checkLineLimits is called by parseLine before each line is parsed.
+func (p *Parser) checkLineLimits(line string) {
+	if l := p.Limits; l != nil && l.LineLength > 0 && len(line) > l.LineLength {
+		p.exceeded("line too long: %d bytes (limit is %d)", len(line), l.LineLength)
+	}
+}
+
+// checkCommandLimits is called by parseLine after each command.
+func (p *Parser) checkCommandLimits(fields []string) {
+	l := p.Limits
+	if l == nil {
+		return
+	}
+	diagram := p.Diagram
+	if l.Actors > 0 && len(diagram.Actors) > l.Actors {
+		p.exceeded("too many actors: %d (limit is %d)", len(diagram.Actors), l.Actors)
+	}
+	if l.Messages > 0 && len(diagram.Messages) > l.Messages {
+		p.exceeded("too many messages: %d (limit is %d)", len(diagram.Messages), l.Messages)
+	}
+	if l.Nesting > 0 {
+		//only actors named in this command can have started an activity
+		for _, field := range fields {
+			if actor, exists := diagram.Actors[field]; exists && actor.ActivityCount > l.Nesting {
+				p.exceeded("too many nested activities of actor %s: %d (limit is %d)", actor.Name, actor.ActivityCount, l.Nesting)
+			}
+		}
+	}
+	if l.Spacing > 0 && fields[0] == "spacing" && diagram.Spacings[p.Time] > l.Spacing {
+		p.exceeded("spacing too large: %d (limit is %d)", diagram.Spacings[p.Time], l.Spacing)
+	}
+}
+
+// checkTimeLimits is called by advanceTime. Since "delay" advances the time
+// step by step, this also stops huge delays early.
+func (p *Parser) checkTimeLimits() {
+	if l := p.Limits; l != nil && l.Ticks > 0 && p.Time > l.Ticks {
+		p.exceeded("too many ticks: %d (limit is %d)", p.Time, l.Ticks)
+	}
+}
@@ -0,0 +1,106 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fuzzLimits are the limits for fuzzed input, chosen like the defaults of the
+// "serve" subcommand, but smaller to keep each iteration fast.
+func fuzzLimits() *Limits {
+	return &Limits{
+		Actors:     20,
+		Messages:   200,
+		Ticks:      1000,
+		LineLength: 1000,
+		Nesting:    20,
+		Spacing:    MaxServeSpacing,
+	}
+}
+
+// addFuzzSeeds adds the example and one small input per command.
+func addFuzzSeeds(f *testing.F) {
+	example, err := os.ReadFile("../example.txt")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(string(example))
+	for _, seed := range []string{
+		"start a\nstart b\nsend a m1 hello\nreceive b m1\nstop a\nstop b\n",
+		"start a\ncall a m1 x\nreceive b m1\n\nreturn b m2 y\nreceive a m2\nstop a\n",
+		"option auto-tick\nstart a\nstart b\nsend a m1 x; receive b m1\nconstraint m1.send m1.receive {1s}\nstop a\nstop b\n",
+		"start a\ndelay 3 waiting\nskip\nspacing 40\ndivider phase 2\nstop a\n",
+		"start a\ntimer set a t1 5s\n\ntimer expire a t1\nstop a\n",
+		"start a\nsend a m1 x\ntimeout m1 b\nstate a idle\ncoregion begin a\n\ncoregion end a\nstop a\n",
+		"newpage first\nstart a\nstop a\nnewpage second\nstart b\nstop b\n",
+		"legend\na the client\nend\nstart a\nannotate note\nstop a\n",
+		"participant a Client\nstyle a color=red head=ellipse multiplicity=*\nstart a\nstart b\nsend! a m1 b x\n\nstop a\nstop b\n",
+		"start a\nsend [ m1 x\nreceive a m1\nsend a m2 y\nreceive ] m2\nbefore m1.receive m2.send\nstop a\n",
+	} {
+		f.Add(seed)
+	}
+}
+
+// FuzzParse checks that no input makes the parser crash (instead of failing
+// with an error message) or exceed the limits.
+func FuzzParse(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, input string) {
+		catchFailure(func() {
+			parsePagesLimited(strings.NewReader(input), fuzzLimits())
+		})
+	})
+}
+
+// FuzzRender checks that every diagram that can be parsed can also be
+// rendered in every output format.
+func FuzzRender(f *testing.F) {
+	addFuzzSeeds(f)
+	var formats []string
+	for format := range OutputExtensions {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		var diagrams []*Diagram
+		if msg := catchFailure(func() { diagrams = parsePagesLimited(strings.NewReader(input), fuzzLimits()) }); msg != "" {
+			t.Skip(msg)
+		}
+		for _, format := range formats {
+			opts := DefaultOptions()
+			opts.Format = format
+			for _, diagram := range diagrams {
+				msg := catchFailure(func() {
+					for _, page := range renderPages(diagram, opts) {
+						page(io.Discard)
+					}
+				})
+				if msg != "" {
+					t.Errorf("cannot render valid input as %s: %s", format, msg)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,259 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"sort"
+	"time"
+)
+
+// combinedTick is a point in time of one of the diagrams that are combined.
+type combinedTick struct {
+	Part      int //index into the list of diagrams
+	Time      uint
+	Timestamp time.Duration //only if all diagrams have timestamps
+}
+
+// Combine merges several diagrams (e.g. partial traces of different
+// services) into one. Actors with the same name are unified. The points in
+// time of all diagrams are interleaved by their wall-clock timestamps if all
+// diagrams have them, or by their logical time otherwise. Inconsistencies
+// between the diagrams are reported as warnings.
+func Combine(parts []*Diagram) (combined *Diagram, err error) {
+	defer catchError(&err)
+	useTimestamps := true
+	for _, part := range parts {
+		if len(part.Timestamps) == 0 {
+			useTimestamps = false
+		}
+	}
+
+	//collect all points in time and bring them into a common order
+	var ticks []combinedTick
+	for partIdx, part := range parts {
+		var timestamp time.Duration
+		if useTimestamps {
+			//points in time before the first timestamp are sorted as if they had that timestamp
+			timestamp = part.Timestamps[sortedTimes(part.Timestamps)[0]]
+		}
+		for t := uint(0); t <= part.lastEventTime(); t++ {
+			if ts, exists := part.Timestamps[t]; exists {
+				timestamp = ts
+			}
+			ticks = append(ticks, combinedTick{Part: partIdx, Time: t, Timestamp: timestamp})
+		}
+	}
+	sort.SliceStable(ticks, func(i, j int) bool {
+		if ticks[i].Timestamp != ticks[j].Timestamp {
+			return ticks[i].Timestamp < ticks[j].Timestamp
+		}
+		if ticks[i].Time != ticks[j].Time {
+			return ticks[i].Time < ticks[j].Time
+		}
+		return ticks[i].Part < ticks[j].Part
+	})
+
+	//points in time of different diagrams with the same timestamp (or the same
+	//logical time if there are no timestamps) are unified, so that the same
+	//event recorded in multiple traces ends up at the same point in time
+	timeMaps := make([]map[uint]uint, len(parts))
+	for idx := range timeMaps {
+		timeMaps[idx] = make(map[uint]uint)
+	}
+	var current uint
+	usedParts := make(map[int]bool)
+	for idx, tick := range ticks {
+		if idx > 0 {
+			prev := ticks[idx-1]
+			sameTick := tick.Timestamp == prev.Timestamp
+			if !useTimestamps {
+				sameTick = tick.Time == prev.Time
+			}
+			if !sameTick || usedParts[tick.Part] {
+				current++
+				usedParts = make(map[int]bool)
+			}
+		}
+		timeMaps[tick.Part][tick.Time] = current
+		usedParts[tick.Part] = true
+	}
+
+	result := &Diagram{
+		Title:      parts[0].Title,
+		Actors:     make(map[string]*Actor),
+		Messages:   make(map[string]*Message),
+		Timestamps: make(map[uint]time.Duration),
+		Spacings:   make(map[uint]uint),
+	}
+	for partIdx, part := range parts {
+		part.remapTime(timeMaps[partIdx])
+		if result.Title == "" {
+			result.Title = part.Title
+		}
+
+		//actors are ordered by their first appearance
+		for _, actor := range sortedActors(part.Actors) {
+			existing, exists := result.Actors[actor.Name]
+			if !exists {
+				actor.DisplayOrder = uint(len(result.Actors))
+				result.Actors[actor.Name] = actor
+				continue
+			}
+			if existing.Label != actor.Label {
+				warn("actor %s has different labels %q and %q", actor.Name, existing.Label, actor.Label)
+			}
+			//activities that were recorded in multiple traces are only kept once
+			known := make(map[[2]uint]int)
+			for _, activity := range existing.Activities {
+				known[[2]uint{activity.StartTime, activity.StopTime}]++
+			}
+			for _, activity := range actor.Activities {
+				key := [2]uint{activity.StartTime, activity.StopTime}
+				if known[key] > 0 {
+					known[key]--
+					continue
+				}
+				existing.Activities = append(existing.Activities, activity)
+			}
+		}
+
+		for _, name := range sortedMessageNames(part.Messages) {
+			msg := part.Messages[name]
+			if existing, exists := result.Messages[name]; exists {
+				if messageKey(existing) == messageKey(msg) && existing.SenderTime == msg.SenderTime && existing.ReceiverTime == msg.ReceiverTime {
+					continue //same message recorded in both traces
+				}
+				warn("message %s is defined differently in multiple inputs; renaming", name)
+				name = uniqueMessageName(name, result.Messages)
+			}
+			result.Messages[name] = msg
+		}
+
+		result.Gaps = append(result.Gaps, part.Gaps...)
+		result.Constraints = append(result.Constraints, part.Constraints...)
+		result.Orderings = append(result.Orderings, part.Orderings...)
+		result.Timers = append(result.Timers, part.Timers...)
+		result.States = append(result.States, part.States...)
+		result.Coregions = append(result.Coregions, part.Coregions...)
+		result.Annotations = append(result.Annotations, part.Annotations...)
+		result.Dividers = append(result.Dividers, part.Dividers...)
+		result.Legend = append(result.Legend, part.Legend...)
+		for t, ts := range part.Timestamps {
+			result.Timestamps[t] = ts
+		}
+		for t, spacing := range part.Spacings {
+			result.Spacings[t] = spacing
+		}
+	}
+
+	//activities of actors that appear in multiple inputs may now overlap
+	lanes := make(map[string]*laneIndex, len(result.Actors))
+	for _, actor := range result.Actors {
+		actor.restackActivities()
+		lanes[actor.Name] = newLaneIndex(actor.Activities)
+	}
+	for _, msg := range result.Messages {
+		if lane, exists := lanes[msg.SenderName]; exists {
+			msg.SenderLayer = lane.layerAt(msg.SenderTime)
+		}
+		if lane, exists := lanes[msg.ReceiverName]; exists {
+			msg.ReceiverLayer = lane.layerAt(msg.ReceiverTime)
+		}
+	}
+	return result, nil
+}
+
+// lastEventTime returns the latest point in time that is used by the diagram.
+func (diagram *Diagram) lastEventTime() uint {
+	max := getMaxTime(diagram)
+	for _, msg := range diagram.Messages {
+		if max < msg.ReceiverTime {
+			max = msg.ReceiverTime
+		}
+	}
+	for _, annotation := range diagram.Annotations {
+		if max < annotation.Time {
+			max = annotation.Time
+		}
+	}
+	return max
+}
+
+// remapTime replaces all points in time in the diagram according to the
+// given mapping.
+func (diagram *Diagram) remapTime(mapping map[uint]uint) {
+	remap := func(t *uint) {
+		*t = mapping[*t]
+	}
+	for _, actor := range diagram.Actors {
+		for _, activity := range actor.Activities {
+			remap(&activity.StartTime)
+			remap(&activity.StopTime)
+		}
+	}
+	for _, msg := range diagram.Messages {
+		remap(&msg.SenderTime)
+		remap(&msg.ReceiverTime)
+	}
+	for _, gap := range diagram.Gaps {
+		remap(&gap.StartTime)
+		remap(&gap.StopTime)
+	}
+	for _, timer := range diagram.Timers {
+		remap(&timer.SetTime)
+		if timer.StopTime != 0 {
+			remap(&timer.StopTime)
+		}
+	}
+	for _, state := range diagram.States {
+		remap(&state.Time)
+	}
+	for _, coregion := range diagram.Coregions {
+		remap(&coregion.StartTime)
+		if coregion.StopTime != 0 {
+			remap(&coregion.StopTime)
+		}
+	}
+	for _, annotation := range diagram.Annotations {
+		remap(&annotation.Time)
+	}
+	for _, divider := range diagram.Dividers {
+		remap(&divider.Time)
+	}
+
+	timestamps := make(map[uint]time.Duration, len(diagram.Timestamps))
+	for t, ts := range diagram.Timestamps {
+		timestamps[mapping[t]] = ts
+	}
+	diagram.Timestamps = timestamps
+	spacings := make(map[uint]uint, len(diagram.Spacings))
+	for t, spacing := range diagram.Spacings {
+		spacings[mapping[t]] = spacing
+	}
+	diagram.Spacings = spacings
+}
+
+func sortedTimes(timestamps map[uint]time.Duration) []uint {
+	result := make([]uint, 0, len(timestamps))
+	for t := range timestamps {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
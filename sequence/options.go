@@ -0,0 +1,151 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Options contains all settings that affect how diagrams are transformed and
+// rendered. The command-line interface fills an Options instance from its flags
+// (see AddFlags), library callers start from DefaultOptions(). Nothing in this
+// package looks at the flags directly, so a single process (e.g. the "serve"
+// subcommand) can render many diagrams with different options concurrently.
+type Options struct {
+	Format string
+	//layout
+	Proportional   bool
+	TimeScale      time.Duration
+	MaxGap         uint
+	MaxHeight      uint
+	MaxWidth       uint
+	PageSize       PageSize
+	PageMargin     uint //in mm, with -page-size
+	Scale          float64
+	Theme          Theme
+	Ruler          bool
+	Gridlines      bool
+	Autonumber     bool
+	MessageIndex   bool
+	NoActivations  bool
+	HideReturns    bool
+	ArrowHeads     arrowHeadsFlagValue
+	CurvedArrows   bool
+	RawLabels      bool
+	LabelPlacement LabelPlacement
+	DebugOverlay   bool
+	Frame          bool
+	//swimlanes
+	ShadeSwimlanes     bool
+	SwimlaneSeparators bool
+	ActorFooters       bool
+	//actor options
+	Only           string
+	Hide           string
+	HiddenMessages string
+	Merge          mergeFlagValue
+	Focus          string
+	SplitPhases    bool
+	//redaction
+	Redact        bool
+	RedactStyle   string
+	RedactAllow   string
+	RedactKeyFile string
+	//not a flag: set by the command-line interface to report overlaps in the layout
+	WarnOverlaps bool
+	//not a flag: set by renderHTML() to give each message arrow an id (see messageAnchor)
+	MessageAnchors bool
+	//not a flag: set by the command-line interface for -timings (nil = do not measure)
+	Timings *Timings
+}
+
+// DefaultOptions returns the options that apply when no flags are given.
+func DefaultOptions() *Options {
+	return &Options{
+		Format:         "svg",
+		TimeScale:      100 * time.Millisecond,
+		MaxGap:         4,
+		HiddenMessages: "drop",
+		RedactStyle:    "hash",
+		ArrowHeads:     arrowHeadsFlagValue{"send": "half", "call": "filled", "return": "open"},
+		LabelPlacement: LabelPlacement{Anchor: "sender", Vertical: "above"},
+		PageMargin:     15,
+		Scale:          1,
+		Theme:          defaultTheme,
+	}
+}
+
+// Set changes the options given by their flag names, e.g. {"max-height":
+// "800"}. This is used by the bindings for other languages (see wasm.go and
+// capi.go).
+func (opts *Options) Set(values map[string]string) error {
+	fs := flag.NewFlagSet("options", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts.AddFlags(fs)
+	for name, value := range values {
+		if err := fs.Set(name, value); err != nil {
+			return &InputError{fmt.Sprintf("invalid option %s: %s", name, err.Error())}
+		}
+	}
+	return nil
+}
+
+// AddFlags declares a command-line flag for each option, with the current
+// value as the default.
+func (opts *Options) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&opts.Proportional, "proportional", opts.Proportional, "make vertical distances proportional to the elapsed time between timestamped events")
+	fs.DurationVar(&opts.TimeScale, "time-scale", opts.TimeScale, "with -proportional: elapsed time corresponding to one step (shorter intervals still take up one step)")
+	fs.UintVar(&opts.MaxGap, "max-gap", opts.MaxGap, "with -proportional: maximum vertical distance between two consecutive points in time (in steps)")
+	fs.StringVar(&opts.Format, "format", opts.Format, "output format: svg, gantt (Mermaid Gantt chart of activities), communication (UML communication diagram), dot (Graphviz graph of actor dependencies), csv (matrix of message counts), events (tab-separated list of message events), html (standalone page with the SVG, links to each message and an index of messages) or changes (only for the diff subcommand: list of added and removed messages)")
+	fs.BoolVar(&opts.Autonumber, "autonumber", opts.Autonumber, "prefix message labels with sequence numbers")
+	fs.BoolVar(&opts.MessageIndex, "message-index", opts.MessageIndex, "with -autonumber: render a table of all numbered messages below the diagram")
+	fs.UintVar(&opts.MaxHeight, "max-height", opts.MaxHeight, "split diagrams that are higher than this (in px) into multiple pages")
+	fs.UintVar(&opts.MaxWidth, "max-width", opts.MaxWidth, "split diagrams that are wider than this (in px) into multiple pages with groups of actors")
+	fs.Var(&opts.PageSize, "page-size", `lay out the diagram for printing on pages of this paper size: a3, a4, a5, letter or legal, optionally with ",landscape" (diagrams are scaled down to the page width, and split into multiple pages between two points in time)`)
+	fs.UintVar(&opts.PageMargin, "page-margin", opts.PageMargin, "with -page-size: margin around the diagram on each page (in mm)")
+	fs.Float64Var(&opts.Scale, "scale", opts.Scale, "with -format svg or communication: multiply the width and height of the image by this factor (e.g. 2 for high-DPI displays), so that rasterizers render it at this size with fonts and strokes scaled consistently")
+	fs.Var(dpiFlagValue{&opts.Scale}, "dpi", "same as -scale, but given as the resolution relative to 96 DPI (e.g. 192 is the same as -scale 2)")
+	fs.BoolVar(&opts.Ruler, "ruler", opts.Ruler, "render a time ruler in the left margin")
+	fs.BoolVar(&opts.Gridlines, "gridlines", opts.Gridlines, "draw faint horizontal lines at each point in time, to show which events are simultaneous")
+	fs.StringVar(&opts.Only, "only", opts.Only, "comma-separated list of actors: render only these actors")
+	fs.BoolVar(&opts.NoActivations, "no-activations", opts.NoActivations, "draw plain lifelines without activity boxes")
+	fs.BoolVar(&opts.HideReturns, "hide-returns", opts.HideReturns, "do not draw arrows for return messages")
+	fs.Var(&themeFileFlagValue{Options: opts}, "theme-file", `JSON file with the colors ("foreground", "box-fill"), font ("font-family") and vertical "spacing" of the diagram, and defaults for "arrowheads" and "label-placement" (see "theme export"); later flags override the theme`)
+	fs.Var(&opts.ArrowHeads, "arrowheads", `arrowhead for each message kind (send, call, return): open, filled, half or none, e.g. "send=open,call=filled"`)
+	fs.BoolVar(&opts.CurvedArrows, "curved-arrows", opts.CurvedArrows, "draw messages that skip over other actors as shallow arcs, and messages from an actor to itself as loops")
+	fs.Var(&opts.LabelPlacement, "label-placement", `where message labels are drawn: sender, center or receiver, and above or on-line, e.g. "center,on-line" (can be overridden per message with the "placement" command)`)
+	fs.BoolVar(&opts.RawLabels, "raw-labels", opts.RawLabels, "insert labels into the SVG without escaping, so that they can contain SVG markup (only for trusted input)")
+	fs.BoolVar(&opts.DebugOverlay, "debug-overlay", opts.DebugOverlay, "draw ticks, actor display orders, activity layers and bounding boxes on top of the diagram, to diagnose layout problems")
+	fs.BoolVar(&opts.Frame, "frame", opts.Frame, `draw the UML interaction frame around the diagram, with the title in its "sd" name tag; messages from and to "[", "]" or "ENV" start or end at the frame's edge (gates)`)
+	fs.BoolVar(&opts.ShadeSwimlanes, "shade-swimlanes", opts.ShadeSwimlanes, "fill every other actor's column with a faint background tint")
+	fs.BoolVar(&opts.SwimlaneSeparators, "swimlane-separators", opts.SwimlaneSeparators, "draw faint vertical lines between the actors' columns")
+	fs.BoolVar(&opts.ActorFooters, "actor-footers", opts.ActorFooters, "repeat the actors' label boxes at the bottom of their lifelines")
+	fs.StringVar(&opts.Focus, "focus", opts.Focus, "comma-separated list of actors: render one diagram per actor, showing only the actor and its direct neighbors")
+	fs.BoolVar(&opts.Redact, "redact", opts.Redact, "replace actor names and all labels with pseudonyms")
+	fs.StringVar(&opts.RedactStyle, "redact-style", opts.RedactStyle, "with -redact: hash (stable across runs with the same -redact-key-file) or pseudonym (numbered)")
+	fs.StringVar(&opts.RedactAllow, "redact-allow", opts.RedactAllow, "with -redact: comma-separated list of names and labels that are not replaced")
+	fs.StringVar(&opts.RedactKeyFile, "redact-key-file", opts.RedactKeyFile, "with -redact-style=hash: file with the secret key for the hashes (default: a random key for each run)")
+	fs.BoolVar(&opts.SplitPhases, "split-phases", opts.SplitPhases, "in addition to each diagram, render one diagram per phase between dividers (with only the actors active in that phase)")
+	fs.StringVar(&opts.Hide, "hide", opts.Hide, "comma-separated list of actors: do not render these actors")
+	fs.Var(&opts.Merge, "merge", `render a group of actors as one actor, e.g. "db-primary,db-replica=Database" (can be given multiple times)`)
+	fs.StringVar(&opts.HiddenMessages, "hidden-messages", opts.HiddenMessages, "with -only/-hide: how to render messages to hidden actors: drop, or border (arrow to the diagram border)")
+}
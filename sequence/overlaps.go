@@ -0,0 +1,251 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"fmt"
+	"sort"
+)
+
+// This file contains the detection of overlapping elements in the layout of a
+// diagram. The layout does not avoid overlaps by itself, so we tell the author
+// where they occur, and where an extra blank line (i.e. an extra unit of time)
+// in the input would separate the overlapping elements.
+
+// layoutItem is an element of the rendered diagram that can overlap with
+// others: a message label, a message arrow, or an activity box.
+type layoutItem struct {
+	Kind        string //"label", "arrow" or "activity"
+	Name        string //message name (for labels and arrows) or actor name (for activities)
+	Left, Right int    //bounding box
+	Top, Bottom int
+	//for arrows: the line itself
+	X1, Y1, X2, Y2 int
+	//the points in time covered by this item
+	FirstTime, LastTime uint
+}
+
+func (item layoutItem) String() string {
+	switch item.Kind {
+	case "activity":
+		return "activity of actor " + item.Name
+	default:
+		return item.Kind + " of message " + item.Name
+	}
+}
+
+// overlap describes two overlapping items in the layout.
+type overlap struct {
+	Item1, Item2 layoutItem
+}
+
+func (o overlap) String() string {
+	//the item further down is Item2 (see findOverlaps)
+	msg := fmt.Sprintf("%s overlaps %s at tick %d", o.Item2, o.Item1, o.Item2.FirstTime)
+	switch {
+	case o.Item1.LastTime < o.Item2.FirstTime:
+		return msg + fmt.Sprintf("; a blank line before tick %d would separate them", o.Item2.FirstTime)
+	case o.Item1.Kind != "activity" && o.Item2.Kind != "activity" && o.Item1.FirstTime == o.Item1.LastTime && o.Item2.FirstTime == o.Item2.LastTime:
+		return msg + "; move one of the messages to a separate line"
+	default:
+		return msg + "; blank lines cannot separate them, consider a shorter label or a different placement"
+	}
+}
+
+// warnOverlaps emits a warning for each overlap in the layout of the diagram.
+func warnOverlaps(diagram *Diagram, layout *Layout) {
+	for _, o := range findOverlaps(diagram, layout) {
+		warn(o.String())
+	}
+}
+
+// findOverlaps returns all pairs of overlapping labels, arrows and activity
+// boxes in the layout (except for arrows that cross activity boxes, which is
+// normal).
+func findOverlaps(diagram *Diagram, layout *Layout) []overlap {
+	items := layoutItems(diagram, layout)
+
+	//sweep from top to bottom, comparing each item only with the items above
+	//it that reach down far enough
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Top < items[j].Top
+	})
+	var result []overlap
+	var active []layoutItem
+	for _, item := range items {
+		remaining := active[:0]
+		for _, other := range active {
+			if other.Bottom < item.Top {
+				continue
+			}
+			remaining = append(remaining, other)
+			if overlaps(other, item) {
+				o := overlap{other, item}
+				if other.FirstTime > item.FirstTime {
+					o = overlap{item, other}
+				}
+				result = append(result, o)
+			}
+		}
+		active = append(remaining, item)
+	}
+	return result
+}
+
+// layoutItems returns the labels, arrows and activity boxes in the layout.
+// Arcs and loops (see -curved-arrows) are not included.
+func layoutItems(diagram *Diagram, layout *Layout) []layoutItem {
+	opts := layout.Options
+	var items []layoutItem
+
+	for _, name := range sortedMessageNames(diagram.Messages) {
+		message := diagram.Messages[name]
+		if !message.isDrawn(opts) {
+			continue
+		}
+		g := message.geometry(diagram.Actors[message.SenderName], diagram.Actors[message.ReceiverName], layout)
+		if g.Loop || g.Arc {
+			continue
+		}
+		first, last := message.SenderTime, message.ReceiverTime
+		if last < first {
+			first, last = last, first
+		}
+		arrow := layoutItem{
+			Kind: "arrow", Name: name,
+			X1: g.X1, Y1: g.Y1, X2: g.X2, Y2: g.Y2,
+			FirstTime: first, LastTime: last,
+		}
+		arrow.Left, arrow.Right = minMax(g.X1, g.X2)
+		arrow.Top, arrow.Bottom = minMax(g.Y1, g.Y2)
+		items = append(items, arrow)
+
+		if message.Label == "" {
+			continue
+		}
+		//the labels are measured like activity labels in drawBox()
+		lines := labelLines(message.Label)
+		width := 0
+		for idx, line := range lines {
+			if idx == 0 && message.Number > 0 {
+				line = fmt.Sprintf("%d. %s", message.Number, line)
+			}
+			if w := TextWidth(line); width < w {
+				width = w
+			}
+		}
+		halfWidth := int(float64(width)*0.6*MessageFontSize) / 2
+		//the text extends from the ascent of the first line to the baseline
+		//of the last line (see drawMessageLabel)
+		const ascent = MessageFontSize * 3 / 4
+		height := (len(lines) - 1) * MessageLineHeight
+		bottom := g.YText
+		if g.OnLine {
+			bottom += height / 2
+		}
+		items = append(items, layoutItem{
+			Kind: "label", Name: name,
+			Left: g.XText - halfWidth, Right: g.XText + halfWidth,
+			Top: bottom - height - ascent, Bottom: bottom,
+			FirstTime: message.SenderTime, LastTime: message.SenderTime,
+		})
+	}
+
+	if !opts.NoActivations {
+		for _, actor := range sortedActors(diagram.Actors) {
+			for _, activity := range actor.Activities {
+				x := int(actor.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2 + activity.Layer*ActivityOffset)
+				items = append(items, layoutItem{
+					Kind: "activity", Name: actor.Name,
+					Left: x - ActivityWidth/2, Right: x + ActivityWidth/2,
+					Top: int(layout.Y(activity.StartTime)), Bottom: int(layout.Y(activity.StopTime)),
+					FirstTime: activity.StartTime, LastTime: activity.StopTime,
+				})
+			}
+		}
+	}
+	return items
+}
+
+// overlaps checks whether two items overlap in a way that is worth a warning.
+func overlaps(item1, item2 layoutItem) bool {
+	if item1.Right <= item2.Left || item2.Right <= item1.Left || item1.Bottom < item2.Top || item2.Bottom < item1.Top {
+		return false
+	}
+	if item1.Kind > item2.Kind {
+		item1, item2 = item2, item1
+	}
+	switch item1.Kind + " " + item2.Kind {
+	case "activity activity":
+		//nested activities are drawn next to each other
+		return false
+	case "activity arrow":
+		//arrows start and end at activity boxes, or cross them on the way
+		return false
+	case "arrow arrow":
+		//only horizontal arrows can lie on top of each other
+		return item1.Name != item2.Name && item1.Y1 == item1.Y2 && item2.Y1 == item2.Y2 && item1.Y1 == item2.Y1
+	case "arrow label":
+		return item1.Name != item2.Name && lineIntersectsBox(item1, item2)
+	default:
+		//"activity label" and "label label": the bounding boxes overlap (not
+		//just touch)
+		return item1.Top < item2.Bottom && item2.Top < item1.Bottom
+	}
+}
+
+// lineIntersectsBox checks whether the line of the arrow passes through the
+// bounding box of the other item.
+func lineIntersectsBox(arrow, box layoutItem) bool {
+	//clip the line at the top and bottom edges of the box (Liang-Barsky
+	//algorithm, reduced to the vertical direction since the horizontal
+	//extent is checked below)
+	x1, y1, x2, y2 := float64(arrow.X1), float64(arrow.Y1), float64(arrow.X2), float64(arrow.Y2)
+	if y1 != y2 {
+		t1 := (float64(box.Top) - y1) / (y2 - y1)
+		t2 := (float64(box.Bottom) - y1) / (y2 - y1)
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		if t1 < 0 {
+			t1 = 0
+		}
+		if t2 > 1 {
+			t2 = 1
+		}
+		if t1 > t2 {
+			return false
+		}
+		x1, x2 = x1+t1*(x2-x1), x1+t2*(x2-x1)
+	} else if y1 < float64(box.Top) || y1 > float64(box.Bottom) {
+		return false
+	}
+	left, right := x1, x2
+	if left > right {
+		left, right = right, left
+	}
+	return right > float64(box.Left) && left < float64(box.Right)
+}
+
+func minMax(a, b int) (int, int) {
+	if a > b {
+		return b, a
+	}
+	return a, b
+}
@@ -0,0 +1,72 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"fmt"
+	"io"
+)
+
+// debugOverlayColors are the outline colors of the bounding boxes drawn by
+// drawDebugOverlay, by kind of layoutItem.
+var debugOverlayColors = map[string]string{
+	"label":    "blue",
+	"arrow":    "red",
+	"activity": "green",
+}
+
+// drawDebugOverlay implements -debug-overlay: on top of the diagram, it draws
+// the layout parameters (ticks, actors' DisplayOrder, activities' Layer) and
+// the bounding boxes that findOverlaps() works with, in a translucent layer.
+func drawDebugOverlay(w io.Writer, diagram *Diagram, maxTime uint, width int, layout *Layout) {
+	fmt.Fprint(w, `<g opacity="0.6" font-family="monospace" font-size="8" fill="magenta">`)
+
+	//ticks at the left border, with a line across the diagram
+	for t := uint(0); t <= maxTime+1; t++ {
+		y := layout.Y(t)
+		fmt.Fprintf(w, `<line x1="0" x2="%d" y1="%d" y2="%d" stroke="magenta" stroke-width="0.5" stroke-dasharray="2,4" />`, width, y, y)
+		fmt.Fprintf(w, `<text x="2" y="%d">t=%d</text>`, y-1, t)
+	}
+
+	for _, actor := range sortedActors(diagram.Actors) {
+		x := actor.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2
+		fmt.Fprintf(w, `<text x="%d" y="%d" text-anchor="middle">order=%d</text>`,
+			x, HeaderHeight-actor.headHeight()-2, actor.DisplayOrder,
+		)
+		if layout.Options.NoActivations {
+			continue
+		}
+		for _, activity := range actor.Activities {
+			xBox := x + activity.Layer*ActivityOffset + ActivityWidth/2
+			fmt.Fprintf(w, `<text x="%d" y="%d">L%d</text>`, xBox+1, layout.Y(activity.StartTime)+8, activity.Layer)
+		}
+	}
+
+	for _, item := range layoutItems(diagram, layout) {
+		height := item.Bottom - item.Top
+		if height == 0 {
+			height = 1 //horizontal arrows
+		}
+		fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="%s" stroke-width="0.5" />`,
+			item.Left, item.Top, item.Right-item.Left, height, debugOverlayColors[item.Kind],
+		)
+	}
+
+	fmt.Fprint(w, `</g>`)
+}
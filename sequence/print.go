@@ -0,0 +1,189 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// paperSizes contains the paper sizes accepted by -page-size, in mm (portrait
+// orientation).
+var paperSizes = map[string][2]float64{
+	"a3":     {297, 420},
+	"a4":     {210, 297},
+	"a5":     {148, 210},
+	"letter": {215.9, 279.4},
+	"legal":  {215.9, 355.6},
+}
+
+// PageSize is the value of -page-size, e.g. "a4" or "letter,landscape".
+type PageSize struct {
+	Name      string //key in paperSizes; empty = no print layout
+	Landscape bool
+}
+
+func (size *PageSize) String() string {
+	if size.Landscape {
+		return size.Name + ",landscape"
+	}
+	return size.Name
+}
+
+func (size *PageSize) Set(value string) error {
+	for _, word := range strings.Split(value, ",") {
+		switch word = strings.ToLower(strings.TrimSpace(word)); word {
+		case "landscape":
+			size.Landscape = true
+		case "portrait":
+			size.Landscape = false
+		default:
+			if _, exists := paperSizes[word]; !exists {
+				names := make([]string, 0, len(paperSizes))
+				for name := range paperSizes {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				return fmt.Errorf("unknown page size: %s (expected one of %s, optionally with landscape or portrait)", word, strings.Join(names, ", "))
+			}
+			size.Name = word
+		}
+	}
+	if size.Name == "" {
+		return fmt.Errorf("missing paper size in %q", value)
+	}
+	return nil
+}
+
+// dimensions returns the width and height of the paper in mm.
+func (size PageSize) dimensions() (width, height float64) {
+	dims := paperSizes[size.Name]
+	if size.Landscape {
+		return dims[1], dims[0]
+	}
+	return dims[0], dims[1]
+}
+
+// PixelsPerMM is the resolution that SVG viewers assume for absolute lengths
+// (96 px per inch).
+const PixelsPerMM = 96 / 25.4
+
+// printPages lays out the body on pages of the given paper size, with a margin
+// of `margin` mm on each side. Diagrams that are too wide for the page are
+// scaled down to fit its width, and diagrams that are too long for it are split
+// between two points in time like with -max-height, repeating the actor
+// headers on each page. The resulting SVG documents have their width and
+// height given in mm, so that they print on the paper at the right size (or
+// can be converted into one multi-page PDF with any SVG-to-PDF converter).
+func (body svgBody) printPages(diagram *Diagram, size PageSize, margin uint) []pageWriter {
+	paperWidth, paperHeight := size.dimensions()
+	areaWidth := (paperWidth - 2*float64(margin)) * PixelsPerMM
+	areaHeight := (paperHeight - 2*float64(margin)) * PixelsPerMM
+	if areaWidth <= 0 || areaHeight <= 0 {
+		fail("-page-margin %d is too large for page size %s", margin, size.String())
+	}
+
+	scale := 1.0
+	if float64(body.Width) > areaWidth {
+		scale = areaWidth / float64(body.Width)
+	}
+	maxHeight := uint(areaHeight / scale)
+	if maxHeight <= 2*HeaderHeight {
+		fail("page size %s is too small for this diagram: try landscape orientation or a smaller -page-margin", size.String())
+	}
+
+	rows := body.rowPages(diagram, maxHeight)
+	pages := make([]pageWriter, len(rows))
+	for idx, row := range rows {
+		row := row
+		pages[idx] = func(w io.Writer) {
+			fmt.Fprintf(w, `<svg version="1.1" baseProfile="full" xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="0 0 %.2f %.2f">`,
+				paperWidth, paperHeight, paperWidth*PixelsPerMM, paperHeight*PixelsPerMM,
+			)
+			offset := float64(margin) * PixelsPerMM
+			fmt.Fprintf(w, `<g transform="translate(%.2f,%.2f) scale(%.4f)">`, offset, offset, scale)
+			row(w)
+			fmt.Fprintln(w, `</g></svg>`)
+		}
+	}
+	return pages
+}
+
+// dpiFlagValue is the value of -dpi, which sets the same option as -scale.
+type dpiFlagValue struct {
+	Scale *float64
+}
+
+func (dpi dpiFlagValue) String() string {
+	if dpi.Scale == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*dpi.Scale*96, 'g', -1, 64)
+}
+
+func (dpi dpiFlagValue) Set(value string) error {
+	resolution, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	*dpi.Scale = resolution / 96
+	return nil
+}
+
+// svgSizeRx matches the size attributes that writeSVGHeader puts on the root
+// element of an SVG document.
+var svgSizeRx = regexp.MustCompile(`^(<svg [^>]*?)width="(\d+)" height="(\d+)"`)
+
+// scalePages changes the width and height of the SVG documents written by
+// the given pages by the given factor (from -scale or -dpi). The content is
+// scaled along with it through a viewBox, so that rasterizers render fonts
+// and strokes at the larger size instead of upscaling a small bitmap.
+func scalePages(pages []pageWriter, scale float64) []pageWriter {
+	if scale <= 0 || math.IsInf(scale, 0) || math.IsNaN(scale) {
+		fail("-scale must be a positive number, got %g", scale)
+	}
+	if scale == 1 {
+		return pages
+	}
+	result := make([]pageWriter, len(pages))
+	for idx, page := range pages {
+		page := page
+		result[idx] = func(w io.Writer) {
+			var buf bytes.Buffer
+			page(&buf)
+			match := svgSizeRx.FindSubmatchIndex(buf.Bytes())
+			if match == nil {
+				fail("cannot apply -scale: output does not start with an <svg> element")
+			}
+			content := buf.Bytes()
+			width, _ := strconv.ParseFloat(string(content[match[4]:match[5]]), 64)
+			height, _ := strconv.ParseFloat(string(content[match[6]:match[7]]), 64)
+			w.Write(content[:match[3]])
+			fmt.Fprintf(w, `width="%g" height="%g" viewBox="0 0 %g %g"`, width*scale, height*scale, width, height)
+			w.Write(content[match[1]:])
+		}
+	}
+	return result
+}
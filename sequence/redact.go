@@ -0,0 +1,188 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// redactor replaces names and texts with pseudonyms (see -redact). The same
+// input always yields the same replacement, so that the structure of the
+// diagrams is preserved.
+type redactor struct {
+	Style        string          //"hash" or "pseudonym"
+	Key          []byte          //for style "hash" (see redactionKey)
+	Allowed      map[string]bool //names and texts that are not replaced
+	Replacements map[string]string
+	Counters     map[string]int //by kind, only for style "pseudonym"
+}
+
+// redact returns the replacement for the given text. The kind (e.g. "actor"
+// or "message") is used as a prefix for the replacement.
+func (r *redactor) redact(kind, text string) string {
+	if text == "" || r.Allowed[text] {
+		return text
+	}
+	key := kind + " " + text
+	if replacement, exists := r.Replacements[key]; exists {
+		return replacement
+	}
+	var replacement string
+	switch r.Style {
+	case "hash":
+		//keyed, so that short names cannot be recovered by hashing candidates
+		mac := hmac.New(sha256.New, r.Key)
+		mac.Write([]byte(text))
+		replacement = kind + "-" + hex.EncodeToString(mac.Sum(nil)[:6])
+	default:
+		r.Counters[kind]++
+		replacement = fmt.Sprintf("%s-%d", kind, r.Counters[kind])
+	}
+	r.Replacements[key] = replacement
+	return replacement
+}
+
+// redactDiagrams implements -redact by replacing all actor names, labels and
+// free-form texts in the diagrams, except for those on the -redact-allow list.
+func redactDiagrams(diagrams []*Diagram, opts *Options) {
+	if !opts.Redact {
+		return
+	}
+	switch opts.RedactStyle {
+	case "hash", "pseudonym":
+	default:
+		fail("unknown value for -redact-style: %s", opts.RedactStyle)
+	}
+	r := &redactor{
+		Style:        opts.RedactStyle,
+		Key:          redactionKey(opts),
+		Allowed:      splitList(opts.RedactAllow),
+		Replacements: make(map[string]string),
+		Counters:     make(map[string]int),
+	}
+	for _, diagram := range diagrams {
+		diagram.redact(r)
+	}
+}
+
+var (
+	processRedactionKey     []byte
+	processRedactionKeyOnce sync.Once
+)
+
+// redactionKey returns the secret for the "hash" style of -redact: the
+// content of -redact-key-file, or else a random key that is generated once
+// per process. Hashes are therefore only stable across runs when the same key
+// file is given.
+func redactionKey(opts *Options) []byte {
+	if opts.RedactKeyFile != "" {
+		buf, err := os.ReadFile(opts.RedactKeyFile)
+		failIfErr(err)
+		key := bytes.TrimSpace(buf)
+		if len(key) == 0 {
+			fail("-redact-key-file: %s is empty", opts.RedactKeyFile)
+		}
+		return key
+	}
+	processRedactionKeyOnce.Do(func() {
+		processRedactionKey = make([]byte, 32)
+		_, err := rand.Read(processRedactionKey)
+		failIfErr(err)
+	})
+	return processRedactionKey
+}
+
+func (diagram *Diagram) redact(r *redactor) {
+	diagram.Title = r.redact("title", diagram.Title)
+
+	//go through everything in a fixed order, so that pseudonyms are numbered deterministically
+	actorName := func(name string) string {
+		if isGate(name) {
+			return name
+		}
+		return r.redact("actor", name)
+	}
+	actors := make(map[string]*Actor, len(diagram.Actors))
+	for _, actor := range sortedActors(diagram.Actors) {
+		if actor.Label == actor.Name {
+			actor.Label = actorName(actor.Name)
+		} else {
+			actor.Label = r.redact("label", actor.Label)
+		}
+		actor.Stereotype = r.redact("stereotype", actor.Stereotype)
+		actor.Name = actorName(actor.Name)
+		actors[actor.Name] = actor
+		for _, activity := range actor.Activities {
+			activity.Label = r.redact("activity", activity.Label)
+		}
+	}
+	diagram.Actors = actors
+
+	//message names end up in the output as anchors (see messageAnchor); event
+	//refs point to the messages, so they follow the renaming
+	messages := make(map[string]*Message, len(diagram.Messages))
+	for _, key := range sortedMessageNames(diagram.Messages) {
+		msg := diagram.Messages[key]
+		suffix := strings.TrimPrefix(key, msg.Name) //e.g. "#1" (see uniqueMessageName)
+		msg.Name = r.redact("m", msg.Name)
+		messages[msg.Name+suffix] = msg
+		msg.SenderName = actorName(msg.SenderName)
+		if msg.ReceiverName != "" {
+			msg.ReceiverName = actorName(msg.ReceiverName)
+		}
+		msg.Label = r.redact("message", msg.Label)
+	}
+	diagram.Messages = messages
+	for _, timer := range diagram.Timers {
+		timer.ActorName = actorName(timer.ActorName)
+		timer.Name = r.redact("t", timer.Name)
+		timer.Label = r.redact("timer", timer.Label)
+	}
+	for _, constraint := range diagram.Constraints {
+		constraint.Label = r.redact("constraint", constraint.Label)
+	}
+	for _, state := range diagram.States {
+		state.ActorName = actorName(state.ActorName)
+		state.Label = r.redact("state", state.Label)
+	}
+	for _, coregion := range diagram.Coregions {
+		coregion.ActorName = actorName(coregion.ActorName)
+	}
+	for _, entry := range diagram.Legend {
+		entry.ActorName = actorName(entry.ActorName)
+		entry.Description = r.redact("text", entry.Description)
+	}
+	for _, annotation := range diagram.Annotations {
+		annotation.Text = r.redact("text", annotation.Text)
+	}
+	for _, gap := range diagram.Gaps {
+		gap.Label = r.redact("text", gap.Label)
+	}
+	for _, divider := range diagram.Dividers {
+		divider.Label = r.redact("text", divider.Label)
+	}
+}
@@ -0,0 +1,149 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// redactTestInput contains every kind of element that carries a name or
+// text. All of them start with "zq", so that leftovers are easy to find.
+const redactTestInput = `legend
+zqalice the zqdescription
+end
+participant zqalice zqlabel
+stereotype zqalice zqstereotype
+start zqalice zqactivity
+start zqbob
+timer set zqalice zqtimername zqtimerlabel
+call zqalice zqmsg zqhello
+receive zqbob zqmsg
+constraint zqmsg.send zqmsg.receive zqconstraint
+state zqbob zqstate
+annotate zqannotation
+delay 2 zqdelay
+divider zqdivider
+return zqbob zqmsg zqreply
+receive zqalice zqmsg
+send [ zqgatemsg zqgatelabel
+receive zqalice zqgatemsg
+before zqmsg.receive zqgatemsg.receive
+timer expire zqalice zqtimername
+skip zqskip
+stop zqalice
+stop zqbob
+newpage zqtitle
+start zqcarol
+stop zqcarol
+`
+
+var redactTestToken = regexp.MustCompile(`zq[a-z]+`)
+
+// renderRedactTestInput renders redactTestInput in every output format, and
+// returns the tokens from the input that occur in the output.
+func renderRedactTestInput(t *testing.T, opts *Options) map[string][]string {
+	t.Helper()
+	var formats []string
+	for format := range OutputExtensions {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	result := make(map[string][]string)
+	for _, format := range formats {
+		opts.Format = format
+		var buf bytes.Buffer
+		msg := catchFailure(func() {
+			diagrams := parsePages(strings.NewReader(redactTestInput))
+			for _, diagram := range transform(diagrams, opts) {
+				for _, page := range renderPages(diagram, opts) {
+					page(&buf)
+				}
+			}
+		})
+		if msg != "" {
+			t.Fatalf("cannot render as %s: %s", format, msg)
+		}
+		for _, token := range redactTestToken.FindAllString(buf.String(), -1) {
+			result[token] = append(result[token], format)
+		}
+	}
+	return result
+}
+
+func TestRedactCoversAllElements(t *testing.T) {
+	//without -redact, every token shall appear in some output format, otherwise
+	//the check below proves nothing
+	found := renderRedactTestInput(t, DefaultOptions())
+	for _, token := range redactTestToken.FindAllString(redactTestInput, -1) {
+		if len(found[token]) == 0 {
+			t.Errorf("%s does not appear in any output format even without -redact", token)
+		}
+	}
+
+	for _, style := range []string{"hash", "pseudonym"} {
+		opts := DefaultOptions()
+		opts.Redact = true
+		opts.RedactStyle = style
+		for token, formats := range renderRedactTestInput(t, opts) {
+			t.Errorf("-redact-style=%s: %s survives in %s", style, token, strings.Join(formats, ", "))
+		}
+	}
+}
+
+func TestRedactHashKey(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	key1 := write("key1", "first secret\n")
+	key2 := write("key2", "second secret\n")
+
+	redactedTitle := func(keyFile string) string {
+		opts := DefaultOptions()
+		opts.Redact = true
+		opts.RedactKeyFile = keyFile
+		diagrams := []*Diagram{{Title: "zqtitle"}}
+		redactDiagrams(diagrams, opts)
+		return diagrams[0].Title
+	}
+
+	if redactedTitle(key1) != redactedTitle(key1) {
+		t.Error("hashes with the same key differ")
+	}
+	if redactedTitle(key1) == redactedTitle(key2) {
+		t.Error("hashes with different keys are equal")
+	}
+	if redactedTitle("") != redactedTitle("") {
+		t.Error("hashes with the random key differ within the same run")
+	}
+	if msg := catchFailure(func() { redactedTitle(write("empty", "\n")) }); msg == "" {
+		t.Error("empty key file was accepted")
+	}
+}
@@ -0,0 +1,2796 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+// Package sequence parses, transforms and renders sequence diagrams. It is the
+// library behind the sequence-diagram program, which only adds the
+// command-line interface and file handling on top:
+//
+//	diagrams, err := sequence.Parse(input)
+//	...
+//	pages, err := sequence.Render(diagrams, sequence.DefaultOptions())
+//	...
+//	err = sequence.WritePages(output, pages)
+//
+// Errors are returned instead of terminating the program; errors in the
+// diagram source or the options are of type *InputError.
+package sequence
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Actor struct {
+	Name          string
+	Label         string
+	DisplayOrder  uint
+	Activities    []*Activity
+	BlockedByCall *Message //during parsing, contains not-yet-answered synchronous message
+	ActivityCount uint     //during parsing, counts number of running activities
+	Lifeline      LifelineStyle
+	Declared      bool   //if true, the actor was declared with the "participant" command
+	HeadShape     string //"rectangle" (default), "rounded", "ellipse" or "hexagon" (see "style" command)
+	Stereotype    string //without guillemets, e.g. "service" (see "stereotype" command)
+	Multiplicity  string //for actors that stand for a pool of instances, e.g. "*" (see "style" command)
+}
+
+// LifelineStyle describes how the lifeline of an actor is drawn. It is set by
+// the "style" command; the zero value is a thin black dashed line.
+type LifelineStyle struct {
+	Pattern string //"dashed" (default), "dotted" or "solid"
+	Color   string
+	Width   uint
+}
+
+type Activity struct {
+	StartTime uint
+	StopTime  uint
+	Label     string //optional, e.g. "processing order"
+	//layout parameters
+	Layer uint
+}
+
+// Gap is a span of time that was inserted with the "delay" or "skip" command,
+// or that was compressed by the layout.
+type Gap struct {
+	StartTime uint
+	StopTime  uint
+	Label     string
+	Torn      bool //if true, time was omitted and the gap is rendered as a torn band across all lifelines
+}
+
+// Annotation is a text that is rendered in the left margin at a certain
+// point in time, regardless of actors.
+type Annotation struct {
+	Time uint
+	Text string
+}
+
+// Divider is a horizontal line across all lifelines that separates two phases
+// of the interaction (see "divider" command and -split-phases).
+type Divider struct {
+	Time  uint
+	Label string
+}
+
+// LegendEntry is a description of an actor, as declared in a "legend" block.
+type LegendEntry struct {
+	ActorName   string
+	Description string
+}
+
+// Timer is a timer on an actor's lifeline that was started by "timer set" and
+// (optionally) ended by "timer cancel" or "timer expire".
+type Timer struct {
+	ActorName string
+	Name      string
+	Label     string
+	SetTime   uint
+	StopTime  uint //0 while the timer is running
+	Expired   bool
+}
+
+// StateInvariant is a state that an actor is in at a point in time, drawn as
+// a rounded box on its lifeline (see "state" command).
+type StateInvariant struct {
+	ActorName string
+	Time      uint
+	Label     string
+}
+
+// Coregion is a span on an actor's lifeline within which the order of events
+// is not significant (see "coregion" command).
+type Coregion struct {
+	ActorName string
+	StartTime uint
+	StopTime  uint //0 until the "coregion end" command
+}
+
+// Constraint is a duration constraint between two events, e.g. "< 5ms".
+type Constraint struct {
+	From  EventRef
+	To    EventRef
+	Label string
+}
+
+// EventRef refers to the sending or receiving of a message, e.g. "m1.send" or
+// "m1.receive".
+type EventRef struct {
+	Message *Message
+	Receive bool
+}
+
+func (ref EventRef) Time() uint {
+	if ref.Receive {
+		return ref.Message.ReceiverTime
+	}
+	return ref.Message.SenderTime
+}
+
+func (ref EventRef) ActorName() string {
+	if ref.Receive {
+		return ref.Message.ReceiverName
+	}
+	return ref.Message.SenderName
+}
+
+func (ref EventRef) Layer() uint {
+	if ref.Receive {
+		return ref.Message.ReceiverLayer
+	}
+	return ref.Message.SenderLayer
+}
+
+func (ref EventRef) String() string {
+	if ref.Receive {
+		return ref.Message.Name + ".receive"
+	}
+	return ref.Message.Name + ".send"
+}
+
+// hasHappened returns false for the receiving of a message that has not been
+// received yet.
+func (ref EventRef) hasHappened() bool {
+	return !ref.Receive || ref.Message.ReceiverName != ""
+}
+
+// GeneralOrdering states that one event happens before another, without
+// implying anything about the time in between (see "before" command).
+type GeneralOrdering struct {
+	Before EventRef
+	After  EventRef
+}
+
+// Diagram is the model of one parsed diagram.
+type Diagram struct {
+	Title       string //from the "newpage" command that started this diagram
+	Actors      map[string]*Actor
+	Messages    map[string]*Message
+	Gaps        []*Gap
+	Constraints []*Constraint
+	Orderings   []*GeneralOrdering
+	Timers      []*Timer
+	States      []*StateInvariant
+	Coregions   []*Coregion
+	Annotations []*Annotation
+	Dividers    []*Divider
+	Legend      []*LegendEntry
+	Timestamps  map[uint]time.Duration //wall-clock time of events, by logical time (only for events that have one)
+	Spacings    map[uint]uint          //changes of vertical distance per unit of time (in px), by logical time
+}
+
+type Message struct {
+	Kind         string //command name that generated the message (one of "send", "call", "return")
+	Name         string //as given in the input (may be reused by later messages, see parseSend)
+	Label        string
+	SenderName   string
+	ReceiverName string
+	SenderTime   uint
+	ReceiverTime uint
+	Number       uint           //only set with -autonumber
+	TimedOut     bool           //if true, the message was not received; ReceiverName and ReceiverTime describe the "timeout" command
+	Color        string         //if set, the arrow and label are drawn in this color instead of black (used by the "diff" subcommand)
+	HideReturn   bool           //for calls: if true, the response to this call is not drawn (see "hide-return" command)
+	Hidden       bool           //if true, no arrow is drawn for this message (it still affects activities)
+	Placement    LabelPlacement //overrides -label-placement for this message (see "placement" command)
+	//layout parameters
+	SenderLayer   uint
+	ReceiverLayer uint
+}
+
+const (
+	HeaderHeight          = 50
+	SwimlaneStep          = 25  //per unit of time
+	SwimlaneWidth         = 200 //per actor
+	LabelWidth            = SwimlaneWidth / 2
+	LabelHeight           = 20
+	ActivityWidth         = 20
+	ActivityOffset        = ActivityWidth / 2
+	ArrowTipSize          = 10
+	MessageFontSize       = 12
+	MessageBaselineOffset = 3
+	MessageLineHeight     = MessageFontSize + 2 //for labels with multiple lines
+	RulerWidth            = 80                  //left margin for the time ruler
+	RulerFontSize         = 10
+	AnnotationWidth       = 150 //left margin for annotations
+	AnnotationFontSize    = 10
+	ActivityFontSize      = 10 //for labels inside activity boxes
+	LegendLineHeight      = 20
+	LegendKeyWidth        = 150 //width of the column with actor labels in the legend
+	TitleHeight           = 30
+	TitleFontSize         = 16
+	SkipSteps             = 2    //units of time occupied by a "skip" command
+	MaxDelaySteps         = 1000 //of a "delay" command (every unit of time is a tick that takes time to lay out)
+	TornGapHeight         = 16
+	TimerSymbolSize       = 10
+	StateHeight           = 16 //of state invariant boxes
+	StateFontSize         = 10
+	StereotypeHeight      = 12 //added to the head box of actors with a stereotype
+	StereotypeFontSize    = 10
+	MultiInstanceOffset   = 4  //offset of the stacked head box behind multi-instance actors
+	FrameHeaderHeight     = 24 //with -frame: top margin for the frame's name tag
+	FrameFontSize         = 12
+	SelfLoopWidth         = 40 //with -curved-arrows: horizontal extent of messages from an actor to itself
+	ArcFlatness           = 8  //with -curved-arrows: ratio of horizontal extent to height of arcs
+)
+
+// PageDelimiter separates the diagrams on stdout when the input contains
+// multiple pages.
+const PageDelimiter = "<!-- newpage -->"
+
+// OutputExtensions contains the file extension for each output format.
+var OutputExtensions = map[string]string{
+	"svg":           ".svg",
+	"gantt":         ".mmd",
+	"communication": ".svg",
+	"dot":           ".dot",
+	"csv":           ".csv",
+	"events":        ".tsv",
+	"html":          ".html",
+}
+
+// Parse splits the input into pages at each "newpage" command, and parses
+// each page into a separate diagram. It returns the first error in the input.
+func Parse(r io.Reader) (diagrams []*Diagram, err error) {
+	defer catchError(&err)
+	return parsePages(r), nil
+}
+
+// ParseLimited is like Parse, but also fails as soon as the input exceeds the
+// given limits.
+func ParseLimited(r io.Reader, limits *Limits) (diagrams []*Diagram, err error) {
+	defer catchError(&err)
+	return parsePagesLimited(r, limits), nil
+}
+
+// ParseRecovering is like ParseLimited, but keeps going after errors in the
+// input, and returns all of them in the order of the input. The diagrams can
+// always be rendered (see parseRecovering). The returned error is only set if
+// the input could not be read, exceeds the limits or is empty.
+func ParseRecovering(r io.Reader, limits *Limits) (diagrams []*Diagram, errs []ParseError, err error) {
+	defer catchError(&err)
+	diagrams, errs = parsePagesRecovering(r, limits)
+	return diagrams, errs, nil
+}
+
+// Transform applies the actor options (Merge, Only, Hide, Focus etc.) and
+// Redact to the diagrams. The diagrams are modified in place.
+func Transform(diagrams []*Diagram, opts *Options) (result []*Diagram, err error) {
+	defer catchError(&err)
+	return transform(diagrams, opts), nil
+}
+
+// RenderPages renders a diagram (that has already been transformed) in the
+// output format. Depending on the options, the diagram may be split into
+// multiple pages.
+func RenderPages(diagram *Diagram, opts *Options) (pages []Page, err error) {
+	defer catchError(&err)
+	return exportPages(renderPages(diagram, opts)), nil
+}
+
+// Render transforms the diagrams and renders all of them.
+func Render(diagrams []*Diagram, opts *Options) (pages []Page, err error) {
+	defer catchError(&err)
+	diagrams = transform(diagrams, opts)
+	opts.Timings.count(diagrams)
+
+	defer opts.Timings.Measure("render")()
+	var result []pageWriter
+	for _, diagram := range diagrams {
+		result = append(result, renderPages(diagram, opts)...)
+	}
+	return exportPages(result), nil
+}
+
+// RenderSource renders a diagram source into a string, with multiple pages
+// separated by PageDelimiter. This is used by the bindings for other
+// languages (see wasm.go and capi.go).
+func RenderSource(source string, opts *Options) (output string, err error) {
+	defer catchError(&err)
+	var pages []pageWriter
+	for _, diagram := range transform(parsePages(strings.NewReader(source)), opts) {
+		pages = append(pages, renderPages(diagram, opts)...)
+	}
+	var buf bytes.Buffer
+	writePages(&buf, pages)
+	return buf.String(), nil
+}
+
+// transform applies the actor options and -redact to the diagrams.
+func transform(diagrams []*Diagram, opts *Options) []*Diagram {
+	endTransform := opts.Timings.Measure("transform")
+	mergeActorGroups(diagrams, opts.Merge)
+	filterDiagrams(diagrams, opts)
+	diagrams = focusDiagrams(diagrams, opts.Focus)
+	redactDiagrams(diagrams, opts)
+	if opts.SplitPhases {
+		diagrams = splitPhases(diagrams)
+	}
+	endTransform()
+
+	/* enable this for debugging * /
+	for _, diagram := range diagrams {
+		for name, actor := range diagram.Actors {
+			fmt.Fprintf(os.Stderr, "actor %s = %#v\n", name, actor)
+			for idx, activity := range actor.Activities {
+				fmt.Fprintf(os.Stderr, "activity %d = %#v\n", idx, activity)
+			}
+		}
+		for name, message := range diagram.Messages {
+			fmt.Fprintf(os.Stderr, "message %s = %#v\n", name, message)
+		}
+	}
+	/* */
+
+	return diagrams
+}
+
+// Page renders one output document.
+type Page func(w io.Writer) error
+
+// pageWriter is the internal form of Page, which reports errors through
+// fail() and failIfErr().
+type pageWriter func(w io.Writer)
+
+// exportPages converts pages for the exported functions.
+func exportPages(pages []pageWriter) []Page {
+	result := make([]Page, len(pages))
+	for idx, page := range pages {
+		result[idx] = func(w io.Writer) (err error) {
+			defer catchError(&err)
+			page(w)
+			return nil
+		}
+	}
+	return result
+}
+
+func renderPages(diagram *Diagram, opts *Options) []pageWriter {
+	switch opts.Format {
+	case "svg":
+		return scalePages(svgPages(diagram, opts), opts.Scale)
+	case "gantt":
+		return []pageWriter{func(w io.Writer) { renderGantt(w, diagram) }}
+	case "communication":
+		return scalePages([]pageWriter{func(w io.Writer) { renderCommunication(w, diagram, opts) }}, opts.Scale)
+	case "dot":
+		return []pageWriter{func(w io.Writer) { renderDOT(w, diagram) }}
+	case "csv":
+		return []pageWriter{func(w io.Writer) { renderCSV(w, diagram) }}
+	case "events":
+		return []pageWriter{func(w io.Writer) { renderEvents(w, diagram) }}
+	case "html":
+		return []pageWriter{func(w io.Writer) { renderHTML(w, diagram, opts) }}
+	default:
+		fail("unknown output format: %s", opts.Format)
+		return nil
+	}
+}
+
+// writePages writes the pages into one stream, separated by PageDelimiter.
+func writePages(w io.Writer, pages []pageWriter) {
+	for idx, page := range pages {
+		if idx > 0 {
+			fmt.Fprintln(w, PageDelimiter)
+		}
+		page(w)
+	}
+}
+
+// WritePages writes the pages into one stream, separated by PageDelimiter.
+func WritePages(w io.Writer, pages []Page) error {
+	for idx, page := range pages {
+		if idx > 0 {
+			if _, err := fmt.Fprintln(w, PageDelimiter); err != nil {
+				return err
+			}
+		}
+		if err := page(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// svgBody is the content of an SVG document (everything except for the
+// <svg> element and the <defs>), along with the information required to
+// split it into multiple pages.
+type svgBody struct {
+	Content    []byte
+	Width      uint
+	Height     uint
+	LeftMargin uint
+	TopMargin  uint
+	Layout     *Layout
+	Breaks     []uint //vertical positions where a page break may be inserted, in ascending order
+}
+
+// svgPages renders the diagram into one SVG document, or into multiple SVG
+// documents if it is higher than allowed by -max-height.
+func svgPages(diagram *Diagram, opts *Options) []pageWriter {
+	body := renderSVGBody(diagram, opts)
+	if opts.WarnOverlaps {
+		warnOverlaps(diagram, body.Layout)
+	}
+	if opts.PageSize.Name != "" {
+		if opts.MaxWidth > 0 || opts.MaxHeight > 0 {
+			fail("-page-size cannot be combined with -max-width or -max-height")
+		}
+		if opts.Scale != 1 {
+			fail("-page-size cannot be combined with -scale or -dpi (the page size determines the size of the image)")
+		}
+		return body.printPages(diagram, opts.PageSize, opts.PageMargin)
+	}
+	if opts.MaxWidth > 0 && body.Width > opts.MaxWidth {
+		if opts.MaxHeight > 0 {
+			fail("-max-width and -max-height cannot be combined")
+		}
+		return body.columnPages(diagram, opts.MaxWidth)
+	}
+	if opts.MaxHeight > 0 && opts.MaxHeight <= 2*HeaderHeight {
+		fail("-max-height must be larger than %d", 2*HeaderHeight)
+	}
+	return body.rowPages(diagram, opts.MaxHeight)
+}
+
+// rowPages splits the body into pages that are at most maxHeight high (or
+// not at all if maxHeight is 0). Page breaks are only inserted between two
+// points in time, and each page except for the first one repeats the actor
+// headers.
+func (body svgBody) rowPages(diagram *Diagram, maxHeight uint) []pageWriter {
+	if maxHeight == 0 || body.Height <= maxHeight {
+		return []pageWriter{func(w io.Writer) {
+			writeSVGHeader(w, body.Width, body.Height)
+			w.Write(body.Content)
+			fmt.Fprintln(w, `</svg>`)
+		}}
+	}
+
+	var pages []pageWriter
+	var startY uint
+	for startY < body.Height {
+		//all pages except for the first one start with a repetition of the actor headers
+		var top uint
+		if startY > 0 {
+			top = HeaderHeight
+		}
+		endY := body.Height
+		if startY+maxHeight-top < body.Height {
+			endY = startY + maxHeight - top
+			for idx := len(body.Breaks) - 1; idx >= 0; idx-- {
+				if body.Breaks[idx] > startY && body.Breaks[idx] <= endY {
+					endY = body.Breaks[idx]
+					break
+				}
+			}
+		}
+		pages = append(pages, body.page(diagram, startY, endY, top))
+		startY = endY
+	}
+	return pages
+}
+
+// page returns a Page showing the vertical range [startY, endY) of the body,
+// shifted down by `top` to make room for the repeated actor headers.
+func (body svgBody) page(diagram *Diagram, startY, endY, top uint) pageWriter {
+	return func(w io.Writer) {
+		writeSVGHeader(w, body.Width, top+endY-startY)
+		fmt.Fprintf(w, `<clipPath id="page"><rect x="0" y="%d" width="%d" height="%d" /></clipPath>`,
+			top, body.Width, endY-startY,
+		)
+		fmt.Fprintf(w, `<g clip-path="url(#page)"><g transform="translate(0,%d)">`, int(top)-int(startY))
+		w.Write(body.Content)
+		fmt.Fprint(w, `</g></g>`)
+		if top > 0 {
+			fmt.Fprintf(w, `<g transform="translate(%d,0)">`, body.LeftMargin)
+			for _, actor := range sortedActors(diagram.Actors) {
+				actor.drawHead(w, HeaderHeight-actor.headHeight(), body.Layout.Options)
+			}
+			fmt.Fprint(w, `</g>`)
+		}
+		fmt.Fprintln(w, `</svg>`)
+	}
+}
+
+// columnPages splits the body into pages showing groups of adjacent actors
+// that fit into -max-width. Each page repeats the left margin (time ruler and
+// annotations), and messages to or from actors on other pages are marked with
+// a reference at the page border.
+func (body svgBody) columnPages(diagram *Diagram, maxWidth uint) []pageWriter {
+	if maxWidth < body.LeftMargin+SwimlaneWidth {
+		fail("-max-width must be at least %d", body.LeftMargin+SwimlaneWidth)
+	}
+	perPage := (maxWidth - body.LeftMargin) / SwimlaneWidth
+	count := uint(len(diagram.Actors))
+
+	var pages []pageWriter
+	for first := uint(0); first < count; first += perPage {
+		last := first + perPage
+		if last > count {
+			last = count
+		}
+		pages = append(pages, body.columnPage(diagram, first, last, perPage))
+	}
+	return pages
+}
+
+// columnPage returns a Page showing the actors with DisplayOrder in [first, last).
+func (body svgBody) columnPage(diagram *Diagram, first, last, perPage uint) pageWriter {
+	return func(w io.Writer) {
+		lanesWidth := (last - first) * SwimlaneWidth
+		width := body.LeftMargin + lanesWidth
+		writeSVGHeader(w, width, body.Height)
+		if body.LeftMargin > 0 {
+			fmt.Fprintf(w, `<clipPath id="margin"><rect x="0" y="0" width="%d" height="%d" /></clipPath>`,
+				body.LeftMargin, body.Height,
+			)
+			fmt.Fprint(w, `<g clip-path="url(#margin)">`)
+			w.Write(body.Content)
+			fmt.Fprint(w, `</g>`)
+		}
+		fmt.Fprintf(w, `<clipPath id="lanes"><rect x="%d" y="0" width="%d" height="%d" /></clipPath>`,
+			body.LeftMargin, lanesWidth, body.Height,
+		)
+		fmt.Fprintf(w, `<g clip-path="url(#lanes)"><g transform="translate(%d,0)">`, -int(first*SwimlaneWidth))
+		w.Write(body.Content)
+		fmt.Fprint(w, `</g></g>`)
+
+		isOnPage := func(actor *Actor) bool {
+			return actor.DisplayOrder >= first && actor.DisplayOrder < last
+		}
+		for _, msg := range sortedMessages(diagram.Messages) {
+			sender, receiver := diagram.Actors[msg.SenderName], diagram.Actors[msg.ReceiverName]
+			switch {
+			case sender == nil || receiver == nil || !msg.isDrawn(body.Layout.Options):
+				//messages to the diagram border do not cross into other pages
+			case isOnPage(sender) && !isOnPage(receiver):
+				body.drawReference(w, width, "to", receiver, msg.SenderTime, receiver.DisplayOrder > sender.DisplayOrder, perPage)
+			case !isOnPage(sender) && isOnPage(receiver):
+				body.drawReference(w, width, "from", sender, msg.ReceiverTime, sender.DisplayOrder > receiver.DisplayOrder, perPage)
+			}
+		}
+		fmt.Fprintln(w, `</svg>`)
+	}
+}
+
+// drawReference renders a note like "to database (page 2)" at the left or
+// right border of a column page, below the message arrow that crosses it.
+func (body svgBody) drawReference(w io.Writer, width uint, direction string, other *Actor, t uint, rightBorder bool, perPage uint) {
+	x, anchor := body.LeftMargin+MessageBaselineOffset, "start"
+	if rightBorder {
+		x, anchor = width-MessageBaselineOffset, "end"
+	}
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d" font-style="italic" text-anchor="%s" fill="dimgray">%s %s (page %d)</text>`,
+		x, body.TopMargin+body.Layout.Y(t)+RulerFontSize+MessageBaselineOffset, RulerFontSize, anchor,
+		direction, escapeText(other.Label, body.Layout.Options), other.DisplayOrder/perPage+1,
+	)
+}
+
+func writeSVGHeader(w io.Writer, width, height uint) {
+	fmt.Fprintf(w, `<svg version="1.1" baseProfile="full" xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`,
+		width, height)
+
+	fmt.Fprintf(w, `
+		<defs>
+			<marker id="normal" viewBox="0 0 10 10" refX="1" refY="5" markerWidth="%d" markerHeight="%d" orient="auto">
+				<path d="M 0 0 L 10 5 L 0 5 L 10 5 L 0 10" fill="none" stroke="black" />
+			</marker>
+			<marker id="filled" viewBox="0 0 10 10" refX="1" refY="5" markerWidth="%d" markerHeight="%d" orient="auto">
+				<path d="M 0 0 L 10 5 L 0 10 z" fill="black" />
+			</marker>
+			<marker id="half" viewBox="0 0 10 10" refX="1" refY="5" markerWidth="%d" markerHeight="%d" orient="auto">
+				<path d="M 0 0 L 10 5 L 0 5" fill="none" stroke="black" />
+			</marker>
+		</defs>
+	`, ArrowTipSize, ArrowTipSize, ArrowTipSize, ArrowTipSize, ArrowTipSize, ArrowTipSize)
+}
+
+func renderSVGBody(diagram *Diagram, opts *Options) svgBody {
+	actors, messages := diagram.Actors, diagram.Messages
+	maxTime := getMaxTime(diagram)
+	layout := computeLayout(diagram, maxTime, opts)
+	width := len(actors) * SwimlaneWidth
+	height := layout.bottom(maxTime)
+	leftMargin := 0
+	if opts.Ruler {
+		leftMargin += RulerWidth
+	}
+	if len(diagram.Annotations) > 0 {
+		leftMargin += AnnotationWidth
+	}
+	svgWidth := width + leftMargin
+	var legend, index [][2]string
+	for _, entry := range diagram.Legend {
+		legend = append(legend, [2]string{escapeText(actors[entry.ActorName].Label, opts), escapeText(entry.Description, opts)})
+	}
+	if opts.Autonumber {
+		var number uint
+		for _, message := range sortedMessages(messages) {
+			if !message.isDrawn(opts) {
+				continue
+			}
+			number++
+			message.Number = number
+			if opts.MessageIndex {
+				index = append(index, [2]string{strconv.Itoa(int(number)), escapeText(strings.Join(labelLines(message.Label), " "), opts)})
+			}
+		}
+	}
+	legendY := height
+	height += tableHeight(legend)
+	indexY := height
+	height += tableHeight(index)
+	var topMargin uint
+	switch {
+	case opts.Frame:
+		topMargin = FrameHeaderHeight
+	case diagram.Title != "":
+		topMargin = TitleHeight
+	}
+
+	//page breaks are allowed between two points in time, and before the tables
+	var breaks []uint
+	for t := uint(1); t <= maxTime+1; t++ {
+		breaks = append(breaks, topMargin+(layout.Y(t-1)+layout.Y(t))/2)
+	}
+	breaks = append(breaks, topMargin+legendY, topMargin+indexY)
+
+	var buf bytes.Buffer
+	w := &buf
+	writeThemeStyle(w, opts.Theme)
+	switch {
+	case opts.Frame:
+		//the frame encloses the lifelines, but not the legend and index
+		drawFrame(w, diagram.Title, leftMargin, width, topMargin+legendY, opts)
+	case diagram.Title != "":
+		fmt.Fprintf(w, `<text x="%d" y="%g" font-size="%d" font-weight="bold" text-anchor="middle">%s</text>`,
+			svgWidth/2, 0.7*TitleHeight, TitleFontSize, escapeText(diagram.Title, opts),
+		)
+	}
+	if topMargin > 0 {
+		fmt.Fprintf(w, `<g transform="translate(0,%d)">`, topMargin)
+	}
+	if opts.Ruler {
+		diagram.drawRuler(w, maxTime, layout)
+	}
+	for _, annotation := range diagram.Annotations {
+		annotation.draw(w, leftMargin, layout)
+	}
+	if leftMargin > 0 {
+		fmt.Fprintf(w, `<g transform="translate(%d,0)">`, leftMargin)
+	}
+
+	if opts.Gridlines {
+		drawGridlines(w, maxTime, width, layout)
+	}
+	for _, actor := range sortedActors(actors) {
+		actor.drawSwimLane(w, maxTime, layout)
+		if !opts.NoActivations {
+			for _, activity := range actor.Activities {
+				activity.drawBox(w, actor.DisplayOrder, layout)
+			}
+		}
+	}
+	for _, name := range sortedMessageNames(messages) {
+		message := messages[name]
+		if !message.isDrawn(opts) {
+			continue
+		}
+		if opts.MessageAnchors {
+			fmt.Fprintf(w, `<g id="%s" data-from="%s" data-to="%s">`,
+				html.EscapeString(messageAnchor(name)), html.EscapeString(message.SenderName), html.EscapeString(message.ReceiverName),
+			)
+		}
+		message.drawArrow(w, actors[message.SenderName], actors[message.ReceiverName], layout)
+		if opts.MessageAnchors {
+			fmt.Fprint(w, `</g>`)
+		}
+	}
+	for _, gap := range diagram.Gaps {
+		gap.draw(w, width, layout)
+	}
+	for _, gap := range layout.CompressedGaps {
+		gap.draw(w, width, layout)
+	}
+	for _, divider := range diagram.Dividers {
+		divider.draw(w, width, layout)
+	}
+	for _, timer := range diagram.Timers {
+		timer.draw(w, actors[timer.ActorName], layout)
+	}
+	for _, state := range diagram.States {
+		state.draw(w, actors[state.ActorName], layout)
+	}
+	for _, coregion := range diagram.Coregions {
+		coregion.draw(w, actors[coregion.ActorName], layout)
+	}
+	for _, constraint := range diagram.Constraints {
+		constraint.drawMeasure(w, actors[constraint.From.ActorName()], layout)
+	}
+	for _, ordering := range diagram.Orderings {
+		ordering.draw(w, actors, layout)
+	}
+	drawTable(w, legend, legendY, width)
+	drawTable(w, index, indexY, width)
+	if opts.DebugOverlay {
+		drawDebugOverlay(w, diagram, maxTime, width, layout)
+	}
+
+	if leftMargin > 0 {
+		fmt.Fprint(w, `</g>`)
+	}
+	if topMargin > 0 {
+		fmt.Fprint(w, `</g>`)
+	}
+
+	return svgBody{
+		Content:    buf.Bytes(),
+		Width:      uint(svgWidth),
+		Height:     topMargin + height,
+		LeftMargin: uint(leftMargin),
+		TopMargin:  topMargin,
+		Layout:     layout,
+		Breaks:     breaks,
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// parsing
+
+// parsePages splits the input into pages at each "newpage" command, and
+// parses each page into a separate diagram. It fails on the first error in
+// the input.
+func parsePages(r io.Reader) []*Diagram {
+	return parsePagesLimited(r, nil)
+}
+
+// parsePagesLimited is like parsePages, but also fails as soon as the input
+// exceeds the given limits.
+func parsePagesLimited(r io.Reader, limits *Limits) []*Diagram {
+	diagrams, errs := parsePagesRecovering(r, limits)
+	if len(errs) > 0 {
+		fail(errs[0].String())
+	}
+	return diagrams
+}
+
+// ParseError is an error in the input, with the number of the line where it
+// was found (counted from the start of the input, not of the page).
+type ParseError struct {
+	Line    int
+	Message string
+}
+
+func (e ParseError) String() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// parsePagesRecovering is like parsePagesLimited, but keeps going after
+// errors (see parseRecovering), and returns all errors in the order of the
+// input.
+func parsePagesRecovering(r io.Reader, limits *Limits) ([]*Diagram, []ParseError) {
+	input, err := io.ReadAll(DecodeInput(r))
+	failIfErr(err)
+
+	var diagrams []*Diagram
+	var errs []ParseError
+	var page strings.Builder
+	title, firstLine := "", 1
+	flush := func(nextLine int) {
+		if strings.TrimSpace(page.String()) != "" {
+			diagram, pageErrs := parseRecovering(strings.NewReader(page.String()), firstLine, limits)
+			diagram.Title = title
+			diagrams = append(diagrams, diagram)
+			errs = append(errs, pageErrs...)
+		}
+		page.Reset()
+		firstLine = nextLine
+	}
+
+	for idx, line := range strings.SplitAfter(string(input), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "newpage" {
+			flush(idx + 2)
+			title = ParseText(fields[1:])
+			continue
+		}
+		page.WriteString(line)
+	}
+	flush(0)
+
+	if len(diagrams) == 0 {
+		fail("input does not contain any commands")
+	}
+	return diagrams, errs
+}
+
+// parseRecovering parses one page of input like parseWith, but when a line
+// fails to parse, it records the error and continues with the next line.
+// Unfinished activities and unreceived messages at the end of the input are
+// reported as one error, and are then handled as in Parser.Snapshot(), so the
+// resulting diagram can always be rendered. The first line of the input has
+// the given number. If the input exceeds the limits, parseRecovering fails
+// immediately.
+func parseRecovering(input io.Reader, firstLine int, limits *Limits) (*Diagram, []ParseError) {
+	p := NewParser()
+	p.Limits = limits
+	r := bufio.NewReader(input)
+	var errs []ParseError
+	lineNo, lastLine := firstLine-1, firstLine
+	loop := true
+	for loop {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			loop = false //break after this iteration
+		} else {
+			failIfErr(err)
+		}
+		lineNo++
+		if strings.TrimSpace(line) != "" {
+			lastLine = lineNo
+		}
+		if msg := catchFailure(func() { p.parseLine(line) }); msg != "" {
+			if p.Aborted {
+				fail(ParseError{Line: lineNo, Message: msg}.String())
+			}
+			errs = append(errs, ParseError{Line: lineNo, Message: msg})
+		}
+	}
+
+	//errors at the end of the input are reported on the last non-empty line
+	if msg := catchFailure(p.finish); msg != "" {
+		errs = append(errs, ParseError{Line: lastLine, Message: msg})
+		return p.Snapshot(), errs
+	}
+	return p.Diagram, errs
+}
+
+// parseWith is like parse, but calls flush (if not nil) after each line of
+// input and at the end of the input, with the current point in time. This is
+// used by the streaming renderer to remove finished elements from the diagram.
+func parseWith(input io.Reader, flush func(diagram *Diagram, time uint)) *Diagram {
+	p := NewParser()
+	r := bufio.NewReader(input)
+	loop := true
+	for loop {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			loop = false //break after this iteration
+		} else {
+			failIfErr(err)
+		}
+		p.parseLine(line)
+		if flush != nil {
+			flush(p.Diagram, p.Time)
+		}
+	}
+
+	p.finish()
+	if flush != nil {
+		flush(p.Diagram, p.Time)
+	}
+	return p.Diagram
+}
+
+// Parser contains the state of parsing between lines of input (see parseWith).
+type Parser struct {
+	Diagram       *Diagram
+	RunningTimers map[string]*Timer //key = actor name + " " + timer name
+	Time          uint
+	Pending       []autoReceive
+	//in auto-tick mode, every event command advances time by one step, except
+	//for commands within a "together" block
+	AutoTick   bool
+	InTogether bool
+	InLegend   bool
+	Limits     *Limits //nil = unlimited
+	Aborted    bool    //set when a limit was exceeded
+}
+
+// NewParser returns a parser for a single page of input, which is fed to it
+// line by line (see ParseLine and Finish).
+func NewParser() *Parser {
+	return &Parser{
+		Diagram: &Diagram{
+			Actors:     make(map[string]*Actor),
+			Messages:   make(map[string]*Message),
+			Timestamps: make(map[uint]time.Duration),
+			Spacings:   make(map[uint]uint),
+		},
+		RunningTimers: make(map[string]*Timer),
+		Time:          1,
+	}
+}
+
+func (p *Parser) advanceTime() {
+	p.Time++
+	p.checkTimeLimits()
+	p.Pending = receivePending(p.Pending, p.Time, p.Diagram.Actors, p.Diagram.Messages)
+}
+
+// ParseLine parses one line of input. After an error, the parser state may
+// have been changed partially, so callers that want to keep going should
+// continue with a copy of the last good state (see Clone).
+func (p *Parser) ParseLine(line string) (err error) {
+	defer catchError(&err)
+	p.parseLine(line)
+	return nil
+}
+
+func (p *Parser) parseLine(line string) {
+	diagram, actors, messages := p.Diagram, p.Diagram.Actors, p.Diagram.Messages
+	checkEncoding(line)
+	p.checkLineLimits(line)
+
+	//lines starting with "#" are comments (and do not advance time)
+	if IsComment(line) {
+		return
+	}
+
+	//lines within a "legend" block are not commands, but legend entries
+	if p.InLegend {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			//ignore
+		case len(fields) == 1 && fields[0] == "end":
+			p.InLegend = false
+		default:
+			diagram.Legend = append(diagram.Legend, &LegendEntry{
+				ActorName:   fields[0],
+				Description: ParseText(fields[1:]),
+			})
+		}
+		return
+	}
+
+	if strings.TrimSpace(line) == "" {
+		//advance time on every empty line (unless auto-tick mode takes care of that)
+		if !p.AutoTick {
+			p.advanceTime()
+		}
+		return
+	}
+
+	//multiple commands on the same line (separated by ";") happen at the same time
+	lineHasEvent := false
+	for _, command := range SplitCommands(line) {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+
+		//events may be prefixed with a wall-clock timestamp (e.g. "@12:00:01.250")
+		if strings.HasPrefix(fields[0], "@") {
+			ts := parseTimestamp(fields[0])
+			if existing, exists := diagram.Timestamps[p.Time]; !exists || ts < existing {
+				diagram.Timestamps[p.Time] = ts
+			}
+			fields = fields[1:]
+			if len(fields) == 0 {
+				continue
+			}
+		}
+
+		isEvent := true
+		switch fields[0] {
+		case "start":
+			parseStart(fields[1:], p.Time, actors)
+		case "stop":
+			parseStop(fields[1:], p.Time, actors)
+		case "label":
+			parseLabel(fields[1:], actors)
+			isEvent = false
+		case "style":
+			parseStyle(fields[1:], actors)
+			isEvent = false
+		case "participant":
+			parseParticipant(fields[1:], actors)
+			isEvent = false
+		case "stereotype":
+			parseStereotype(fields[1:], actors)
+			isEvent = false
+		case "send", "call", "return":
+			parseSend(fields[1:], fields[0], p.Time, actors, messages)
+		case "send!", "call!", "return!":
+			p.Pending = append(p.Pending, parseAutoSend(fields[1:], strings.TrimSuffix(fields[0], "!"), p.Time, actors, messages))
+		case "receive":
+			parseReceive(fields[1:], p.Time, actors, messages)
+		case "delay":
+			gap := parseDelay(fields[1:], p.Time)
+			for p.Time < gap.StopTime {
+				p.advanceTime()
+			}
+			if gap.Label != "" {
+				diagram.Gaps = append(diagram.Gaps, gap)
+			}
+			isEvent = false
+		case "skip":
+			gap := &Gap{StartTime: p.Time, StopTime: p.Time + SkipSteps, Label: ParseText(fields[1:]), Torn: true}
+			for p.Time < gap.StopTime {
+				p.advanceTime()
+			}
+			diagram.Gaps = append(diagram.Gaps, gap)
+			isEvent = false
+		case "timeout":
+			parseTimeout(fields[1:], p.Time, actors, messages)
+		case "hide-return":
+			parseHideReturn(fields[1:], messages)
+			isEvent = false
+		case "placement":
+			parsePlacement(fields[1:], messages)
+			isEvent = false
+		case "timer":
+			parseTimer(fields[1:], p.Time, diagram, p.RunningTimers)
+		case "legend":
+			if len(fields) != 1 {
+				fail("wrong number of arguments for 'legend': expected 0, got %d", len(fields)-1)
+			}
+			p.InLegend = true
+			isEvent = false
+		case "divider":
+			diagram.Dividers = append(diagram.Dividers, &Divider{Time: p.Time, Label: ParseText(fields[1:])})
+		case "state":
+			diagram.States = append(diagram.States, parseState(fields[1:], p.Time, actors))
+		case "coregion":
+			parseCoregion(fields[1:], p.Time, diagram)
+		case "annotate":
+			if len(fields) < 2 {
+				fail("wrong number of arguments for 'annotate': expected 1, got 0")
+			}
+			diagram.Annotations = append(diagram.Annotations, &Annotation{Time: p.Time, Text: ParseText(fields[1:])})
+			isEvent = false
+		case "spacing":
+			diagram.Spacings[p.Time] = parseSpacing(fields[1:])
+			isEvent = false
+		case "constraint":
+			diagram.Constraints = append(diagram.Constraints, parseConstraint(fields[1:], messages))
+			isEvent = false
+		case "before":
+			diagram.Orderings = append(diagram.Orderings, parseBefore(fields[1:], messages))
+			isEvent = false
+		case "option":
+			if len(fields) != 2 {
+				fail("wrong number of arguments for 'option': expected 1, got %d", len(fields)-1)
+			}
+			switch fields[1] {
+			case "auto-tick":
+				p.AutoTick = true
+			default:
+				fail("unknown option: %s", fields[1])
+			}
+			isEvent = false
+		case "together":
+			if !p.AutoTick {
+				fail("'together' is only allowed in auto-tick mode")
+			}
+			if p.InTogether {
+				fail("'together' blocks cannot be nested")
+			}
+			p.InTogether = true
+			isEvent = false
+		case "end":
+			if !p.InTogether {
+				fail("'end' without matching 'together'")
+			}
+			p.InTogether = false
+			p.advanceTime()
+			isEvent = false
+		default:
+			fail("unknown command: %s", fields[0])
+		}
+		p.checkCommandLimits(fields)
+
+		lineHasEvent = lineHasEvent || isEvent
+	}
+
+	if p.AutoTick && lineHasEvent && !p.InTogether {
+		p.advanceTime()
+	}
+}
+
+// IsComment returns whether the line is a comment.
+func IsComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// Finish checks that the diagram in p.Diagram is complete at the end of the
+// input.
+func (p *Parser) Finish() (err error) {
+	defer catchError(&err)
+	p.finish()
+	return nil
+}
+
+func (p *Parser) finish() {
+	diagram, actors, messages := p.Diagram, p.Diagram.Actors, p.Diagram.Messages
+	if p.InTogether {
+		fail("unterminated 'together' block")
+	}
+	if p.InLegend {
+		fail("unterminated 'legend' block")
+	}
+	for _, entry := range diagram.Legend {
+		if _, exists := actors[entry.ActorName]; !exists {
+			fail("legend refers to unknown actor %s", entry.ActorName)
+		}
+	}
+	//messages sent on the last tick are received after the end of the input
+	for _, pending := range p.Pending {
+		parseReceive([]string{pending.ReceiverName, pending.MessageName}, pending.Time, actors, messages)
+	}
+
+	for _, actor := range sortedActors(actors) {
+		if actor.ActivityCount > 0 {
+			fail("actor %s has %d unfinished activities", actor.Name, actor.ActivityCount)
+		}
+	}
+	for _, name := range sortedMessageNames(messages) {
+		if messages[name].ReceiverName == "" {
+			fail("message %s was not received by anyone", name)
+		}
+	}
+	for _, coregion := range diagram.Coregions {
+		if coregion.StopTime == 0 {
+			fail("unterminated coregion on actor %s", coregion.ActorName)
+		}
+	}
+	for _, ordering := range diagram.Orderings {
+		ordering.check()
+	}
+
+	//declared participants are drawn even if they are not used, but that is
+	//probably a leftover from an earlier version of the diagram
+	used := make(map[string]bool)
+	for _, message := range messages {
+		used[message.SenderName] = true
+		used[message.ReceiverName] = true
+	}
+	for _, actor := range sortedActors(actors) {
+		if actor.Declared && len(actor.Activities) == 0 && !used[actor.Name] {
+			warn("participant %s is declared, but never used", actor.Name)
+		}
+	}
+}
+
+// SplitCommands splits a line into the commands separated by ";". A literal
+// semicolon can be written as "\;".
+func SplitCommands(line string) []string {
+	//fast path for the common case
+	if !strings.Contains(line, ";") {
+		return []string{line}
+	}
+
+	var commands []string
+	var current strings.Builder
+	for idx := 0; idx < len(line); idx++ {
+		switch {
+		case strings.HasPrefix(line[idx:], `\;`):
+			current.WriteByte(';')
+			idx++
+		case line[idx] == ';':
+			commands = append(commands, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(line[idx])
+		}
+	}
+	return append(commands, current.String())
+}
+
+func makeActor(name string, actors map[string]*Actor) *Actor {
+	actor, exists := actors[name]
+	if !exists {
+		actor = &Actor{Name: name, Label: name, DisplayOrder: uint(len(actors))}
+		actors[name] = actor
+	}
+	return actor
+}
+
+func parseStart(args []string, time uint, actors map[string]*Actor) {
+	if len(args) < 1 {
+		fail("wrong number of arguments for 'start': expected at least 1, got %d", len(args))
+	}
+	actor := makeActor(args[0], actors)
+	activity := &Activity{StartTime: time, Label: ParseText(args[1:]), Layer: actor.ActivityCount}
+	actor.Activities = append(actor.Activities, activity)
+	actor.ActivityCount++
+}
+
+func parseStop(args []string, time uint, actors map[string]*Actor) {
+	if len(args) != 1 {
+		fail("wrong number of arguments for 'stop': expected 1, got %d", len(args))
+	}
+	actor := makeActor(args[0], actors)
+
+	//the innermost running activity is the one that was started last (searching
+	//backwards keeps this cheap for actors with many activities)
+	var activityToStop *Activity
+	for idx := len(actor.Activities) - 1; idx >= 0; idx-- {
+		if actor.Activities[idx].StopTime == 0 {
+			activityToStop = actor.Activities[idx]
+			break
+		}
+	}
+	if activityToStop == nil {
+		fail("cannot stop actor %s: not active", actor.Name)
+	}
+
+	activityToStop.StopTime = time
+	actor.ActivityCount--
+}
+
+func parseLabel(args []string, actors map[string]*Actor) {
+	if len(args) < 2 {
+		fail("wrong number of arguments for 'label': expected 2, got %d", len(args))
+	}
+	actor := makeActor(args[0], actors)
+	actor.Label = strings.Join(args[1:], " ")
+}
+
+// parseParticipant declares an actor, with an optional label. Declarations
+// reserve the actor's column (in the order of the declarations) even if the
+// actor never becomes active, so they must come before any other use.
+func parseParticipant(args []string, actors map[string]*Actor) {
+	if len(args) < 1 {
+		fail("wrong number of arguments for 'participant': expected at least 1, got %d", len(args))
+	}
+	if _, exists := actors[args[0]]; exists {
+		fail("cannot declare participant %s: already declared or used before", args[0])
+	}
+	actor := makeActor(args[0], actors)
+	actor.Declared = true
+	if len(args) > 1 {
+		actor.Label = strings.Join(args[1:], " ")
+	}
+}
+
+// parseStereotype sets the stereotype of an actor. Guillemets around it are
+// optional, and may also be written as "<<" and ">>".
+func parseStereotype(args []string, actors map[string]*Actor) {
+	if len(args) < 2 {
+		fail("wrong number of arguments for 'stereotype': expected 2, got %d", len(args))
+	}
+	actor := makeActor(args[0], actors)
+	text := ParseText(args[1:])
+	for _, quotes := range [][2]string{{"«", "»"}, {"<<", ">>"}} {
+		if strings.HasPrefix(text, quotes[0]) && strings.HasSuffix(text, quotes[1]) {
+			text = strings.TrimSuffix(strings.TrimPrefix(text, quotes[0]), quotes[1])
+		}
+	}
+	if strings.TrimSpace(text) == "" {
+		fail("stereotype of actor %s is empty", actor.Name)
+	}
+	actor.Stereotype = text
+}
+
+func parseState(args []string, time uint, actors map[string]*Actor) *StateInvariant {
+	if len(args) < 2 {
+		fail("wrong number of arguments for 'state': expected 2, got %d", len(args))
+	}
+	actor := makeActor(args[0], actors)
+	return &StateInvariant{ActorName: actor.Name, Time: time, Label: ParseText(args[1:])}
+}
+
+func parseCoregion(args []string, time uint, diagram *Diagram) {
+	if len(args) != 2 {
+		fail("wrong number of arguments for 'coregion': expected 2, got %d", len(args))
+	}
+	actor := makeActor(args[1], diagram.Actors)
+	var running *Coregion
+	for _, coregion := range diagram.Coregions {
+		if coregion.ActorName == actor.Name && coregion.StopTime == 0 {
+			running = coregion
+		}
+	}
+
+	switch args[0] {
+	case "begin":
+		if running != nil {
+			fail("cannot begin coregion on actor %s: already in a coregion", actor.Name)
+		}
+		diagram.Coregions = append(diagram.Coregions, &Coregion{ActorName: actor.Name, StartTime: time})
+	case "end":
+		if running == nil {
+			fail("cannot end coregion on actor %s: not in a coregion", actor.Name)
+		}
+		if running.StartTime == time {
+			fail("cannot end coregion on actor %s at the same time as it began", actor.Name)
+		}
+		running.StopTime = time
+	default:
+		fail("unknown coregion action: %s (expected begin or end)", args[0])
+	}
+}
+
+// parseStyle parses a command like "style worker lifeline=solid color=blue
+// width=2". All attributes are optional.
+func parseStyle(args []string, actors map[string]*Actor) {
+	if len(args) < 2 {
+		fail("wrong number of arguments for 'style': expected at least 2, got %d", len(args))
+	}
+	actor := makeActor(args[0], actors)
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || value == "" {
+			fail(`invalid argument for 'style': expected "key=value", got %s`, arg)
+		}
+		switch key {
+		case "lifeline":
+			if value != "dashed" && value != "dotted" && value != "solid" {
+				fail("invalid lifeline style: %s (expected dashed, dotted or solid)", value)
+			}
+			actor.Lifeline.Pattern = value
+		case "color":
+			actor.Lifeline.Color = value
+		case "width":
+			width, err := strconv.ParseUint(value, 10, 0)
+			if err != nil || width == 0 {
+				fail("invalid lifeline width: expected a positive number, got %s", value)
+			}
+			actor.Lifeline.Width = uint(width)
+		case "head":
+			if value != "rectangle" && value != "rounded" && value != "ellipse" && value != "hexagon" {
+				fail("invalid head shape: %s (expected rectangle, rounded, ellipse or hexagon)", value)
+			}
+			actor.HeadShape = value
+		case "multiplicity":
+			actor.Multiplicity = value
+		default:
+			fail("unknown style attribute: %s (expected lifeline, color, width, head or multiplicity)", key)
+		}
+	}
+}
+
+func parseDelay(args []string, time uint) *Gap {
+	if len(args) < 1 {
+		fail("wrong number of arguments for 'delay': expected 1, got %d", len(args))
+	}
+	steps, err := strconv.ParseUint(args[0], 10, 0)
+	if err != nil || steps == 0 {
+		fail("invalid argument for 'delay': expected a positive number, got %s", args[0])
+	}
+	if steps > MaxDelaySteps {
+		fail("invalid argument for 'delay': must not be larger than %d, got %s", MaxDelaySteps, args[0])
+	}
+	return &Gap{
+		StartTime: time,
+		StopTime:  time + uint(steps),
+		Label:     strings.Join(args[1:], " "),
+	}
+}
+
+// parseTimestamp accepts either a time of day like "@12:00:01.250" or a
+// duration like "@1.25s" (relative to an arbitrary point in time).
+func parseTimestamp(field string) time.Duration {
+	str := strings.TrimPrefix(field, "@")
+	if d, err := time.ParseDuration(str); err == nil {
+		return d
+	}
+	t, err := time.Parse("15:04:05.999999999", str)
+	if err != nil {
+		fail("invalid timestamp: %s", field)
+	}
+	return t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()))
+}
+
+func formatTimestamp(ts time.Duration) string {
+	ms := ts.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// ParseText joins the given arguments into a free-form text, removing the
+// quotes if the whole text is enclosed in double quotes.
+func ParseText(args []string) string {
+	text := strings.Join(args, " ")
+	if len(text) >= 2 && strings.HasPrefix(text, `"`) && strings.HasSuffix(text, `"`) {
+		text = text[1 : len(text)-1]
+	}
+	return text
+}
+
+func parseEventRef(arg string, messages map[string]*Message) EventRef {
+	var ref EventRef
+	name := arg
+	switch {
+	case strings.HasSuffix(arg, ".send"):
+		name = strings.TrimSuffix(arg, ".send")
+	case strings.HasSuffix(arg, ".receive"):
+		name = strings.TrimSuffix(arg, ".receive")
+		ref.Receive = true
+	default:
+		fail("invalid event reference: %s (expected <message>.send or <message>.receive)", arg)
+	}
+	msg, exists := messages[name]
+	if !exists {
+		fail("cannot refer to message %s: has not been sent yet", name)
+	}
+	ref.Message = msg
+	return ref
+}
+
+func parseBefore(args []string, messages map[string]*Message) *GeneralOrdering {
+	if len(args) != 2 {
+		fail("wrong number of arguments for 'before': expected 2, got %d", len(args))
+	}
+	ordering := &GeneralOrdering{
+		Before: parseEventRef(args[0], messages),
+		After:  parseEventRef(args[1], messages),
+	}
+	if ordering.Before.Message == ordering.After.Message {
+		fail("cannot order events of the same message %s", ordering.Before.Message.Name)
+	}
+	ordering.check()
+	return ordering
+}
+
+// check fails if the diagram shows the events in the opposite order. Events
+// that have not happened yet are checked again by finish().
+func (ordering *GeneralOrdering) check() {
+	before, after := ordering.Before, ordering.After
+	if before.hasHappened() && after.hasHappened() && before.Time() > after.Time() {
+		fail("ordering %s before %s contradicts the diagram: %s happens at tick %d, %s at tick %d",
+			before, after, before, before.Time(), after, after.Time(),
+		)
+	}
+}
+
+func parseSpacing(args []string) uint {
+	if len(args) != 1 {
+		fail("wrong number of arguments for 'spacing': expected 1, got %d", len(args))
+	}
+	step, err := strconv.ParseUint(args[0], 10, 0)
+	if err != nil || step == 0 {
+		fail("invalid argument for 'spacing': expected a positive number, got %s", args[0])
+	}
+	return uint(step)
+}
+
+func parseHideReturn(args []string, messages map[string]*Message) {
+	if len(args) != 1 {
+		fail("wrong number of arguments for 'hide-return': expected 1, got %d", len(args))
+	}
+	msg, exists := messages[args[0]]
+	if !exists {
+		fail("cannot hide return of message %s: has not been sent yet", args[0])
+	}
+	if msg.Kind != "call" {
+		fail("cannot hide return of message %s: is not a call", args[0])
+	}
+	msg.HideReturn = true
+}
+
+func parsePlacement(args []string, messages map[string]*Message) {
+	if len(args) < 2 {
+		fail("wrong number of arguments for 'placement': expected at least 2, got %d", len(args))
+	}
+	msg, exists := messages[args[0]]
+	if !exists {
+		fail("cannot place label of message %s: has not been sent yet", args[0])
+	}
+	if err := msg.Placement.Set(strings.Join(args[1:], ",")); err != nil {
+		fail(err.Error())
+	}
+}
+
+func parseTimeout(args []string, time uint, actors map[string]*Actor, messages map[string]*Message) {
+	if len(args) != 2 {
+		fail("wrong number of arguments for 'timeout': expected 2, got %d", len(args))
+	}
+	name := args[0]
+	msg, exists := messages[name]
+	if !exists {
+		fail("cannot time out message %s: has not been sent yet", name)
+	}
+	if msg.ReceiverName != "" {
+		fail("cannot time out message %s: has already been received", name)
+	}
+	receiver := makeActor(args[1], actors)
+
+	//a caller does not wait for the response to a call that timed out
+	if sender := actors[msg.SenderName]; sender != nil && sender.BlockedByCall == msg {
+		sender.BlockedByCall = nil
+	}
+	//and a caller whose response timed out does not wait for it anymore either
+	if msg.Kind == "return" && receiver.BlockedByCall != nil && receiver.BlockedByCall.ReceiverName == msg.SenderName {
+		msg.Hidden = receiver.BlockedByCall.HideReturn
+		receiver.BlockedByCall = nil
+	}
+
+	msg.TimedOut = true
+	msg.ReceiverName = receiver.Name
+	msg.ReceiverTime = time
+	if receiver.ActivityCount > 0 {
+		msg.ReceiverLayer = receiver.ActivityCount - 1
+	}
+}
+
+func parseTimer(args []string, time uint, diagram *Diagram, runningTimers map[string]*Timer) {
+	if len(args) < 3 {
+		fail("wrong number of arguments for 'timer': expected 3, got %d", len(args))
+	}
+	actor := makeActor(args[1], diagram.Actors)
+	name := args[2]
+	key := actor.Name + " " + name
+	timer := runningTimers[key]
+
+	switch args[0] {
+	case "set":
+		if timer != nil {
+			fail("cannot set timer %s on actor %s: already running", name, actor.Name)
+		}
+		timer = &Timer{ActorName: actor.Name, Name: name, Label: ParseText(args[3:]), SetTime: time}
+		diagram.Timers = append(diagram.Timers, timer)
+		runningTimers[key] = timer
+	case "cancel", "expire":
+		if len(args) != 3 {
+			fail("wrong number of arguments for 'timer %s': expected 2, got %d", args[0], len(args)-1)
+		}
+		if timer == nil {
+			fail("cannot %s timer %s on actor %s: not running", args[0], name, actor.Name)
+		}
+		timer.StopTime = time
+		timer.Expired = args[0] == "expire"
+		delete(runningTimers, key)
+	default:
+		fail("unknown timer action: %s (expected set, cancel or expire)", args[0])
+	}
+}
+
+func parseConstraint(args []string, messages map[string]*Message) *Constraint {
+	if len(args) < 3 {
+		fail("wrong number of arguments for 'constraint': expected 3, got %d", len(args))
+	}
+	constraint := &Constraint{
+		From:  parseEventRef(args[0], messages),
+		To:    parseEventRef(args[1], messages),
+		Label: ParseText(args[2:]),
+	}
+	if isGate(constraint.From.ActorName()) {
+		fail("cannot start constraint at %s: is at a gate, not on a lifeline", args[0])
+	}
+	return constraint
+}
+
+func parseSend(args []string, kind string, time uint, actors map[string]*Actor, messages map[string]*Message) {
+	if len(args) < 3 {
+		fail("wrong number of arguments for '%s': expected 3, got %d", kind, len(args))
+	}
+	if gate := gateName(args[0]); gate != "" {
+		parseGateSend(gate, args, kind, time, messages)
+		return
+	}
+	sender := makeActor(args[0], actors)
+
+	name := args[1]
+	if previous, exists := messages[name]; exists {
+		if previous.ReceiverName == "" {
+			fail("cannot send message %s again before it has been received", name)
+		}
+		//message names may be reused once the previous message with that name
+		//has been received; the name then refers to the new message, and the
+		//previous one is kept under a unique name
+		messages[uniqueMessageName(name, messages)] = previous
+	}
+	if sender.BlockedByCall != nil {
+		fail("actor %s cannot send message %s while waiting for response to %s", sender.Name, name, sender.BlockedByCall.Name)
+	}
+
+	if sender.ActivityCount == 0 {
+		fail("actor %s cannot send message %s while not active", sender.Name, name)
+	}
+
+	msg := &Message{
+		Kind:        kind,
+		Name:        name,
+		Label:       strings.Join(args[2:], " "),
+		SenderName:  sender.Name,
+		SenderTime:  time,
+		SenderLayer: sender.ActivityCount - 1,
+	}
+	messages[name] = msg
+	switch kind {
+	case "call":
+		sender.BlockedByCall = msg
+	case "return":
+		parseStop([]string{sender.Name}, time, actors)
+	}
+}
+
+// parseGateSend is like parseSend, but for messages that enter the diagram
+// through a gate on the frame's left or right edge. There is no sender whose
+// activities and calls would have to be checked.
+func parseGateSend(gate string, args []string, kind string, time uint, messages map[string]*Message) {
+	name := args[1]
+	if previous, exists := messages[name]; exists {
+		if previous.ReceiverName == "" {
+			fail("cannot send message %s again before it has been received", name)
+		}
+		messages[uniqueMessageName(name, messages)] = previous
+	}
+	messages[name] = &Message{
+		Kind:       kind,
+		Name:       name,
+		Label:      strings.Join(args[2:], " "),
+		SenderName: gate,
+		SenderTime: time,
+	}
+}
+
+// autoReceive is a receive that was implied by a "send!", "call!" or "return!"
+// command and which will be executed on the next tick.
+type autoReceive struct {
+	ReceiverName string
+	MessageName  string
+	Time         uint
+}
+
+func parseAutoSend(args []string, kind string, time uint, actors map[string]*Actor, messages map[string]*Message) autoReceive {
+	if len(args) < 4 {
+		fail("wrong number of arguments for '%s!': expected 4, got %d", kind, len(args))
+	}
+	//the receiver name is removed from the args for parseSend
+	parseSend(append([]string{args[0], args[1]}, args[3:]...), kind, time, actors, messages)
+	return autoReceive{ReceiverName: args[2], MessageName: args[1], Time: time + 1}
+}
+
+func receivePending(pending []autoReceive, time uint, actors map[string]*Actor, messages map[string]*Message) []autoReceive {
+	var remaining []autoReceive
+	for _, p := range pending {
+		if p.Time <= time {
+			parseReceive([]string{p.ReceiverName, p.MessageName}, p.Time, actors, messages)
+		} else {
+			remaining = append(remaining, p)
+		}
+	}
+	return remaining
+}
+
+func parseReceive(args []string, time uint, actors map[string]*Actor, messages map[string]*Message) {
+	if len(args) != 2 {
+		fail("wrong number of arguments for 'stop': expected 2, got %d", len(args))
+	}
+	name := args[1]
+	msg, exists := messages[name]
+	if !exists {
+		fail("cannot receive message %s: has not been sent yet", name)
+	}
+	if msg.TimedOut {
+		fail("cannot receive message %s: has timed out", name)
+	}
+	if gate := gateName(args[0]); gate != "" {
+		//the message leaves the diagram through a gate on the frame's edge
+		if isGate(msg.SenderName) {
+			fail("cannot receive message %s at a gate: was sent from a gate", name)
+		}
+		msg.ReceiverName = gate
+		msg.ReceiverTime = time
+		return
+	}
+	receiver := makeActor(args[0], actors)
+
+	if receiver.BlockedByCall == nil {
+		if msg.Kind == "return" {
+			fail("actor %s cannot receive return message without having made a call", receiver.Name)
+		}
+	} else {
+		if msg.Kind != "return" {
+			fail("actor %s cannot receive message %s while waiting for response to %s",
+				receiver.Name, name, receiver.BlockedByCall.Name)
+		}
+		msg.Hidden = receiver.BlockedByCall.HideReturn
+		called := receiver.BlockedByCall.ReceiverName
+		if called != msg.SenderName {
+			fail("actor %s cannot receive response to message %s from actor %s (expected actor %s)",
+				receiver.Name, receiver.BlockedByCall.Name, msg.SenderName, called,
+			)
+		}
+		receiver.BlockedByCall = nil
+	}
+
+	if msg.Kind == "call" {
+		parseStart([]string{receiver.Name}, time, actors)
+	}
+
+	if receiver.ActivityCount == 0 {
+		fail("actor %s cannot receive message %s while not active", receiver.Name, name)
+	}
+
+	msg.ReceiverName = receiver.Name
+	msg.ReceiverTime = time
+	msg.ReceiverLayer = receiver.ActivityCount - 1
+}
+
+// sortedMessages returns the messages in the order in which they were sent.
+// Everything that produces output or numbers messages shall iterate over the
+// messages in this order (and over the actors in the order of sortedActors),
+// so that the output does not depend on the iteration order of maps.
+func sortedMessages(messages map[string]*Message) []*Message {
+	result := make([]*Message, 0, len(messages))
+	for _, name := range sortedMessageNames(messages) {
+		result = append(result, messages[name])
+	}
+	return result
+}
+
+// sortedMessageNames returns the keys of the messages map in the order of
+// sortedMessages.
+func sortedMessageNames(messages map[string]*Message) []string {
+	names := make([]string, 0, len(messages))
+	for name := range messages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		m1, m2 := messages[names[i]], messages[names[j]]
+		if m1.SenderTime != m2.SenderTime {
+			return m1.SenderTime < m2.SenderTime
+		}
+		if m1.ReceiverTime != m2.ReceiverTime {
+			return m1.ReceiverTime < m2.ReceiverTime
+		}
+		if m1.Name != m2.Name {
+			return m1.Name < m2.Name
+		}
+		return names[i] < names[j] //for messages that reuse a name (see uniqueMessageName)
+	})
+	return names
+}
+
+// sortedActors returns the actors in display order.
+func sortedActors(actors map[string]*Actor) []*Actor {
+	result := make([]*Actor, 0, len(actors))
+	for _, actor := range actors {
+		result = append(result, actor)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DisplayOrder < result[j].DisplayOrder
+	})
+	return result
+}
+
+func uniqueMessageName(name string, messages map[string]*Message) string {
+	for idx := 1; ; idx++ {
+		candidate := fmt.Sprintf("%s#%d", name, idx)
+		if _, exists := messages[candidate]; !exists {
+			return candidate
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// layout calculations
+
+func getMaxTime(diagram *Diagram) (max uint) {
+	for _, divider := range diagram.Dividers {
+		if max < divider.Time {
+			max = divider.Time
+		}
+	}
+	for _, gap := range diagram.Gaps {
+		if max < gap.StopTime {
+			max = gap.StopTime
+		}
+	}
+	for _, state := range diagram.States {
+		if max < state.Time {
+			max = state.Time
+		}
+	}
+	for _, coregion := range diagram.Coregions {
+		if max < coregion.StopTime {
+			max = coregion.StopTime
+		}
+	}
+	for _, timer := range diagram.Timers {
+		if max < timer.SetTime {
+			max = timer.SetTime
+		}
+		if max < timer.StopTime {
+			max = timer.StopTime
+		}
+	}
+	for _, actor := range diagram.Actors {
+		for _, activity := range actor.Activities {
+			if max < activity.StopTime {
+				max = activity.StopTime
+			}
+		}
+	}
+	return
+}
+
+// Layout contains the vertical position of each point in time.
+type Layout struct {
+	TimeY          []uint
+	LastStep       uint   //vertical distance per unit of time after the last entry in TimeY
+	Width          uint   //of all swimlanes together
+	HeadHeight     uint   //of the highest actor head (see Actor.headHeight)
+	CompressedGaps []*Gap //only in proportional layout
+	Options        *Options
+}
+
+func computeLayout(diagram *Diagram, maxTime uint, opts *Options) *Layout {
+	defer opts.Timings.Measure("layout")()
+	layout := &Layout{TimeY: make([]uint, maxTime+3), Width: uint(len(diagram.Actors)) * SwimlaneWidth, Options: opts}
+	layout.TimeY[0] = HeaderHeight
+	layout.LastStep = opts.Theme.Spacing
+	for _, actor := range diagram.Actors {
+		if height := actor.headHeight(); layout.HeadHeight < height {
+			layout.HeadHeight = height
+		}
+	}
+
+	//labels with multiple lines need additional space above their arrow
+	extraLines := make(map[uint]uint)
+	for _, msg := range diagram.Messages {
+		lines := uint(len(labelLines(msg.Label)))
+		if lines > 1 && lines-1 > extraLines[msg.SenderTime] && msg.isDrawn(opts) {
+			extraLines[msg.SenderTime] = lines - 1
+		}
+	}
+
+	for t := uint(1); t < uint(len(layout.TimeY)); t++ {
+		if step, exists := diagram.Spacings[t-1]; exists {
+			layout.LastStep = step
+		}
+		layout.TimeY[t] = layout.TimeY[t-1] + diagram.stepHeight(t, layout.LastStep, opts) + extraLines[t]*MessageLineHeight
+
+		//mark the places where the time was compressed by the proportional layout
+		if elapsed, exists := diagram.elapsed(t); exists && opts.Proportional && elapsed > time.Duration(opts.MaxGap)*opts.TimeScale {
+			layout.CompressedGaps = append(layout.CompressedGaps, &Gap{
+				StartTime: t - 1,
+				StopTime:  t,
+				Label:     "+" + elapsed.String(),
+				Torn:      true,
+			})
+		}
+	}
+	return layout
+}
+
+// elapsed returns the wall-clock time between the points in time t-1 and t,
+// if both have a timestamp.
+func (diagram *Diagram) elapsed(t uint) (time.Duration, bool) {
+	ts1, exists1 := diagram.Timestamps[t-1]
+	ts2, exists2 := diagram.Timestamps[t]
+	return ts2 - ts1, exists1 && exists2
+}
+
+// stepHeight returns the vertical distance between the points in time t-1 and
+// t, given the current vertical distance per unit of time.
+func (diagram *Diagram) stepHeight(t uint, step uint, opts *Options) uint {
+	if !opts.Proportional {
+		return step
+	}
+	elapsed, exists := diagram.elapsed(t)
+	if !exists {
+		return step
+	}
+	steps := float64(elapsed) / float64(opts.TimeScale)
+	if steps < 1 {
+		steps = 1
+	}
+	if steps > float64(opts.MaxGap) {
+		steps = float64(opts.MaxGap)
+	}
+	return uint(steps*float64(step) + 0.5)
+}
+
+// bottom returns the vertical position below the lifelines (and the actor
+// footers, if any), where the tables below the diagram begin.
+func (layout *Layout) bottom(maxTime uint) uint {
+	if layout.Options.ActorFooters {
+		return layout.Y(maxTime+2) + layout.HeadHeight
+	}
+	return layout.Y(maxTime + 2)
+}
+
+// Y returns the vertical position of the given point in time.
+func (layout *Layout) Y(t uint) uint {
+	last := uint(len(layout.TimeY)) - 1
+	if t > last {
+		return layout.TimeY[last] + (t-last)*layout.LastStep
+	}
+	return layout.TimeY[t]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// rendering
+
+func (actor *Actor) drawSwimLane(w io.Writer, maxTime uint, layout *Layout) {
+	actor.drawHead(w, HeaderHeight-actor.headHeight(), layout.Options)
+	left := actor.DisplayOrder * SwimlaneWidth
+	if layout.Options.ShadeSwimlanes && actor.DisplayOrder%2 == 1 {
+		fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" fill="black" fill-opacity="0.04" />`,
+			left, HeaderHeight, SwimlaneWidth, layout.Y(maxTime+1)-HeaderHeight,
+		)
+	}
+	if layout.Options.SwimlaneSeparators && actor.DisplayOrder > 0 {
+		fmt.Fprintf(w, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="lightgray" />`,
+			left, left, HeaderHeight-LabelHeight, layout.Y(maxTime+1),
+		)
+	}
+	x := left + SwimlaneWidth/2
+	fmt.Fprintf(w, `<line x1="%d" x2="%d" y1="%d" y2="%d" %s/>`,
+		x, x, HeaderHeight, layout.Y(maxTime+1), actor.Lifeline.attributes(),
+	)
+	if layout.Options.ActorFooters {
+		actor.drawHead(w, layout.Y(maxTime+1), layout.Options)
+	}
+}
+
+// attributes returns the SVG attributes for drawing a lifeline in this style.
+func (style LifelineStyle) attributes() string {
+	color := "black"
+	if style.Color != "" {
+		color = html.EscapeString(style.Color)
+	}
+	result := fmt.Sprintf(`stroke="%s" `, color)
+	if style.Width > 1 {
+		result += fmt.Sprintf(`stroke-width="%d" `, style.Width)
+	}
+	switch style.Pattern {
+	case "", "dashed":
+		result += `stroke-dasharray="5,5" `
+	case "dotted":
+		result += `stroke-dasharray="2,3" `
+	}
+	return result
+}
+
+// headHeight returns the height of the actor's head box.
+func (actor *Actor) headHeight() uint {
+	if actor.Stereotype != "" {
+		return LabelHeight + StereotypeHeight
+	}
+	return LabelHeight
+}
+
+// drawHead renders the box with the actor's label (and stereotype, if any)
+// above its lifeline (or below it, see -actor-footers), starting at the given
+// vertical position.
+func (actor *Actor) drawHead(w io.Writer, top uint, opts *Options) {
+	x := actor.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2
+	height := actor.headHeight()
+	drawShape := func(x, top uint) {
+		switch actor.HeadShape {
+		case "rounded":
+			fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" rx="%d" stroke="black" fill="white" />`,
+				x-LabelWidth/2, top, LabelWidth, height, LabelHeight/3,
+			)
+		case "ellipse":
+			fmt.Fprintf(w, `<ellipse cx="%d" cy="%d" rx="%d" ry="%d" stroke="black" fill="white" />`,
+				x, top+height/2, LabelWidth/2, height/2,
+			)
+		case "hexagon":
+			left, right, middle, bottom := x-LabelWidth/2, x+LabelWidth/2, top+height/2, top+height
+			fmt.Fprintf(w, `<polygon points="%d,%d %d,%d %d,%d %d,%d %d,%d %d,%d" stroke="black" fill="white" />`,
+				left, middle, left+height/2, top, right-height/2, top,
+				right, middle, right-height/2, bottom, left+height/2, bottom,
+			)
+		default:
+			fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" stroke="black" fill="white" />`,
+				x-LabelWidth/2, top, LabelWidth, height,
+			)
+		}
+	}
+	label := actor.Label
+	if actor.Multiplicity != "" {
+		//a second head peeks out behind the first one, like a stack of cards
+		drawShape(x+MultiInstanceOffset, top+MultiInstanceOffset)
+		label += "[" + actor.Multiplicity + "]"
+	}
+	drawShape(x, top)
+	if actor.Stereotype != "" {
+		stereotype := "«" + actor.Stereotype + "»"
+		fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d"%s>%s</text>`,
+			x, top+StereotypeHeight, StereotypeFontSize, bidiAttributes(stereotype, "middle"), escapeText(stereotype, opts),
+		)
+	}
+	fmt.Fprintf(w, `<text x="%d" y="%g" font-size="%g"%s>%s</text>`,
+		x, float64(top+height)-0.25*LabelHeight, 0.7*LabelHeight, bidiAttributes(label, "middle"), escapeText(label, opts),
+	)
+}
+
+func (activity *Activity) drawBox(w io.Writer, actorDisplayOrder uint, layout *Layout) {
+	x := actorDisplayOrder*SwimlaneWidth + SwimlaneWidth/2 + activity.Layer*ActivityOffset
+	yStart := layout.Y(activity.StartTime)
+	yStop := layout.Y(activity.StopTime)
+	fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" stroke="black" fill="white" />`,
+		x-ActivityWidth/2, yStart, ActivityWidth, yStop-yStart,
+	)
+
+	//the label runs downwards along the box, and is shortened to fit into it
+	//(assuming an average width of 0.6 em for narrow characters), or left out
+	//if not even a few characters fit
+	if activity.Label == "" {
+		return
+	}
+	const padding = 4
+	maxWidth := int(float64(int(yStop)-int(yStart)-2*padding) / (0.6 * ActivityFontSize))
+	label, truncated := truncateText(activity.Label, maxWidth)
+	if truncated && maxWidth < 4 {
+		return
+	}
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d"%s dominant-baseline="middle" transform="rotate(90 %d %d)">%s</text>`,
+		x, int(yStart)+padding, ActivityFontSize, bidiAttributes(label, "start"), x, int(yStart)+padding, escapeText(label, layout.Options),
+	)
+}
+
+func (gap *Gap) draw(w io.Writer, width int, layout *Layout) {
+	y := 0.5 * float64(layout.Y(gap.StartTime)+layout.Y(gap.StopTime))
+	if gap.Torn {
+		//white band between two wavy lines that hides everything behind it
+		y1, y2 := y-TornGapHeight/2, y+TornGapHeight/2
+		waves := width/TornGapHeight + 1
+		fmt.Fprintf(w, `<path d="M 0 %g %s L %d %g %s z" fill="white" stroke="none" />`,
+			y1, wavyLine(waves, 1), waves*TornGapHeight, y2, wavyLine(waves, -1),
+		)
+		fmt.Fprintf(w, `<path d="M 0 %g %s" fill="none" stroke="black" />`, y1, wavyLine(waves, 1))
+		fmt.Fprintf(w, `<path d="M 0 %g %s" fill="none" stroke="black" />`, y2, wavyLine(waves, 1))
+	}
+	fmt.Fprintf(w, `<text x="%d" y="%g" font-size="%d" font-style="italic" text-anchor="middle">%s</text>`,
+		width/2, y+MessageFontSize/2, MessageFontSize, escapeText(gap.Label, layout.Options),
+	)
+}
+
+// draw renders the divider as a double line across all lifelines, with the
+// label in the middle. The label is outlined in white to stay readable on top
+// of the lines.
+func (divider *Divider) draw(w io.Writer, width int, layout *Layout) {
+	y := layout.Y(divider.Time)
+	for _, dy := range []int{-2, 2} {
+		fmt.Fprintf(w, `<line x1="0" x2="%d" y1="%d" y2="%d" stroke="dimgray" />`, width, int(y)+dy, int(y)+dy)
+	}
+	if divider.Label != "" {
+		fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d" font-weight="bold" text-anchor="middle" stroke="white" stroke-width="6" paint-order="stroke">%s</text>`,
+			width/2, y+MessageFontSize/3, MessageFontSize, escapeText(divider.Label, layout.Options),
+		)
+	}
+}
+
+// wavyLine returns path commands for a wavy line from the current point,
+// consisting of the given number of waves, in the given direction (1 =
+// rightwards, -1 = leftwards).
+func wavyLine(waves int, direction int) string {
+	dx := direction * TornGapHeight / 2
+	cmds := []string{fmt.Sprintf("q %d %d %d 0", dx/2, -TornGapHeight/4, dx)}
+	for idx := 1; idx < 2*waves; idx++ {
+		cmds = append(cmds, fmt.Sprintf("t %d 0", dx))
+	}
+	return strings.Join(cmds, " ")
+}
+
+// draw renders the annotation in the left margin (right of the ruler, if any)
+// whose right edge is at xMax.
+func (annotation *Annotation) draw(w io.Writer, xMax int, layout *Layout) {
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d" font-style="italic"%s fill="dimgray">%s</text>`,
+		xMax-ArrowTipSize, layout.Y(annotation.Time)+AnnotationFontSize/3, AnnotationFontSize, bidiAttributes(annotation.Text, "end"), richText(escapeText(annotation.Text, layout.Options)),
+	)
+}
+
+func tableHeight(rows [][2]string) uint {
+	if len(rows) == 0 {
+		return 0
+	}
+	return LegendLineHeight * uint(len(rows)+1)
+}
+
+// drawTable renders a two-column table (e.g. the legend) as a box below the
+// diagram, starting at the given vertical position.
+func drawTable(w io.Writer, rows [][2]string, y uint, width int) {
+	if len(rows) == 0 {
+		return
+	}
+	x := LegendLineHeight / 2
+	fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" stroke="black" fill="white" />`,
+		x, y, width-2*x, LegendLineHeight*len(rows)+x,
+	)
+	for idx, row := range rows {
+		yText := y + uint(LegendLineHeight*(idx+1))
+		fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d" font-weight="bold">%s</text>`,
+			2*x, yText, MessageFontSize, row[0],
+		)
+		fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d">%s</text>`,
+			2*x+LegendKeyWidth, yText, MessageFontSize, row[1],
+		)
+	}
+}
+
+func (diagram *Diagram) drawRuler(w io.Writer, maxTime uint, layout *Layout) {
+	x := RulerWidth - ArrowTipSize
+	fmt.Fprintf(w, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="gray" />`,
+		x, x, layout.Y(1), layout.Y(maxTime+1),
+	)
+	for t := uint(1); t <= maxTime+1; t++ {
+		y := layout.Y(t)
+		label := strconv.FormatUint(uint64(t), 10)
+		if ts, exists := diagram.Timestamps[t]; exists {
+			label = formatTimestamp(ts)
+		}
+		fmt.Fprintf(w, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="gray" />`,
+			x-ArrowTipSize/2, x, y, y,
+		)
+		fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d" text-anchor="end" fill="gray">%s</text>`,
+			x-ArrowTipSize, y+RulerFontSize/3, RulerFontSize, label,
+		)
+	}
+}
+
+// drawGridlines renders a faint horizontal line across the diagram at each
+// point in time, to show which events happen simultaneously.
+func drawGridlines(w io.Writer, maxTime uint, width int, layout *Layout) {
+	for t := uint(1); t <= maxTime; t++ {
+		y := layout.Y(t)
+		fmt.Fprintf(w, `<line x1="0" x2="%d" y1="%d" y2="%d" stroke="lightgray" stroke-width="0.5" />`,
+			width, y, y,
+		)
+	}
+}
+
+// draw renders the timer right of the actor's lifeline: an hourglass where it
+// was set, a cross where it was cancelled, and an hourglass with an arrow
+// pointing to the lifeline where it expired.
+func (timer *Timer) draw(w io.Writer, actor *Actor, layout *Layout) {
+	xLifeline := actor.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2 + ActivityWidth/2
+	x := xLifeline + ActivityWidth
+	y1 := layout.Y(timer.SetTime)
+	drawHourglass(w, x, y1)
+	label := timer.Name
+	if timer.Label != "" {
+		label += " (" + timer.Label + ")"
+	}
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d">%s</text>`,
+		x+TimerSymbolSize, y1+MessageFontSize/3, MessageFontSize, escapeText(label, layout.Options),
+	)
+	if timer.StopTime == 0 {
+		return
+	}
+
+	y2 := layout.Y(timer.StopTime)
+	fmt.Fprintf(w, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="black" />`,
+		x, x, y1+TimerSymbolSize/2, y2-TimerSymbolSize/2,
+	)
+	if timer.Expired {
+		drawHourglass(w, x, y2)
+		fmt.Fprintf(w, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="black" marker-end="url(#normal)" />`,
+			x-TimerSymbolSize/2, xLifeline+ArrowTipSize, y2, y2,
+		)
+	} else {
+		drawCross(w, x, y2)
+	}
+}
+
+// draw renders the state invariant as a rounded box that is centered on the
+// actor's lifeline, and covers its activity boxes.
+func (state *StateInvariant) draw(w io.Writer, actor *Actor, layout *Layout) {
+	x := int(actor.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2)
+	y := int(layout.Y(state.Time))
+	//the text is measured like activity labels in drawBox()
+	width := int(float64(TextWidth(state.Label))*0.6*StateFontSize) + StateHeight
+	fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" rx="%d" stroke="black" fill="white" />`,
+		x-width/2, y-StateHeight/2, width, StateHeight, StateHeight/2,
+	)
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d"%s dominant-baseline="middle">%s</text>`,
+		x, y, StateFontSize, bidiAttributes(state.Label, "middle"), escapeText(state.Label, layout.Options),
+	)
+}
+
+// draw renders the coregion in the UML notation: with brackets (rotated by 90
+// degrees) across the lifeline where it begins and ends.
+func (coregion *Coregion) draw(w io.Writer, actor *Actor, layout *Layout) {
+	x := int(actor.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2)
+	y1, y2 := int(layout.Y(coregion.StartTime)), int(layout.Y(coregion.StopTime))
+	const halfWidth, depth = ActivityWidth, 5
+	fmt.Fprintf(w, `<path d="M %d %d L %d %d L %d %d L %d %d M %d %d L %d %d L %d %d L %d %d" fill="none" stroke="black" stroke-width="2" />`,
+		x-halfWidth, y1+depth, x-halfWidth, y1, x+halfWidth, y1, x+halfWidth, y1+depth,
+		x-halfWidth, y2-depth, x-halfWidth, y2, x+halfWidth, y2, x+halfWidth, y2-depth,
+	)
+}
+
+// drawFrame renders the UML interaction frame around the lifelines, with the
+// "sd" name tag in its top left corner. Messages from and to gates (see
+// LeftBorder, RightBorder) start and end on its left and right edge.
+func drawFrame(w io.Writer, title string, x, width int, height uint, opts *Options) {
+	label := "sd"
+	if title != "" {
+		label += " " + title
+	}
+	//the text is measured like activity labels in drawBox()
+	tagWidth := int(float64(TextWidth(label))*0.6*FrameFontSize) + 2*MessageBaselineOffset + FrameHeaderHeight/3
+	fmt.Fprintf(w, `<rect x="%d" y="0" width="%d" height="%d" fill="none" stroke="black" />`, x, width, height)
+	fmt.Fprintf(w, `<path d="M %d 0 H %d V %d L %d %d H %d" fill="white" stroke="black" />`,
+		x, x+tagWidth, FrameHeaderHeight*2/3, x+tagWidth-FrameHeaderHeight/3, FrameHeaderHeight, x,
+	)
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d" font-weight="bold">%s</text>`,
+		x+MessageBaselineOffset, FrameHeaderHeight/2+FrameFontSize/3, FrameFontSize, escapeText(label, opts),
+	)
+}
+
+func drawCross(w io.Writer, x, y uint) {
+	fmt.Fprintf(w, `<path d="M %d %d l %d %d M %d %d l %d %d" stroke="black" />`,
+		x-TimerSymbolSize/2, y-TimerSymbolSize/2, TimerSymbolSize, TimerSymbolSize,
+		x+TimerSymbolSize/2, y-TimerSymbolSize/2, -TimerSymbolSize, TimerSymbolSize,
+	)
+}
+
+func drawHourglass(w io.Writer, x, y uint) {
+	fmt.Fprintf(w, `<path d="M %d %d h %d L %d %d h %d z" fill="white" stroke="black" />`,
+		x-TimerSymbolSize/2, y-TimerSymbolSize/2, TimerSymbolSize,
+		x-TimerSymbolSize/2, y+TimerSymbolSize/2, TimerSymbolSize,
+	)
+}
+
+// drawMeasure renders the constraint as a bracket left of the lifeline of the
+// actor involved in the first event.
+func (constraint *Constraint) drawMeasure(w io.Writer, actor *Actor, layout *Layout) {
+	x := actor.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2 - ActivityWidth
+	y1 := layout.Y(constraint.From.Time())
+	y2 := layout.Y(constraint.To.Time())
+	fmt.Fprintf(w, `<path d="M %d %d h %d V %d h %d" fill="none" stroke="black" />`,
+		x+ArrowTipSize/2, y1, -ArrowTipSize/2, y2, ArrowTipSize/2,
+	)
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="%d" text-anchor="end">%s</text>`,
+		x-MessageBaselineOffset, (y1+y2)/2+MessageFontSize/3, MessageFontSize, escapeText(constraint.Label, layout.Options),
+	)
+}
+
+// draw renders the general ordering as a dotted line between the two events,
+// with an arrowhead in the middle that points towards the later event.
+func (ordering *GeneralOrdering) draw(w io.Writer, actors map[string]*Actor, layout *Layout) {
+	position := func(ref EventRef) (x, y int) {
+		x, _ = layout.endpoint(actors[ref.ActorName()], ref.ActorName(), ref.Layer())
+		return x, int(layout.Y(ref.Time()))
+	}
+	x1, y1 := position(ordering.Before)
+	x2, y2 := position(ordering.After)
+	fmt.Fprintf(w, `<path d="M %d %d L %d %d L %d %d" fill="none" stroke="black" stroke-dasharray="2,3" marker-mid="url(#filled)" />`,
+		x1, y1, (x1+x2)/2, (y1+y2)/2, x2, y2,
+	)
+}
+
+// isDrawn returns whether an arrow is rendered for this message.
+func (message *Message) isDrawn(opts *Options) bool {
+	return !message.Hidden && !(opts.HideReturns && message.Kind == "return")
+}
+
+// arrowGeometry describes where drawArrow() places a message, as computed by
+// Message.geometry().
+type arrowGeometry struct {
+	X1, X2, Y1, Y2 int
+	Order1, Order2 int
+	Offset1        int  //half width of the sender's activity box (0 if not drawn)
+	Loop           bool //with -curved-arrows: message from an actor to itself
+	Arc            bool //with -curved-arrows: message that skips over other actors
+	XText, YText   int  //position of the label on straight arrows
+	OnLine         bool //if true, the label is centered on the line instead of above it
+}
+
+// drawArrow renders the message. The sender or receiver is nil if the
+// message starts or ends at the diagram border (see LeftBorder, RightBorder).
+func (message *Message) drawArrow(w io.Writer, sender *Actor, receiver *Actor, layout *Layout) {
+	g := message.geometry(sender, receiver, layout)
+	x1, x2, y1, y2 := g.X1, g.X2, g.Y1, g.Y2
+
+	opts := ""
+	if message.Kind == "return" {
+		opts += `stroke-dasharray="5,5"`
+	}
+	markerEnd := ""
+	if marker := arrowHeadMarkers[layout.Options.ArrowHeads[message.Kind]]; marker != "" {
+		markerEnd = fmt.Sprintf(`marker-end="url(#%s)" `, marker)
+	}
+
+	if message.TimedOut {
+		//the arrow ends in a cross (see geometry)
+		markerEnd = ""
+		drawCross(w, uint(x2), uint(y2))
+	}
+
+	stroke, textOpts := "black", ""
+	if message.Color != "" {
+		stroke = message.Color
+		if opts != "" {
+			opts += " "
+		}
+		opts += `stroke-width="2"`
+		textOpts = fmt.Sprintf(` fill="%s"`, message.Color)
+	}
+	label := message.displayLabel(layout.Options)
+
+	switch {
+	case g.Loop:
+		//loop out to the right of the activity box and back into it; the
+		//loop needs some height even if the message is received immediately
+		x := x1 + 2*g.Offset1
+		yStart, yEnd := y1, y2
+		if yEnd-yStart < ActivityWidth {
+			yStart, yEnd = (yStart+yEnd)/2-ActivityWidth/2, (yStart+yEnd)/2+ActivityWidth/2
+		}
+		fmt.Fprintf(w, `<path d="M %d %d C %d %d %d %d %d %d" fill="none" stroke="%s" %s%s/>`,
+			x, yStart, x+SelfLoopWidth, yStart, x+SelfLoopWidth, yEnd, x+ArrowTipSize, yEnd, stroke, markerEnd, opts,
+		)
+		drawMessageLabel(w, x+SelfLoopWidth, (yStart+yEnd)/2+MessageFontSize/3, "start", textOpts, label, true)
+	case g.Arc:
+		//a shallow arc that bulges upwards, with the label on its apex
+		dx := x2 - x1
+		if dx < 0 {
+			dx = -dx
+		}
+		yTop := y1
+		if y2 < yTop {
+			yTop = y2
+		}
+		xControl, yControl := (x1+x2)/2, yTop-dx/ArcFlatness
+		fmt.Fprintf(w, `<path d="M %d %d Q %d %d %d %d" fill="none" stroke="%s" %s%s/>`,
+			x1, y1, xControl, yControl, x2, y2, stroke, markerEnd, opts,
+		)
+		yApex := (y1 + 2*yControl + y2) / 4
+		drawMessageLabel(w, xControl, yApex-MessageBaselineOffset, "middle", textOpts, label, false)
+	default:
+		fmt.Fprintf(w, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="%s" %s%s/>`,
+			x1, x2, y1, y2, stroke, markerEnd, opts,
+		)
+		if g.OnLine {
+			//a white outline keeps the label readable on top of the line
+			textOpts += ` stroke="white" stroke-width="3" paint-order="stroke"`
+		}
+		//TODO: use <textPath> for asynchronous messages
+		drawMessageLabel(w, g.XText, g.YText, "middle", textOpts, label, g.OnLine)
+	}
+}
+
+// geometry computes the position of the arrow and label of the message. The
+// sender or receiver is nil if the message starts or ends at the diagram
+// border (see LeftBorder, RightBorder).
+func (message *Message) geometry(sender *Actor, receiver *Actor, layout *Layout) (g arrowGeometry) {
+	x1, order1 := layout.endpoint(sender, message.SenderName, message.SenderLayer)
+	x2, order2 := layout.endpoint(receiver, message.ReceiverName, message.ReceiverLayer)
+	y1 := int(layout.Y(message.SenderTime))
+	y2 := int(layout.Y(message.ReceiverTime))
+	//activity boxes only exist on lifelines, not on the border
+	var offset1, offset2 int
+	if sender != nil && !layout.Options.NoActivations {
+		offset1 = ActivityWidth / 2
+	}
+	if receiver != nil && !layout.Options.NoActivations {
+		offset2 = ActivityWidth / 2
+	}
+	var xText int
+	if order1 < order2 {
+		x1 += offset1
+		x2 -= offset2
+		x2 -= ArrowTipSize
+		xText = (order1 + 1) * SwimlaneWidth
+	} else {
+		x1 -= offset1
+		x2 += offset2
+		x2 += ArrowTipSize
+		xText = order1 * SwimlaneWidth
+	}
+	if sender == nil || receiver == nil {
+		xText = (x1 + x2) / 2
+	}
+
+	if message.TimedOut {
+		//the arrow ends three quarters of the way to the receiver
+		x2 = (x1 + 3*x2) / 4
+		y2 = (y1 + 3*y2) / 4
+	}
+
+	curved := layout.Options.CurvedArrows && !message.TimedOut && sender != nil && receiver != nil
+	g = arrowGeometry{
+		X1: x1, X2: x2, Y1: y1, Y2: y2,
+		Order1: order1, Order2: order2,
+		Offset1: offset1,
+		Loop:    curved && sender == receiver,
+		Arc:     curved && sender != receiver && (order2-order1 > 1 || order1-order2 > 1),
+	}
+
+	placement := layout.Options.LabelPlacement.override(message.Placement)
+	yText := y1
+	switch {
+	case placement.Anchor == "center":
+		xText = (x1 + x2) / 2
+	case placement.Anchor == "receiver" && order1 < order2:
+		xText = order2 * SwimlaneWidth
+	case placement.Anchor == "receiver":
+		xText = (order2 + 1) * SwimlaneWidth
+	}
+	if placement.Anchor != "sender" && x1 != x2 {
+		//follow the slope of asynchronous messages
+		yText += (y2 - y1) * (xText - x1) / (x2 - x1)
+	}
+	g.OnLine = placement.Vertical == "on-line"
+	if g.OnLine {
+		yText += MessageFontSize / 3
+	} else {
+		yText -= MessageBaselineOffset
+	}
+	g.XText, g.YText = xText, yText
+	return g
+}
+
+// displayLabel returns the label of the message as drawn, i.e. escaped and
+// with its number (see -autonumber).
+func (message *Message) displayLabel(opts *Options) string {
+	label := escapeText(message.Label, opts)
+	if message.Number > 0 {
+		label = fmt.Sprintf("%d. %s", message.Number, label)
+	}
+	return label
+}
+
+// labelLines splits a message label at each "\n" (written as a backslash and
+// the letter n in the input).
+func labelLines(label string) []string {
+	return strings.Split(label, `\n`)
+}
+
+// drawMessageLabel renders a message label with the given text-anchor and
+// additional <text> attributes. For labels with multiple lines, the last line
+// is on the given baseline, or if centered is set, the lines are centered
+// vertically around it.
+func drawMessageLabel(w io.Writer, x, y int, anchor, textOpts, label string, centered bool) {
+	attrs := fmt.Sprintf(`font-size="%d"%s%s`, MessageFontSize, bidiAttributes(label, anchor), textOpts)
+	lines := labelLines(label)
+	if len(lines) == 1 {
+		fmt.Fprintf(w, `<text x="%d" y="%d" %s>%s</text>`, x, y, attrs, richText(label))
+		return
+	}
+	y -= (len(lines) - 1) * MessageLineHeight
+	if centered {
+		y += (len(lines) - 1) * MessageLineHeight / 2
+	}
+	fmt.Fprintf(w, `<text x="%d" y="%d" %s>`, x, y, attrs)
+	for idx, line := range lines {
+		fmt.Fprintf(w, `<tspan x="%d" y="%d">%s</tspan>`, x, y+idx*MessageLineHeight, richText(line))
+	}
+	fmt.Fprint(w, `</text>`)
+}
+
+// richText converts a small subset of Markdown in labels and annotations into
+// styled tspans: **bold**, *italic* and `code`. Delimiters without a matching
+// closing delimiter are rendered literally. As in Markdown, emphasis must not
+// start with or end in a space, so that e.g. "a * b * c" stays unchanged.
+func richText(text string) string {
+	if !strings.ContainsAny(text, "*`") {
+		return text
+	}
+	var b strings.Builder
+	for text != "" {
+		idx := strings.IndexAny(text, "*`")
+		if idx < 0 {
+			b.WriteString(text)
+			break
+		}
+		b.WriteString(text[:idx])
+		text = text[idx:]
+
+		delimiter, attrs := "*", `font-style="italic"`
+		switch {
+		case text[0] == '`':
+			delimiter, attrs = "`", `font-family="monospace"`
+		case strings.HasPrefix(text, "**"):
+			delimiter, attrs = "**", `font-weight="bold"`
+		}
+		length := closingDelimiter(text[len(delimiter):], delimiter)
+		if length <= 0 {
+			b.WriteString(delimiter)
+			text = text[len(delimiter):]
+			continue
+		}
+		inner := text[len(delimiter) : len(delimiter)+length]
+		if delimiter != "`" {
+			inner = richText(inner) //code spans are literal, but e.g. bold text may contain code
+		}
+		fmt.Fprintf(&b, `<tspan %s>%s</tspan>`, attrs, inner)
+		text = text[2*len(delimiter)+length:]
+	}
+	return b.String()
+}
+
+// escapeText escapes the text for insertion into SVG markup, unless
+// -raw-labels allows labels to contain markup.
+func escapeText(text string, opts *Options) string {
+	if opts.RawLabels {
+		return text
+	}
+	return html.EscapeString(text)
+}
+
+// closingDelimiter returns the position of the delimiter that closes a span
+// starting at the beginning of text, or -1.
+func closingDelimiter(text, delimiter string) int {
+	if delimiter == "`" {
+		return strings.Index(text, delimiter)
+	}
+	if strings.HasPrefix(text, " ") {
+		return -1
+	}
+	for offset := 0; offset < len(text); {
+		idx := strings.Index(text[offset:], delimiter)
+		if idx < 0 {
+			return -1
+		}
+		idx += offset
+		if delimiter == "*" && strings.HasPrefix(text[idx:], "**") {
+			offset = idx + 2 //a single * cannot close inside a ** run
+			continue
+		}
+		if idx > 0 && text[idx-1] != ' ' {
+			return idx
+		}
+		offset = idx + len(delimiter)
+	}
+	return -1
+}
+
+// LabelPlacement describes where the label of a message is drawn relative to
+// its arrow. Empty fields mean that the default placement applies.
+type LabelPlacement struct {
+	Anchor   string //"sender" (the lane boundary next to the sender), "center" or "receiver"
+	Vertical string //"above" or "on-line"
+}
+
+// override returns this placement, with the fields that are set in the other
+// placement replaced.
+func (placement LabelPlacement) override(other LabelPlacement) LabelPlacement {
+	if other.Anchor != "" {
+		placement.Anchor = other.Anchor
+	}
+	if other.Vertical != "" {
+		placement.Vertical = other.Vertical
+	}
+	return placement
+}
+
+func (placement *LabelPlacement) String() string {
+	var parts []string
+	for _, part := range []string{placement.Anchor, placement.Vertical} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (placement *LabelPlacement) Set(value string) error {
+	for _, word := range strings.Split(value, ",") {
+		switch word = strings.TrimSpace(word); word {
+		case "sender", "center", "receiver":
+			placement.Anchor = word
+		case "above", "on-line":
+			placement.Vertical = word
+		default:
+			return fmt.Errorf("unknown label placement: %s (expected sender, center, receiver, above or on-line)", word)
+		}
+	}
+	return nil
+}
+
+// arrowHeadMarkers maps the arrowhead styles of -arrowheads to the markers
+// defined by writeSVGHeader.
+var arrowHeadMarkers = map[string]string{
+	"open":   "normal",
+	"filled": "filled",
+	"half":   "half",
+	"none":   "",
+}
+
+// arrowHeadsFlagValue contains the arrowhead style for each message kind.
+type arrowHeadsFlagValue map[string]string
+
+func (heads *arrowHeadsFlagValue) String() string {
+	var parts []string
+	for _, kind := range []string{"send", "call", "return"} {
+		if style, exists := (*heads)[kind]; exists {
+			parts = append(parts, kind+"="+style)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (heads *arrowHeadsFlagValue) Set(value string) error {
+	//the map is replaced instead of modified, since copies of the Options
+	//(e.g. per request in the "serve" subcommand) share it
+	result := make(arrowHeadsFlagValue, len(*heads))
+	for kind, style := range *heads {
+		result[kind] = style
+	}
+	for _, part := range strings.Split(value, ",") {
+		kind, style, ok := strings.Cut(strings.TrimSpace(part), "=")
+		switch {
+		case !ok:
+			return fmt.Errorf(`expected "kind=style", got %q`, part)
+		case kind != "send" && kind != "call" && kind != "return":
+			return fmt.Errorf("unknown message kind: %s (expected send, call or return)", kind)
+		}
+		if _, exists := arrowHeadMarkers[style]; !exists {
+			return fmt.Errorf("unknown arrowhead style: %s (expected open, filled, half or none)", style)
+		}
+		result[kind] = style
+	}
+	*heads = result
+	return nil
+}
+
+// endpoint returns the horizontal position of a message endpoint, and a
+// number that orders endpoints from left to right.
+func (layout *Layout) endpoint(actor *Actor, name string, layer uint) (x, order int) {
+	if layout.Options.NoActivations {
+		layer = 0
+	}
+	switch {
+	case actor != nil:
+		return int(actor.DisplayOrder*SwimlaneWidth + SwimlaneWidth/2 + layer*ActivityOffset), int(actor.DisplayOrder)
+	case name == LeftBorder:
+		return 0, -1
+	default:
+		return int(layout.Width), int(layout.Width / SwimlaneWidth)
+	}
+}
+
+// Snapshot returns a copy of the diagram as if the input ended here, except
+// that activities that are still running end at the current time, and messages
+// that have not been received yet are left out (instead of failing like
+// finish() does).
+func (p *Parser) Snapshot() *Diagram {
+	s := p.Clone()
+	diagram := s.Diagram
+	for _, pending := range s.Pending {
+		catchFailure(func() {
+			parseReceive([]string{pending.ReceiverName, pending.MessageName}, pending.Time, diagram.Actors, diagram.Messages)
+		})
+	}
+	for _, actor := range diagram.Actors {
+		for _, activity := range actor.Activities {
+			if activity.StopTime == 0 {
+				activity.StopTime = s.Time
+			}
+		}
+	}
+	for name, msg := range diagram.Messages {
+		if msg.ReceiverName == "" {
+			delete(diagram.Messages, name)
+		}
+	}
+	constraints := diagram.Constraints[:0]
+	for _, c := range diagram.Constraints {
+		if c.From.Message.ReceiverName != "" && c.To.Message.ReceiverName != "" {
+			constraints = append(constraints, c)
+		}
+	}
+	diagram.Constraints = constraints
+	orderings := diagram.Orderings[:0]
+	for _, o := range diagram.Orderings {
+		if o.Before.Message.ReceiverName != "" && o.After.Message.ReceiverName != "" {
+			orderings = append(orderings, o)
+		}
+	}
+	diagram.Orderings = orderings
+	legend := diagram.Legend[:0]
+	for _, entry := range diagram.Legend {
+		if _, exists := diagram.Actors[entry.ActorName]; exists {
+			legend = append(legend, entry)
+		}
+	}
+	diagram.Legend = legend
+	return diagram
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// utilities
+
+// InputError is returned by the exported functions for problems with the
+// diagram source or the options (as opposed to errors from reading the input
+// or writing the output).
+type InputError struct {
+	Message string
+}
+
+// Error implements the error interface.
+func (e *InputError) Error() string {
+	return e.Message
+}
+
+// failure is the panic value used by fail() and failIfErr(). Failures never
+// leave this package: the exported functions recover them with catchError(),
+// and internal callers that shall continue after an error (e.g. with the next
+// line of input) use catchFailure().
+type failure struct {
+	Err error
+}
+
+func fail(msg string, args ...interface{}) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	panic(failure{&InputError{msg}})
+}
+
+// warn reports a warning through the default logger.
+func warn(msg string, args ...interface{}) {
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	slog.Warn(msg)
+}
+
+// failIfErr fails if there is an error. It is meant for errors from reading
+// the input or writing the output, which are returned unchanged.
+func failIfErr(err error) {
+	if err != nil {
+		panic(failure{err})
+	}
+}
+
+// catchError recovers a failure from fail() or failIfErr(), and returns it
+// through *err. The exported functions defer this, so that library callers
+// get an error instead of a panic.
+func catchError(err *error) {
+	if r := recover(); r != nil {
+		f, ok := r.(failure)
+		if !ok {
+			panic(r)
+		}
+		*err = f.Err
+	}
+}
+
+// catchFailure runs the given function and returns the message of its failure
+// (or "" if it succeeded).
+func catchFailure(action func()) (msg string) {
+	var err error
+	func() {
+		defer catchError(&err)
+		action()
+	}()
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,180 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSVGEscapesUserText(t *testing.T) {
+	const payload = `<zq a="b">&`
+	testCases := []struct {
+		Element string
+		Input   string
+	}{
+		{"title", "newpage P\nstart a\nstop a\n"},
+		{"actor label", "participant a P\nstart a\nstop a\n"},
+		{"stereotype", "stereotype a P\nstart a\nstop a\n"},
+		{"activity label", "start a P\n\n\n\n\n\n\n\n\nstop a\n"},
+		{"message label", "start a\nstart b\nsend a m P\nreceive b m\nstop a\nstop b\n"},
+		{"timer label", "start a\ntimer set a t P\n\ntimer expire a t\nstop a\n"},
+		{"constraint label", "start a\nstart b\nsend a m x\n\nreceive b m\nconstraint m.send m.receive P\nstop a\nstop b\n"},
+		{"state", "start a\nstate a P\nstop a\n"},
+		{"annotation", "start a\nannotate P\nstop a\n"},
+		{"delay", "start a\ndelay 2 P\nstop a\n"},
+		{"skip", "start a\nskip P\nstop a\n"},
+		{"divider", "start a\ndivider P\nstop a\n"},
+		{"legend", "legend\na P\nend\nstart a\nstop a\n"},
+	}
+
+	for _, tc := range testCases {
+		input := strings.ReplaceAll(tc.Input, "P", payload)
+		for _, format := range []string{"svg", "communication"} {
+			for _, raw := range []bool{false, true} {
+				opts := DefaultOptions()
+				opts.Format = format
+				opts.RawLabels = raw
+				var buf bytes.Buffer
+				msg := catchFailure(func() {
+					for _, diagram := range parsePages(strings.NewReader(input)) {
+						for _, page := range renderPages(diagram, opts) {
+							page(&buf)
+						}
+					}
+				})
+				if msg != "" {
+					t.Fatalf("%s: %s", tc.Element, msg)
+				}
+				output := buf.String()
+				switch {
+				case format == "svg" && !strings.Contains(output, "zq"):
+					t.Errorf("%s does not appear in %s output", tc.Element, format)
+				case !raw && strings.Contains(output, "<zq"):
+					t.Errorf("%s in %s output is not escaped", tc.Element, format)
+				case raw && strings.Contains(output, "&lt;zq"):
+					t.Errorf("%s in %s output is escaped despite -raw-labels", tc.Element, format)
+				}
+			}
+		}
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	testCases := []struct {
+		Input     string
+		Escaped   string
+		RawLabels bool
+	}{
+		{"plain", "plain", false},
+		{"a < b && c > d", "a &lt; b &amp;&amp; c &gt; d", false},
+		{`say "hi" 'there'`, "say &#34;hi&#34; &#39;there&#39;", false},
+		{"<tspan>x</tspan>", "<tspan>x</tspan>", true},
+	}
+	for _, tc := range testCases {
+		opts := DefaultOptions()
+		opts.RawLabels = tc.RawLabels
+		if actual := escapeText(tc.Input, opts); actual != tc.Escaped {
+			t.Errorf("expected escapeText(%q) = %q, got %q", tc.Input, tc.Escaped, actual)
+		}
+	}
+}
+
+func TestParsePagesRecovering(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Input  string
+		Errors []string
+		Actors []string //of the last page
+		Events int      //number of received messages on all pages
+	}{
+		{
+			Name:   "valid input",
+			Input:  "start a\nstart b\nsend a m1 x\nreceive b m1\nstop a\nstop b\n",
+			Actors: []string{"a", "b"},
+			Events: 1,
+		},
+		{
+			Name:   "parsing continues after errors",
+			Input:  "start a\nfrobnicate\nstart b\nsend a m1 x\nreceive c m1\nreceive b m1\nstop a\nstop b\n",
+			Errors: []string{"line 2: unknown command: frobnicate", "line 5: actor c cannot receive message m1 while not active"},
+			Actors: []string{"a", "b", "c"},
+			Events: 1,
+		},
+		{
+			Name:   "line numbers continue across pages",
+			Input:  "start a\nstop a\nnewpage two\nstart b\nbogus\n\nstop b\n",
+			Errors: []string{"line 5: unknown command: bogus"},
+			Actors: []string{"b"},
+		},
+		{
+			Name:   "errors at the end are reported on the last non-empty line",
+			Input:  "start a\nstart b\nsend a m1 x\n\n\n",
+			Errors: []string{"line 3: actor a has 1 unfinished activities"},
+			Actors: []string{"a", "b"},
+		},
+		{
+			Name:   "blank lines and comments count as lines",
+			Input:  "# comment\n\nstart a\nstop b\nstop a\n",
+			Errors: []string{"line 4: cannot stop actor b: not active"},
+			Actors: []string{"a", "b"},
+		},
+	}
+
+	for _, tc := range testCases {
+		var diagrams []*Diagram
+		var errs []ParseError
+		msg := catchFailure(func() {
+			diagrams, errs = parsePagesRecovering(strings.NewReader(tc.Input), nil)
+		})
+		if msg != "" {
+			t.Errorf("%s: unexpected failure: %s", tc.Name, msg)
+			continue
+		}
+		var actualErrors []string
+		for _, e := range errs {
+			actualErrors = append(actualErrors, e.String())
+		}
+		if strings.Join(actualErrors, "\n") != strings.Join(tc.Errors, "\n") {
+			t.Errorf("%s: expected errors %q, got %q", tc.Name, tc.Errors, actualErrors)
+		}
+
+		var actors []string
+		for name := range diagrams[len(diagrams)-1].Actors {
+			actors = append(actors, name)
+		}
+		sort.Strings(actors)
+		if strings.Join(actors, ",") != strings.Join(tc.Actors, ",") {
+			t.Errorf("%s: expected actors %v, got %v", tc.Name, tc.Actors, actors)
+		}
+		events := 0
+		for _, diagram := range diagrams {
+			for _, msg := range diagram.Messages {
+				if msg.ReceiverTime > 0 {
+					events++
+				}
+			}
+		}
+		if events != tc.Events {
+			t.Errorf("%s: expected %d received messages, got %d", tc.Name, tc.Events, events)
+		}
+	}
+}
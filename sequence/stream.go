@@ -0,0 +1,239 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// streamRenderer draws and removes the finished elements of a diagram while
+// it is being parsed, so that memory usage does not grow with the number of
+// messages. If W is nil, finished elements are only removed (and measured).
+type streamRenderer struct {
+	W          io.Writer
+	Layout     *Layout
+	Width      int
+	LeftMargin int
+	MaxTime    uint //largest point in time of all finished elements
+	HasNotes   bool //whether any annotations were found
+}
+
+func (s *streamRenderer) observe(t uint) {
+	if s.MaxTime < t {
+		s.MaxTime = t
+	}
+}
+
+// drain is the flush callback for parseWith.
+func (s *streamRenderer) drain(diagram *Diagram, time uint) {
+	for _, actor := range sortedActors(diagram.Actors) {
+		running := actor.Activities[:0]
+		for _, activity := range actor.Activities {
+			if activity.StopTime == 0 {
+				running = append(running, activity)
+				continue
+			}
+			s.observe(activity.StopTime)
+			if s.W != nil && !s.Layout.Options.NoActivations {
+				activity.drawBox(s.W, actor.DisplayOrder, s.Layout)
+			}
+		}
+		actor.Activities = running
+	}
+
+	//messages can only be removed once all constraints and orderings that refer to them are drawn
+	var constraints []*Constraint
+	for _, constraint := range diagram.Constraints {
+		if constraint.To.Receive && constraint.To.Message.ReceiverName == "" {
+			constraints = append(constraints, constraint)
+			continue
+		}
+		if s.W != nil {
+			constraint.drawMeasure(s.W, diagram.Actors[constraint.From.ActorName()], s.Layout)
+		}
+	}
+	diagram.Constraints = constraints
+	var orderings []*GeneralOrdering
+	for _, ordering := range diagram.Orderings {
+		if !ordering.Before.hasHappened() || !ordering.After.hasHappened() {
+			orderings = append(orderings, ordering)
+			continue
+		}
+		if s.W != nil {
+			ordering.draw(s.W, diagram.Actors, s.Layout)
+		}
+	}
+	diagram.Orderings = orderings
+	isReferenced := make(map[*Message]bool)
+	for _, constraint := range constraints {
+		isReferenced[constraint.From.Message] = true
+		isReferenced[constraint.To.Message] = true
+	}
+	for _, ordering := range orderings {
+		isReferenced[ordering.Before.Message] = true
+		isReferenced[ordering.After.Message] = true
+	}
+	for _, name := range sortedMessageNames(diagram.Messages) {
+		msg := diagram.Messages[name]
+		if msg.ReceiverName == "" || isReferenced[msg] {
+			continue
+		}
+		if s.W != nil && msg.isDrawn(s.Layout.Options) {
+			msg.drawArrow(s.W, diagram.Actors[msg.SenderName], diagram.Actors[msg.ReceiverName], s.Layout)
+		}
+		delete(diagram.Messages, name)
+	}
+
+	var timers []*Timer
+	for _, timer := range diagram.Timers {
+		if timer.StopTime == 0 {
+			timers = append(timers, timer)
+			continue
+		}
+		s.observe(timer.StopTime)
+		if s.W != nil {
+			timer.draw(s.W, diagram.Actors[timer.ActorName], s.Layout)
+		}
+	}
+	diagram.Timers = timers
+	for _, state := range diagram.States {
+		s.observe(state.Time)
+		if s.W != nil {
+			state.draw(s.W, diagram.Actors[state.ActorName], s.Layout)
+		}
+	}
+	diagram.States = nil
+	var coregions []*Coregion
+	for _, coregion := range diagram.Coregions {
+		if coregion.StopTime == 0 {
+			coregions = append(coregions, coregion)
+			continue
+		}
+		s.observe(coregion.StopTime)
+		if s.W != nil {
+			coregion.draw(s.W, diagram.Actors[coregion.ActorName], s.Layout)
+		}
+	}
+	diagram.Coregions = coregions
+
+	for _, gap := range diagram.Gaps {
+		s.observe(gap.StopTime)
+		if s.W != nil {
+			gap.draw(s.W, s.Width, s.Layout)
+		}
+	}
+	diagram.Gaps = nil
+	for _, divider := range diagram.Dividers {
+		s.observe(divider.Time)
+		if s.W != nil {
+			divider.draw(s.W, s.Width, s.Layout)
+		}
+	}
+	diagram.Dividers = nil
+	//annotations are drawn in the left margin, outside of the translated group (see renderStream)
+	for _, annotation := range diagram.Annotations {
+		s.HasNotes = true
+		if s.W != nil {
+			fmt.Fprintf(s.W, `<g transform="translate(%d,0)">`, -s.LeftMargin)
+			annotation.draw(s.W, s.LeftMargin, s.Layout)
+			fmt.Fprint(s.W, `</g>`)
+		}
+	}
+	diagram.Annotations = nil
+}
+
+// renderStream renders the diagram in the input as SVG in two passes over the
+// input (each pass calls open once), such that memory usage is bounded by the
+// number of actors and points in time instead of the number of messages. The
+// first pass measures the diagram, the second pass writes each element as
+// soon as it is finished.
+//
+// Streaming only supports the plain SVG output of a single diagram. Within
+// the input, constraints and orderings may only refer to messages that have
+// not been received yet, because received messages are discarded.
+func renderStream(w io.Writer, opts *Options, open func() (io.ReadCloser, error)) {
+	if opts.Format != "svg" || opts.Proportional || opts.Autonumber || opts.MaxHeight > 0 || opts.MaxWidth > 0 || opts.PageSize.Name != "" || opts.Scale != 1 {
+		fail("stream: only plain SVG output is supported (without -proportional, -autonumber, -max-height, -max-width, -page-size or -scale)")
+	}
+
+	//first pass: collect actors, spacings and timestamps, and measure the length of the diagram
+	first := &streamRenderer{}
+	skeleton := parseStream(open, first.drain)
+	maxTime := first.MaxTime
+
+	layout := computeLayout(skeleton, maxTime, opts)
+	width := len(skeleton.Actors) * SwimlaneWidth
+	leftMargin := 0
+	if opts.Ruler {
+		leftMargin += RulerWidth
+	}
+	if first.HasNotes {
+		leftMargin += AnnotationWidth
+	}
+	var legend [][2]string
+	for _, entry := range skeleton.Legend {
+		legend = append(legend, [2]string{escapeText(skeleton.Actors[entry.ActorName].Label, opts), escapeText(entry.Description, opts)})
+	}
+	height := layout.bottom(maxTime) + tableHeight(legend)
+
+	//second pass: render
+	out := bufio.NewWriter(w)
+	writeSVGHeader(out, uint(width+leftMargin), height)
+	writeThemeStyle(out, opts.Theme)
+	if opts.Ruler {
+		skeleton.drawRuler(out, maxTime, layout)
+	}
+	if leftMargin > 0 {
+		fmt.Fprintf(out, `<g transform="translate(%d,0)">`, leftMargin)
+	}
+	if opts.Gridlines {
+		drawGridlines(out, maxTime, width, layout)
+	}
+	for _, actor := range sortedActors(skeleton.Actors) {
+		actor.drawSwimLane(out, maxTime, layout)
+	}
+	second := &streamRenderer{W: out, Layout: layout, Width: width, LeftMargin: leftMargin}
+	parseStream(open, second.drain)
+	drawTable(out, legend, layout.bottom(maxTime), width)
+	if leftMargin > 0 {
+		fmt.Fprint(out, `</g>`)
+	}
+	fmt.Fprintln(out, `</svg>`)
+	failIfErr(out.Flush())
+}
+
+// RenderStream renders the diagram in the input as SVG, and writes it into w
+// while the input is being parsed, so that memory usage is bounded by the
+// number of actors and points in time instead of the number of messages. The
+// input is read twice: open is called once for each pass. Only the plain SVG
+// output of a single diagram is supported (see renderStream).
+func RenderStream(w io.Writer, opts *Options, open func() (io.ReadCloser, error)) (err error) {
+	defer catchError(&err)
+	renderStream(w, opts, open)
+	return nil
+}
+
+func parseStream(open func() (io.ReadCloser, error), flush func(*Diagram, uint)) *Diagram {
+	input, err := open()
+	failIfErr(err)
+	defer input.Close()
+	return parseWith(DecodeInput(input), flush)
+}
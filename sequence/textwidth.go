@@ -0,0 +1,178 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"strings"
+	"unicode"
+)
+
+// This file contains the text measurement used when labels need to fit into a
+// given space (e.g. activity labels), or when text is aligned in columns (e.g.
+// by the "fmt" subcommand). Since we do not have font metrics, widths are
+// measured in multiples of the width of a narrow character.
+
+// wideRanges contains the code points that are rendered twice as wide as Latin
+// letters: the East Asian Wide and Fullwidth characters (CJK ideographs, kana,
+// Hangul etc.) and emoji.
+var wideRanges = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x1100, Hi: 0x115F, Stride: 1}, //Hangul Jamo initial consonants
+		{Lo: 0x231A, Hi: 0x231B, Stride: 1}, //watch, hourglass
+		{Lo: 0x23E9, Hi: 0x23EC, Stride: 1},
+		{Lo: 0x23F0, Hi: 0x23F3, Stride: 1},
+		{Lo: 0x25FD, Hi: 0x25FE, Stride: 1},
+		{Lo: 0x2614, Hi: 0x2615, Stride: 1},
+		{Lo: 0x26A1, Hi: 0x26A1, Stride: 1},
+		{Lo: 0x26AA, Hi: 0x26AB, Stride: 1},
+		{Lo: 0x26BD, Hi: 0x26BE, Stride: 1},
+		{Lo: 0x26C4, Hi: 0x26C5, Stride: 1},
+		{Lo: 0x26D4, Hi: 0x26D4, Stride: 1},
+		{Lo: 0x26EA, Hi: 0x26EA, Stride: 1},
+		{Lo: 0x26F2, Hi: 0x26F5, Stride: 1},
+		{Lo: 0x26FA, Hi: 0x26FD, Stride: 1},
+		{Lo: 0x2705, Hi: 0x2705, Stride: 1},
+		{Lo: 0x270A, Hi: 0x270B, Stride: 1},
+		{Lo: 0x2728, Hi: 0x2728, Stride: 1},
+		{Lo: 0x274C, Hi: 0x274C, Stride: 1},
+		{Lo: 0x2753, Hi: 0x2755, Stride: 1},
+		{Lo: 0x2757, Hi: 0x2757, Stride: 1},
+		{Lo: 0x2795, Hi: 0x2797, Stride: 1},
+		{Lo: 0x2B1B, Hi: 0x2B1C, Stride: 1},
+		{Lo: 0x2B50, Hi: 0x2B50, Stride: 1},
+		{Lo: 0x2E80, Hi: 0x303E, Stride: 1}, //CJK radicals, punctuation
+		{Lo: 0x3041, Hi: 0x33FF, Stride: 1}, //kana, CJK compatibility
+		{Lo: 0x3400, Hi: 0x4DBF, Stride: 1}, //CJK extension A
+		{Lo: 0x4E00, Hi: 0x9FFF, Stride: 1}, //CJK unified ideographs
+		{Lo: 0xA000, Hi: 0xA4CF, Stride: 1}, //Yi
+		{Lo: 0xA960, Hi: 0xA97F, Stride: 1}, //Hangul Jamo extended A
+		{Lo: 0xAC00, Hi: 0xD7A3, Stride: 1}, //Hangul syllables
+		{Lo: 0xF900, Hi: 0xFAFF, Stride: 1}, //CJK compatibility ideographs
+		{Lo: 0xFE10, Hi: 0xFE19, Stride: 1}, //vertical forms
+		{Lo: 0xFE30, Hi: 0xFE6F, Stride: 1}, //CJK compatibility forms, small forms
+		{Lo: 0xFF00, Hi: 0xFF60, Stride: 1}, //fullwidth forms
+		{Lo: 0xFFE0, Hi: 0xFFE6, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x16FE0, Hi: 0x18CFF, Stride: 1}, //Tangut
+		{Lo: 0x1B000, Hi: 0x1B2FF, Stride: 1}, //kana supplement
+		{Lo: 0x1F004, Hi: 0x1F004, Stride: 1},
+		{Lo: 0x1F0CF, Hi: 0x1F0CF, Stride: 1},
+		{Lo: 0x1F18E, Hi: 0x1F18E, Stride: 1},
+		{Lo: 0x1F191, Hi: 0x1F19A, Stride: 1},
+		{Lo: 0x1F200, Hi: 0x1F64F, Stride: 1}, //enclosed ideographs, emoji
+		{Lo: 0x1F680, Hi: 0x1F6FF, Stride: 1}, //transport and map symbols
+		{Lo: 0x1F7E0, Hi: 0x1F7EB, Stride: 1},
+		{Lo: 0x1F900, Hi: 0x1FAFF, Stride: 1}, //supplemental symbols and pictographs
+		{Lo: 0x20000, Hi: 0x3FFFD, Stride: 1}, //CJK extensions B and later
+	},
+}
+
+// zeroWidthRanges contains the code points that do not take up space of their
+// own: combining marks, and format characters like zero-width joiners,
+// variation selectors and bidi controls.
+var zeroWidthRanges = []*unicode.RangeTable{unicode.Mn, unicode.Me, unicode.Cf, unicode.Variation_Selector}
+
+// runeWidth returns the width of the code point (0, 1 or 2).
+func runeWidth(r rune) int {
+	switch {
+	case unicode.In(r, zeroWidthRanges...):
+		return 0
+	case unicode.Is(wideRanges, r):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// TextWidth returns the width of the text in multiples of the width of a
+// narrow character.
+func TextWidth(text string) int {
+	width := 0
+	for _, r := range text {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// truncateText shortens the text to the given width by replacing its end with
+// "…", and returns whether it had to be shortened. It never cuts between a
+// character and its combining marks.
+func truncateText(text string, maxWidth int) (string, bool) {
+	if TextWidth(text) <= maxWidth {
+		return text, false
+	}
+	var result strings.Builder
+	width := 0
+	for _, r := range text {
+		w := runeWidth(r)
+		if w > 0 && width+w > maxWidth-1 { //leave room for "…"
+			break
+		}
+		result.WriteRune(r)
+		width += w
+	}
+	return result.String() + "…", true
+}
+
+// rightToLeftScripts contains the scripts whose letters are strongly
+// right-to-left in the Unicode bidirectional algorithm.
+var rightToLeftScripts = []*unicode.RangeTable{
+	unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko,
+	unicode.Samaritan, unicode.Mandaic, unicode.Adlam, unicode.Hanifi_Rohingya,
+}
+
+// isRightToLeft returns whether the text forms a right-to-left paragraph, i.e.
+// whether its first strongly directional character is right-to-left (rules P2
+// and P3 of the Unicode bidirectional algorithm). Renderers order characters
+// within the text correctly on their own, but only we know which direction the
+// text as a whole shall have, which determines e.g. on which side trailing
+// punctuation ends up, and which way the text extends from its anchor.
+func isRightToLeft(text string) bool {
+	for _, r := range text {
+		switch {
+		case unicode.In(r, rightToLeftScripts...):
+			return true
+		case unicode.IsLetter(r):
+			return false
+		}
+	}
+	return false
+}
+
+// bidiAttributes returns the SVG attributes for a <text> element containing
+// the given text, whose text-anchor shall be the given one ("start", "middle"
+// or "end" as for left-to-right text). For right-to-left text, the anchor is
+// mirrored, so that the text occupies the same space as left-to-right text
+// would.
+func bidiAttributes(text, anchor string) string {
+	if !isRightToLeft(text) {
+		if anchor == "start" {
+			return "" //default
+		}
+		return ` text-anchor="` + anchor + `"`
+	}
+	switch anchor {
+	case "start":
+		anchor = "end"
+	case "end":
+		anchor = "start"
+	}
+	return ` direction="rtl" text-anchor="` + anchor + `"`
+}
@@ -0,0 +1,166 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Theme contains the colors, fonts and spacing of the rendered diagram.
+type Theme struct {
+	Foreground string `json:"foreground"`  //color of lines, arrows and text
+	BoxFill    string `json:"box-fill"`    //color of actor heads, activity boxes, notes etc.
+	FontFamily string `json:"font-family"` //empty = the SVG viewer's default font
+	Spacing    uint   `json:"spacing"`     //vertical distance per unit of time (in px), until the first "spacing" command
+}
+
+// defaultTheme is the theme that applies without -theme-file.
+var defaultTheme = Theme{
+	Foreground: "black",
+	BoxFill:    "white",
+	Spacing:    SwimlaneStep,
+}
+
+// themeFile is the content of a file given with -theme-file. Besides the
+// theme itself, it contains the options that choose markers and label
+// positions, so that all aspects of a house style can be kept in one file.
+type themeFile struct {
+	Theme
+	ArrowHeads     map[string]string `json:"arrowheads"`
+	LabelPlacement string            `json:"label-placement"`
+}
+
+// themeFileFlagValue is the value of -theme-file. Loading the file changes
+// the options immediately, so flags given after -theme-file can still
+// override parts of the theme.
+type themeFileFlagValue struct {
+	Options *Options
+	Path    string
+}
+
+func (value *themeFileFlagValue) String() string {
+	return value.Path
+}
+
+func (value *themeFileFlagValue) Set(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := value.Options.loadTheme(buf); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	value.Path = path
+	return nil
+}
+
+// loadTheme applies the theme file with the given content to the options.
+// Fields that are missing from the file keep their current value.
+func (opts *Options) loadTheme(buf []byte) error {
+	file := themeFile{Theme: opts.Theme}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return err
+	}
+	if err := file.Theme.validate(); err != nil {
+		return err
+	}
+
+	//the other options are validated by their flag values
+	heads := opts.ArrowHeads
+	for kind, style := range file.ArrowHeads {
+		if err := heads.Set(kind + "=" + style); err != nil {
+			return err
+		}
+	}
+	placement := opts.LabelPlacement
+	if file.LabelPlacement != "" {
+		if err := placement.Set(file.LabelPlacement); err != nil {
+			return err
+		}
+	}
+
+	opts.Theme = file.Theme
+	opts.ArrowHeads = heads
+	opts.LabelPlacement = placement
+	return nil
+}
+
+func (theme Theme) validate() error {
+	for name, value := range map[string]string{"foreground": theme.Foreground, "box-fill": theme.BoxFill, "font-family": theme.FontFamily} {
+		//the values are inserted into a CSS stylesheet within the SVG
+		if strings.ContainsAny(value, "<>&;{}\"\\\n") {
+			return fmt.Errorf("invalid %s: %q", name, value)
+		}
+	}
+	if theme.Foreground == "" || theme.BoxFill == "" {
+		return fmt.Errorf("foreground and box-fill must not be empty")
+	}
+	if theme.Spacing == 0 {
+		return fmt.Errorf("spacing must be a positive number")
+	}
+	return nil
+}
+
+// ExportTheme writes the theme file corresponding to the given options (the
+// built-in defaults, unless -theme-file or -arrowheads etc. were given), to
+// be used as a starting point for a custom theme.
+func ExportTheme(w io.Writer, opts *Options) error {
+	file := themeFile{
+		Theme:          opts.Theme,
+		ArrowHeads:     opts.ArrowHeads,
+		LabelPlacement: opts.LabelPlacement.String(),
+	}
+	buf, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(buf))
+	return err
+}
+
+// writeThemeStyle writes a stylesheet that applies the colors and fonts of
+// the theme to the SVG elements, which are drawn with the default colors. CSS
+// properties take precedence over the presentation attributes of the
+// elements, so the elements themselves do not need to know about the theme.
+// Nothing is written for the default theme.
+func writeThemeStyle(w io.Writer, theme Theme) {
+	var rules []string
+	if theme.Foreground != defaultTheme.Foreground {
+		rules = append(rules,
+			fmt.Sprintf(`[stroke="black"] { stroke: %s; }`, theme.Foreground),
+			fmt.Sprintf(`[fill="black"], text:not([fill]) { fill: %s; }`, theme.Foreground),
+		)
+	}
+	if theme.BoxFill != defaultTheme.BoxFill {
+		rules = append(rules, fmt.Sprintf(`[fill="white"] { fill: %s; }`, theme.BoxFill))
+	}
+	if theme.FontFamily != "" {
+		rules = append(rules, fmt.Sprintf(`svg { font-family: %s; }`, theme.FontFamily))
+	}
+	if len(rules) > 0 {
+		fmt.Fprintf(w, `<style>%s</style>`, strings.Join(rules, " "))
+	}
+}
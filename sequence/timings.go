@@ -0,0 +1,93 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package sequence
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Timings collects the durations of the processing phases and the element
+// counts for -timings. It can be shared by concurrent renderings. A nil
+// *Timings does not measure anything.
+type Timings struct {
+	mutex     sync.Mutex
+	Phases    []string //in order of first occurrence
+	Durations map[string]time.Duration
+	Diagrams  int
+	Actors    int
+	Messages  int
+	Ticks     uint
+}
+
+// NewTimings returns an empty Timings instance.
+func NewTimings() *Timings {
+	return &Timings{Durations: make(map[string]time.Duration)}
+}
+
+// Measure starts measuring the duration of a phase, and returns a function
+// that ends the measurement. The durations of all measurements of the same
+// phase are added up. Usage:
+//
+//	defer opts.Timings.Measure("layout")()
+func (stats *Timings) Measure(phase string) func() {
+	if stats == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		duration := time.Since(start)
+		stats.mutex.Lock()
+		defer stats.mutex.Unlock()
+		if _, exists := stats.Durations[phase]; !exists {
+			stats.Phases = append(stats.Phases, phase)
+		}
+		stats.Durations[phase] += duration
+	}
+}
+
+// count records the element counts of the rendered diagrams.
+func (stats *Timings) count(diagrams []*Diagram) {
+	if stats == nil {
+		return
+	}
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	for _, diagram := range diagrams {
+		stats.Diagrams++
+		stats.Actors += len(diagram.Actors)
+		stats.Messages += len(diagram.Messages)
+		stats.Ticks += getMaxTime(diagram)
+	}
+}
+
+// Print writes the collected durations and counts in a human-readable form.
+func (stats *Timings) Print(w io.Writer) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	fmt.Fprintln(w, "timings (layout is part of render):")
+	for _, phase := range stats.Phases {
+		fmt.Fprintf(w, "  %-10s %s\n", phase, stats.Durations[phase])
+	}
+	fmt.Fprintf(w, "counts: %d diagrams, %d actors, %d messages, %d ticks\n",
+		stats.Diagrams, stats.Actors, stats.Messages, stats.Ticks,
+	)
+}
@@ -0,0 +1,491 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+var (
+	listenFlag     = flag.String("listen", ":8080", "address on which the \"serve\" subcommand listens")
+	cacheSizeFlag  = flag.Int64("cache-size", 64<<20, "maximum total size (in bytes) of rendered outputs cached by the \"serve\" subcommand")
+	cacheTTLFlag   = flag.Duration("cache-ttl", 10*time.Minute, "how long the \"serve\" subcommand caches rendered outputs")
+	httpMaxAgeFlag = flag.Duration("http-max-age", 0, "serve: max-age in the Cache-Control header of rendered outputs (0 = clients and proxies must revalidate with the ETag)")
+
+	maxInputSizeFlag  = flag.Int64("max-input-size", 1<<20, "serve: maximum size (in bytes) of a diagram")
+	maxActorsFlag     = flag.Int("max-actors", 200, "serve: maximum number of actors per diagram (0 = unlimited)")
+	maxMessagesFlag   = flag.Int("max-messages", 20000, "serve: maximum number of messages per diagram (0 = unlimited)")
+	maxTicksFlag      = flag.Uint("max-ticks", 100000, "serve: maximum number of ticks per diagram (0 = unlimited)")
+	maxLineLengthFlag = flag.Int("max-line-length", 10000, "serve: maximum length (in bytes) of each line of a diagram, and thus of labels (0 = unlimited)")
+	maxNestingFlag    = flag.Uint("max-nesting", 100, "serve: maximum number of nested activities per actor (0 = unlimited)")
+	renderTimeoutFlag = flag.Duration("render-timeout", 10*time.Second, "serve: maximum time for parsing and rendering a diagram")
+	maxRendersFlag    = flag.Int("max-renders", runtime.NumCPU(), "serve: maximum number of concurrent renders")
+
+	authTokensFileFlag    = flag.String("auth-tokens-file", "", "serve: file with one API token per line; if given, requests must send one of them as \"Authorization: Bearer <token>\" or \"X-API-Key: <token>\"")
+	storeDirFlag          = flag.String("store-dir", "", "serve: directory in which diagrams are stored with \"PUT /d/<id>\", and rendered with \"GET /d/<id>.svg\" (default: storage is disabled)")
+	repoURLFlag           = flag.String("repo-url", "", "serve: git repository whose diagram files are rendered into a website below /site/, rebuilt on POST /hooks/repo (default: disabled)")
+	repoBranchFlag        = flag.String("repo-branch", "", "serve: with -repo-url: branch to render (default: the remote's default branch)")
+	repoDirFlag           = flag.String("repo-dir", "", "serve: with -repo-url: directory for the checkout and the rendered website")
+	repoPollIntervalFlag  = flag.Duration("repo-poll-interval", 0, "serve: with -repo-url: also rebuild at this interval (0 = only on webhooks)")
+	webhookSecretFileFlag = flag.String("webhook-secret-file", "", "serve: with -repo-url: file with the secret that webhooks must use to sign their requests")
+	allowNetworksFlag     = flag.String("allow-networks", "", "serve: comma-separated list of addresses or networks (e.g. \"10.0.0.0/8,192.168.1.5\") from which requests are accepted (default: all)")
+)
+
+// serveLimits returns the limits given by the -max-... flags.
+func serveLimits() *sequence.Limits {
+	return &sequence.Limits{
+		Actors:     *maxActorsFlag,
+		Messages:   *maxMessagesFlag,
+		Ticks:      *maxTicksFlag,
+		LineLength: *maxLineLengthFlag,
+		Nesting:    *maxNestingFlag,
+		Spacing:    sequence.MaxServeSpacing,
+	}
+}
+
+// contentTypes contains the HTTP Content-Type for each output format.
+var contentTypes = map[string]string{
+	"svg":           "image/svg+xml",
+	"gantt":         "text/plain; charset=utf-8",
+	"communication": "image/svg+xml",
+	"dot":           "text/vnd.graphviz; charset=utf-8",
+	"csv":           "text/csv; charset=utf-8",
+	"events":        "text/tab-separated-values; charset=utf-8",
+	"html":          "text/html; charset=utf-8",
+}
+
+// serve runs an HTTP server with the following endpoints (plus those of
+// -store-dir and -repo-url):
+//
+//	GET  /                           (the playground, see handlePlayground)
+//	GET  /render?format=<format>&source=<diagram>
+//	POST /render?format=<format>     (with the diagram as request body)
+//
+// Without the format parameter, the format is chosen by the Accept request
+// header (see negotiateFormat), and defaults to -format. All other options
+// are taken from the command line. Multiple pages are separated by
+// PageDelimiter, like on stdout. Rendered outputs are cached (see
+// renderCache), and the X-Cache response header tells whether the response
+// came from the cache.
+//
+// Since the output only depends on the input, the format and the options,
+// responses carry a strong ETag derived from these, and conditional requests
+// with a matching If-None-Match header are answered with 304 Not Modified
+// without rendering anything. Caching by browsers and proxies is controlled
+// with -http-max-age.
+//
+// Since the server may be shared by many clients, each request is subject to
+// the limits given by the -max-... and -render-timeout flags. Access can be
+// restricted with -auth-tokens-file and -allow-networks (see serverAuth).
+func serve(opts *sequence.Options) {
+	if *maxRendersFlag < 1 {
+		fail("-max-renders must be at least 1")
+	}
+	if opts.RawLabels {
+		warn("-raw-labels is set: clients can insert arbitrary markup into the rendered diagrams")
+	}
+	s := &renderServer{
+		Options:     opts,
+		Auth:        loadServerAuth(),
+		Cache:       newRenderCache(*cacheSizeFlag, *cacheTTLFlag),
+		RenderSlots: make(chan struct{}, *maxRendersFlag),
+		//all options that affect the output, for the ETags
+		OptionsFingerprint: fmt.Sprintf("%+v", *opts),
+		CacheControl:       "public, no-cache",
+	}
+	if *httpMaxAgeFlag > 0 {
+		s.CacheControl = fmt.Sprintf("public, max-age=%d", int64(httpMaxAgeFlag.Seconds()))
+	}
+
+	s.handle("/render", s.Auth.check, s.handleRender)
+	s.handle("/", s.Auth.checkNetwork, s.handlePlayground)
+	if *storeDirFlag != "" {
+		failIfErr(os.MkdirAll(*storeDirFlag, 0777))
+		s.Store = &diagramStore{Dir: *storeDirFlag}
+		s.handle("/d/", s.Auth.check, s.handleStore)
+	}
+	if *repoURLFlag != "" {
+		s.Publisher = newRepoPublisher(opts)
+		s.handle("/hooks/repo", s.checkWebhook, s.handleWebhook)
+		s.handle("/site/", s.Auth.check, http.StripPrefix("/site/", http.FileServer(http.Dir(s.Publisher.SiteDir()))).ServeHTTP)
+		go s.Publisher.run()
+	}
+
+	slog.Info("listening", "address", *listenFlag)
+	failIfErr(http.ListenAndServe(*listenFlag, nil))
+}
+
+// renderServer contains the state of the "serve" subcommand that is shared
+// by all requests.
+type renderServer struct {
+	Options            *sequence.Options
+	Auth               *serverAuth
+	Cache              *renderCache
+	RenderSlots        chan struct{} //one element per running render (see -max-renders)
+	OptionsFingerprint string
+	CacheControl       string
+	Store              *diagramStore  //nil unless -store-dir is given
+	Publisher          *repoPublisher //nil unless -repo-url is given
+}
+
+// statusRecorder remembers the status code of a response, for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.Status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// handle registers a handler for the given path (or subtree, if the pattern
+// ends in "/"). Requests must pass the check (usually serverAuth.check)
+// before they reach the handler, and one line per request is logged with the
+// outcome.
+func (s *renderServer) handle(pattern string, check func(*http.Request) (int, string), handler http.HandlerFunc) {
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, Status: http.StatusOK}
+		defer func() {
+			slog.Info("request", "method", r.Method, "path", r.URL.Path, "status", rec.Status, "cache", w.Header().Get("X-Cache"), "duration", time.Since(start))
+		}()
+
+		if code, msg := check(r); code != 0 {
+			if code == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="render"`)
+			}
+			http.Error(rec, msg, code)
+			return
+		}
+		handler(rec, r)
+	})
+}
+
+// readInput reads the request body, subject to -max-input-size. On error, it
+// writes an error response and returns false.
+func readInput(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	input, err := io.ReadAll(http.MaxBytesReader(w, r.Body, *maxInputSizeFlag))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return input, true
+}
+
+func (s *renderServer) handleRender(w http.ResponseWriter, r *http.Request) {
+	var input []byte
+	switch r.Method {
+	case "GET":
+		input = []byte(r.URL.Query().Get("source"))
+	case "POST":
+		var ok bool
+		input, ok = readInput(w, r)
+		if !ok {
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if int64(len(input)) > *maxInputSizeFlag {
+		http.Error(w, "input too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		//the response depends on the Accept header only if no format is given
+		w.Header().Set("Vary", "Accept")
+		var ok bool
+		format, ok = negotiateFormat(r.Header.Get("Accept"), s.Options.Format)
+		if !ok {
+			http.Error(w, "none of the accepted media types can be rendered (available: "+strings.Join(mediaTypes(), ", ")+")", http.StatusNotAcceptable)
+			return
+		}
+	}
+	s.respondRendered(w, r, input, format)
+}
+
+// respondRendered writes the input, rendered in the given format, into the
+// response. Outputs are cached, and conditional GETs are answered with the
+// ETag (see serve).
+func (s *renderServer) respondRendered(w http.ResponseWriter, r *http.Request, input []byte, format string) {
+	contentType, exists := contentTypes[format]
+	if !exists {
+		http.Error(w, "unknown output format: "+format, http.StatusBadRequest)
+		return
+	}
+
+	key := cacheKey(input, format)
+	etag := strongETag(key, s.OptionsFingerprint)
+	if r.Method == "GET" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", s.CacheControl)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	output, hit := s.Cache.get(key)
+	if hit {
+		w.Header().Set("X-Cache", "hit")
+	} else {
+		timeout := time.NewTimer(*renderTimeoutFlag)
+		defer timeout.Stop()
+
+		//the slot is released by the render itself, so renders that ran into
+		//the timeout still count against -max-renders until they finish
+		select {
+		case s.RenderSlots <- struct{}{}:
+		case <-timeout.C:
+			http.Error(w, "too many concurrent renders", http.StatusServiceUnavailable)
+			return
+		}
+		result := make(chan renderResult, 1)
+		go func() {
+			defer func() { <-s.RenderSlots }()
+			renderOpts := *s.Options
+			renderOpts.Format = format
+			result <- renderLimited(input, &renderOpts)
+		}()
+
+		var res renderResult
+		select {
+		case res = <-result:
+		case <-timeout.C:
+			http.Error(w, "render timed out", http.StatusServiceUnavailable)
+			return
+		}
+		if res.Error != "" {
+			http.Error(w, res.Error, http.StatusUnprocessableEntity)
+			return
+		}
+		output = res.Output
+		s.Cache.put(key, output)
+		w.Header().Set("X-Cache", "miss")
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", s.CacheControl)
+	w.Write(output)
+}
+
+type renderResult struct {
+	Output []byte
+	Error  string
+}
+
+// renderLimited parses and renders the input, but fails if any diagram
+// exceeds the -max-actors, -max-messages or -max-ticks limits.
+func renderLimited(input []byte, opts *sequence.Options) (result renderResult) {
+	var buf bytes.Buffer
+	result.Error = catchFailure(func() {
+		diagrams, err := sequence.ParseLimited(bytes.NewReader(input), serveLimits())
+		failIfErr(err)
+		failIfErr(sequence.WritePages(&buf, renderDiagrams(diagrams, opts)))
+	})
+	result.Output = buf.Bytes()
+	return
+}
+
+// cacheKey identifies a rendered output by the hash of its input and the
+// options that affect the rendering.
+func cacheKey(input []byte, format string) string {
+	sum := sha256.Sum256(input)
+	return strings.Join([]string{hex.EncodeToString(sum[:]), format}, " ")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// HTTP caching and content negotiation
+
+// strongETag returns the ETag for the output identified by the cache key,
+// when rendered with the given options.
+func strongETag(key, optionsFingerprint string) string {
+	sum := sha256.Sum256([]byte(key + "\n" + optionsFingerprint))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches evaluates an If-None-Match header, which contains "*" or a
+// list of ETags. As required for If-None-Match, the comparison is weak, i.e.
+// the "W/" prefix of weak ETags is ignored.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiationOrder is the order in which formats are considered by
+// negotiateFormat when multiple formats match an accepted media range. Formats
+// with the same media type as an earlier one (e.g. "communication") are only
+// chosen if they are the default format.
+var negotiationOrder = []string{"svg", "html", "csv", "events", "dot", "gantt"}
+
+// negotiateFormat chooses the output format for an Accept request header. The
+// media ranges are tried in the order of their quality values; a range that
+// matches the media type of the default format chooses the default format.
+// An empty header accepts everything. Returns false if no format matches
+// (e.g. for "image/png", which this server cannot render).
+func negotiateFormat(accept, defaultFormat string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return defaultFormat, true
+	}
+	type mediaRange struct {
+		Type    string
+		Quality float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mr := mediaRange{Type: strings.ToLower(strings.TrimSpace(params[0])), Quality: 1}
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.TrimSpace(key) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					mr.Quality = q
+				}
+			}
+		}
+		if mr.Type != "" && mr.Quality > 0 {
+			ranges = append(ranges, mr)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].Quality > ranges[j].Quality })
+
+	for _, mr := range ranges {
+		for _, format := range append([]string{defaultFormat}, negotiationOrder...) {
+			if contentType, exists := contentTypes[format]; exists && mediaTypeMatches(mr.Type, contentType) {
+				return format, true
+			}
+		}
+	}
+	return "", false
+}
+
+// mediaTypeMatches returns whether the media range (e.g. "image/*") from an
+// Accept header matches the Content-Type.
+func mediaTypeMatches(mediaRange, contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch {
+	case mediaRange == "*/*":
+		return true
+	case strings.HasSuffix(mediaRange, "/*"):
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+	default:
+		return mediaRange == mediaType
+	}
+}
+
+// mediaTypes returns the media types that negotiateFormat can choose from.
+func mediaTypes() []string {
+	var result []string
+	for _, format := range negotiationOrder {
+		mediaType, _, _ := strings.Cut(contentTypes[format], ";")
+		result = append(result, mediaType)
+	}
+	return result
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// cache
+
+// renderCache is an LRU cache for rendered outputs. Entries are evicted when
+// the total size of all outputs exceeds MaxSize, or when they are older than
+// TTL.
+type renderCache struct {
+	MaxSize int64
+	TTL     time.Duration
+	Size    int64
+	Entries map[string]*list.Element
+	LRU     *list.List //front = most recently used
+	mutex   sync.Mutex
+}
+
+type cacheEntry struct {
+	Key     string
+	Output  []byte
+	Created time.Time
+}
+
+func newRenderCache(maxSize int64, ttl time.Duration) *renderCache {
+	return &renderCache{
+		MaxSize: maxSize,
+		TTL:     ttl,
+		Entries: make(map[string]*list.Element),
+		LRU:     list.New(),
+	}
+}
+
+func (c *renderCache) get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, exists := c.Entries[key]
+	if !exists {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Since(entry.Created) > c.TTL {
+		c.remove(elem)
+		return nil, false
+	}
+	c.LRU.MoveToFront(elem)
+	return entry.Output, true
+}
+
+func (c *renderCache) put(key string, output []byte) {
+	if int64(len(output)) > c.MaxSize {
+		return //would evict everything else and still not fit
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, exists := c.Entries[key]; exists {
+		c.remove(elem)
+	}
+	c.Entries[key] = c.LRU.PushFront(&cacheEntry{Key: key, Output: output, Created: time.Now()})
+	c.Size += int64(len(output))
+	for c.Size > c.MaxSize {
+		c.remove(c.LRU.Back())
+	}
+}
+
+func (c *renderCache) remove(elem *list.Element) {
+	entry := c.LRU.Remove(elem).(*cacheEntry)
+	delete(c.Entries, entry.Key)
+	c.Size -= int64(len(entry.Output))
+}
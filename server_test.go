@@ -0,0 +1,169 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// newTestServer returns a renderServer like serve() does, but without the
+// optional features and without listening.
+func newTestServer(opts *sequence.Options) *renderServer {
+	return &renderServer{
+		Options:            opts,
+		Auth:               &serverAuth{},
+		Cache:              newRenderCache(1<<20, time.Minute),
+		RenderSlots:        make(chan struct{}, 1),
+		OptionsFingerprint: fmt.Sprintf("%+v", *opts),
+		CacheControl:       "public, no-cache",
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	testCases := []struct {
+		Accept        string
+		DefaultFormat string
+		Format        string //"" = not acceptable
+	}{
+		{"", "svg", "svg"},
+		{"", "csv", "csv"},
+		{"*/*", "svg", "svg"},
+		{"*/*", "communication", "communication"},
+		{"image/svg+xml", "csv", "svg"},
+		{"image/svg+xml", "communication", "communication"},
+		{"text/html", "svg", "html"},
+		{"TEXT/HTML", "svg", "html"},
+		{"text/csv;q=0.5, text/html", "svg", "html"},
+		{"text/csv;q=0.9, text/html;q=0.1", "svg", "csv"},
+		{"text/html;q=0, text/csv", "svg", "csv"},
+		{"text/*", "svg", "html"},
+		{"text/*", "events", "events"},
+		{"text/tab-separated-values", "svg", "events"},
+		{"text/vnd.graphviz", "svg", "dot"},
+		{"image/png", "svg", ""},
+		{"image/png, */*;q=0.1", "svg", "svg"},
+		{"application/json", "svg", ""},
+	}
+	for _, tc := range testCases {
+		format, ok := negotiateFormat(tc.Accept, tc.DefaultFormat)
+		if !ok {
+			format = ""
+		}
+		if format != tc.Format {
+			t.Errorf("Accept %q with default %s: expected %q, got %q", tc.Accept, tc.DefaultFormat, tc.Format, format)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"abc"`
+	testCases := []struct {
+		Header  string
+		Matches bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz"`, false},
+		{`abc`, false},
+		{`*`, true},
+	}
+	for _, tc := range testCases {
+		if etagMatches(tc.Header, etag) != tc.Matches {
+			t.Errorf("If-None-Match %q: expected match = %t", tc.Header, tc.Matches)
+		}
+	}
+}
+
+func TestRenderNegotiationAndETags(t *testing.T) {
+	s := newTestServer(sequence.DefaultOptions())
+	renderURL := func(source, format string) string {
+		query := url.Values{"source": {source}}
+		if format != "" {
+			query.Set("format", format)
+		}
+		return "/render?" + query.Encode()
+	}
+	do := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		s.handleRender(w, r)
+		return w
+	}
+
+	const source = "start a\nstop a\n"
+	first := do("GET", renderURL(source, ""), "", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with ETag, got %d with ETag %q", first.Code, etag)
+	}
+
+	testCases := []struct {
+		Name        string
+		Method      string
+		Target      string
+		Body        string
+		Header      http.Header
+		Status      int
+		ContentType string
+		Vary        string
+		SameETag    bool
+	}{
+		{"repeated request", "GET", renderURL(source, ""), "", nil, http.StatusOK, "image/svg+xml", "Accept", true},
+		{"matching ETag", "GET", renderURL(source, ""), "", http.Header{"If-None-Match": {etag}}, http.StatusNotModified, "", "Accept", true},
+		{"weak matching ETag", "GET", renderURL(source, ""), "", http.Header{"If-None-Match": {"W/" + etag}}, http.StatusNotModified, "", "Accept", true},
+		{"other ETag", "GET", renderURL(source, ""), "", http.Header{"If-None-Match": {`"other"`}}, http.StatusOK, "image/svg+xml", "Accept", true},
+		{"POST ignores If-None-Match", "POST", "/render", source, http.Header{"If-None-Match": {etag}}, http.StatusOK, "image/svg+xml", "Accept", true},
+		{"other source", "GET", renderURL("start b\nstop b\n", ""), "", http.Header{"If-None-Match": {etag}}, http.StatusOK, "image/svg+xml", "Accept", false},
+		{"other format", "GET", renderURL(source, "csv"), "", http.Header{"If-None-Match": {etag}}, http.StatusOK, "text/csv; charset=utf-8", "", false},
+		{"negotiated format", "GET", renderURL(source, ""), "", http.Header{"Accept": {"text/html"}}, http.StatusOK, "text/html; charset=utf-8", "Accept", false},
+		{"format parameter wins", "GET", renderURL(source, "svg"), "", http.Header{"Accept": {"text/html"}}, http.StatusOK, "image/svg+xml", "", true},
+		{"not acceptable", "GET", renderURL(source, ""), "", http.Header{"Accept": {"image/png"}}, http.StatusNotAcceptable, "", "Accept", false},
+		{"unknown format", "GET", renderURL(source, "png"), "", nil, http.StatusBadRequest, "", "", false},
+		{"invalid source", "GET", renderURL("bogus\n", ""), "", nil, http.StatusUnprocessableEntity, "", "Accept", false},
+	}
+	for _, tc := range testCases {
+		w := do(tc.Method, tc.Target, tc.Body, tc.Header)
+		if w.Code != tc.Status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.Name, tc.Status, w.Code, w.Body.String())
+			continue
+		}
+		if tc.ContentType != "" && w.Header().Get("Content-Type") != tc.ContentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", tc.Name, tc.ContentType, w.Header().Get("Content-Type"))
+		}
+		if w.Header().Get("Vary") != tc.Vary {
+			t.Errorf("%s: expected Vary %q, got %q", tc.Name, tc.Vary, w.Header().Get("Vary"))
+		}
+		if sameETag := w.Header().Get("ETag") == etag; sameETag != tc.SameETag {
+			t.Errorf("%s: expected same ETag = %t, got ETag %q", tc.Name, tc.SameETag, w.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,292 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// diagramStore persists diagram sources for the "serve" subcommand (see
+// -store-dir). Each diagram is a directory below Dir that contains one file
+// per revision, named like "000001.seq". Revisions are never modified or
+// deleted, so the directory doubles as the revision history.
+type diagramStore struct {
+	Dir   string
+	mutex sync.Mutex //serializes writes, so that revision numbers are assigned without gaps
+}
+
+// storedDiagram is an entry in the listing of a diagramStore.
+type storedDiagram struct {
+	ID        string    `json:"id"`
+	Revisions int       `json:"revisions"`
+	Modified  time.Time `json:"modified"`
+}
+
+// storedRevision is an entry in the history of a stored diagram.
+type storedRevision struct {
+	Revision int       `json:"revision"`
+	Created  time.Time `json:"created"`
+	Size     int64     `json:"size"`
+}
+
+// diagramIDRx matches valid diagram IDs. Since IDs are used as directory
+// names, they must not contain path separators or dots.
+var diagramIDRx = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func revisionFileName(revision int) string {
+	return fmt.Sprintf("%06d%s", revision, BatchExtension)
+}
+
+// history returns all revisions of the diagram, oldest first. It returns an
+// error satisfying os.IsNotExist if the diagram does not exist.
+func (s *diagramStore) history(id string) ([]storedRevision, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Dir, id))
+	if err != nil {
+		return nil, err
+	}
+	var result []storedRevision
+	for _, entry := range entries {
+		revision, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), BatchExtension))
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, storedRevision{Revision: revision, Created: info.ModTime().UTC(), Size: info.Size()})
+	}
+	if len(result) == 0 {
+		return nil, os.ErrNotExist
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Revision < result[j].Revision })
+	return result, nil
+}
+
+// get returns the source of the given revision of the diagram, or of the
+// latest revision if revision is 0.
+func (s *diagramStore) get(id string, revision int) ([]byte, error) {
+	if revision == 0 {
+		revisions, err := s.history(id)
+		if err != nil {
+			return nil, err
+		}
+		revision = revisions[len(revisions)-1].Revision
+	}
+	return os.ReadFile(filepath.Join(s.Dir, id, revisionFileName(revision)))
+}
+
+// put stores the source as a new revision of the diagram, and returns the
+// revision number.
+func (s *diagramStore) put(id string, source []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	revision := 1
+	revisions, err := s.history(id)
+	switch {
+	case err == nil:
+		revision = revisions[len(revisions)-1].Revision + 1
+	case !os.IsNotExist(err):
+		return 0, err
+	}
+
+	dir := filepath.Join(s.Dir, id)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return 0, err
+	}
+	//write into a temporary file first, so that readers never see a partial revision
+	tmpPath := filepath.Join(dir, ".tmp-"+revisionFileName(revision))
+	if err := os.WriteFile(tmpPath, source, 0666); err != nil {
+		return 0, err
+	}
+	return revision, os.Rename(tmpPath, filepath.Join(dir, revisionFileName(revision)))
+}
+
+// list returns all stored diagrams, sorted by ID.
+func (s *diagramStore) list() ([]storedDiagram, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	result := []storedDiagram{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !diagramIDRx.MatchString(entry.Name()) {
+			continue
+		}
+		revisions, err := s.history(entry.Name())
+		if err != nil {
+			continue //e.g. a directory without revisions
+		}
+		latest := revisions[len(revisions)-1]
+		result = append(result, storedDiagram{ID: entry.Name(), Revisions: len(revisions), Modified: latest.Created})
+	}
+	return result, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// HTTP API
+
+// handleStore implements the API of the diagram store below /d/:
+//
+//	GET /d/                      list of all diagrams (JSON)
+//	PUT /d/<id>                  store the request body as a new revision
+//	GET /d/<id>                  source of the latest revision
+//	GET /d/<id>/history          list of all revisions (JSON)
+//	GET /d/<id>.<ext>            latest revision, rendered (e.g. ".svg", ".html")
+//	GET /d/<id>@<revision>       source of the given revision
+//	GET /d/<id>@<revision>.<ext> given revision, rendered
+//
+// The file extensions are those of the output formats (see OutputExtensions).
+func (s *renderServer) handleStore(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/d/")
+	if path == "" {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		diagrams, err := s.Store.list()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, diagrams)
+		return
+	}
+
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(path, ext)
+	showHistory := strings.HasSuffix(name, "/history")
+	name = strings.TrimSuffix(name, "/history")
+	id, revisionStr, hasRevision := strings.Cut(name, "@")
+	if !diagramIDRx.MatchString(id) {
+		http.Error(w, "invalid diagram ID (expected 1-64 letters, digits, dashes or underscores)", http.StatusNotFound)
+		return
+	}
+	var revision int
+	if hasRevision {
+		var err error
+		revision, err = strconv.Atoi(revisionStr)
+		if err != nil || revision < 1 {
+			http.Error(w, "invalid revision: "+revisionStr, http.StatusNotFound)
+			return
+		}
+	}
+
+	if r.Method == "PUT" {
+		if ext != "" || showHistory || hasRevision {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.putDiagram(w, r, id)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if showHistory {
+		revisions, err := s.Store.history(id)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		writeJSON(w, revisions)
+		return
+	}
+	source, err := s.Store.get(id, revision)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if ext == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(source)
+		return
+	}
+	format, exists := formatForExtension(ext)
+	if !exists {
+		http.Error(w, "unknown file extension: "+ext, http.StatusNotFound)
+		return
+	}
+	s.respondRendered(w, r, source, format)
+}
+
+func (s *renderServer) putDiagram(w http.ResponseWriter, r *http.Request, id string) {
+	source, ok := readInput(w, r)
+	if !ok {
+		return
+	}
+	//only valid diagrams are stored, so that every revision can be rendered
+	if _, err := sequence.ParseLimited(strings.NewReader(string(source)), serveLimits()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	revision, err := s.Store.put(id, source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("stored diagram", "id", id, "revision", revision)
+	w.Header().Set("Location", fmt.Sprintf("/d/%s@%d", id, revision))
+	//headers cannot be changed after WriteHeader, so writeJSON cannot set this
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, map[string]interface{}{"id": id, "revision": revision})
+}
+
+// formatForExtension returns the output format for a file extension (the
+// first one in negotiationOrder, if several formats share an extension).
+func formatForExtension(ext string) (string, bool) {
+	for _, format := range negotiationOrder {
+		if sequence.OutputExtensions[format] == ext {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+func storeError(w http.ResponseWriter, err error) {
+	if os.IsNotExist(err) {
+		http.Error(w, "no such diagram or revision", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,137 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+func TestHandleStore(t *testing.T) {
+	s := newTestServer(sequence.DefaultOptions())
+	s.Store = &diagramStore{Dir: t.TempDir()}
+	const (
+		source1 = "start a\nstop a\n"
+		source2 = "start a\nstart b\nstop a\nstop b\n"
+	)
+
+	//requests are executed in order, so later ones see the revisions stored by earlier ones
+	testCases := []struct {
+		Method   string
+		Path     string
+		Body     string
+		Status   int
+		Location string
+		Contains string //substring of the response body
+	}{
+		{"GET", "/d/", "", http.StatusOK, "", "[]"},
+		{"GET", "/d/diagram", "", http.StatusNotFound, "", "no such diagram or revision"},
+		{"PUT", "/d/diagram", source1, http.StatusCreated, "/d/diagram@1", `"revision":1`},
+		{"PUT", "/d/diagram", source2, http.StatusCreated, "/d/diagram@2", `"revision":2`},
+		{"PUT", "/d/diagram", "bogus\n", http.StatusUnprocessableEntity, "", "unknown command: bogus"},
+		{"GET", "/d/diagram", "", http.StatusOK, "", source2},
+		{"GET", "/d/diagram@1", "", http.StatusOK, "", source1},
+		{"GET", "/d/diagram@2", "", http.StatusOK, "", source2},
+		{"GET", "/d/diagram@3", "", http.StatusNotFound, "", "no such diagram or revision"},
+		{"GET", "/d/diagram@0", "", http.StatusNotFound, "", "invalid revision: 0"},
+		{"GET", "/d/diagram@-1", "", http.StatusNotFound, "", "invalid revision: -1"},
+		{"GET", "/d/diagram@latest", "", http.StatusNotFound, "", "invalid revision: latest"},
+		{"GET", "/d/diagram/history", "", http.StatusOK, "", `{"revision":2,`},
+		{"GET", "/d/diagram.svg", "", http.StatusOK, "", ">b<"},
+		{"GET", "/d/diagram@1.csv", "", http.StatusOK, "", ""},
+		{"GET", "/d/diagram.exe", "", http.StatusNotFound, "", "unknown file extension: .exe"},
+		{"GET", "/d/", "", http.StatusOK, "", `"id":"diagram","revisions":2`},
+		{"PUT", "/d/diagram.svg", source1, http.StatusMethodNotAllowed, "", ""},
+		{"PUT", "/d/diagram@1", source1, http.StatusMethodNotAllowed, "", ""},
+		{"PUT", "/d/diagram/history", source1, http.StatusMethodNotAllowed, "", ""},
+		{"DELETE", "/d/diagram", "", http.StatusMethodNotAllowed, "", ""},
+		{"POST", "/d/", "", http.StatusMethodNotAllowed, "", ""},
+		//IDs are directory names, so anything that could escape the store is rejected
+		{"PUT", "/d/Valid_ID-09", source1, http.StatusCreated, "/d/Valid_ID-09@1", ""},
+		{"PUT", "/d/" + strings.Repeat("x", 64), source1, http.StatusCreated, "", ""},
+		{"PUT", "/d/" + strings.Repeat("x", 65), source1, http.StatusNotFound, "", "invalid diagram ID"},
+		{"PUT", "/d/..", source1, http.StatusNotFound, "", "invalid diagram ID"},
+		{"PUT", "/d/a%2F..%2F..%2Fescape", source1, http.StatusNotFound, "", "invalid diagram ID"},
+		{"PUT", "/d/with%20space", source1, http.StatusNotFound, "", "invalid diagram ID"},
+		{"PUT", "/d/.hidden", source1, http.StatusNotFound, "", "invalid diagram ID"},
+		{"GET", "/d/@1", "", http.StatusNotFound, "", "invalid diagram ID"},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest(tc.Method, tc.Path, strings.NewReader(tc.Body))
+		w := httptest.NewRecorder()
+		s.handleStore(w, r)
+		name := tc.Method + " " + tc.Path
+		if w.Code != tc.Status {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tc.Status, w.Code, w.Body.String())
+			continue
+		}
+		if location := w.Header().Get("Location"); tc.Location != "" && location != tc.Location {
+			t.Errorf("%s: expected Location %q, got %q", name, tc.Location, location)
+		}
+		if !strings.Contains(w.Body.String(), tc.Contains) {
+			t.Errorf("%s: expected response to contain %q, got %q", name, tc.Contains, w.Body.String())
+		}
+	}
+
+	//nothing was written outside of the store
+	entries, err := os.ReadDir(filepath.Dir(s.Store.Dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the store directory next to it, got %d entries", len(entries))
+	}
+}
+
+func TestStoreHistoryIgnoresOtherFiles(t *testing.T) {
+	store := &diagramStore{Dir: t.TempDir()}
+	for _, source := range []string{"start a\nstop a\n", "start b\nstop b\n"} {
+		if _, err := store.put("diagram", []byte(source)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//e.g. a temporary file left behind by an interrupted put
+	dir := filepath.Join(store.Dir, "diagram")
+	for _, name := range []string{".tmp-000003.seq", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := store.history("diagram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Revision != 1 || revisions[1].Revision != 2 {
+		t.Errorf("expected revisions 1 and 2, got %+v", revisions)
+	}
+	revision, err := store.put("diagram", []byte("start c\nstop c\n"))
+	if err != nil || revision != 3 {
+		t.Errorf("expected revision 3, got %d (error: %v)", revision, err)
+	}
+	if _, err := store.history("missing"); !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error for a missing diagram, got %v", err)
+	}
+}
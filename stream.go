@@ -0,0 +1,39 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// streamFile implements the "stream" subcommand (see RenderStream).
+func streamFile(path string, opts *sequence.Options, outputPath string) {
+	out := os.Stdout
+	if outputPath != "" {
+		file, err := os.Create(outputPath)
+		failIfErr(err)
+		defer func() { failIfErr(file.Close()) }()
+		out = file
+	}
+	open := func() (io.ReadCloser, error) { return os.Open(path) }
+	failIfErr(sequence.RenderStream(out, opts, open))
+}
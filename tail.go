@@ -0,0 +1,249 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/record"
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+var rulesFlag = flag.String("rules", "", "tail: YAML file with the rules that turn log lines into events")
+
+// tailRule turns the log lines matching its pattern into an event. The
+// templates may refer to named groups of the pattern (e.g. "$sender"), see
+// regexp.Expand.
+type tailRule struct {
+	Pattern  *regexp.Regexp
+	Kind     string //"message" (sender, receiver and label), "send" (sender, label and ID) or "receive" (receiver and ID)
+	Sender   string
+	Receiver string
+	Label    string
+	ID       string //connects a "send" with the matching "receive"
+}
+
+// parseRules reads a rules file like:
+//
+//	rules:
+//	  - pattern: 'sent (?P<label>\w+) to (?P<receiver>\S+) id=(?P<id>\d+)'
+//	    kind: send
+//	    sender: billing
+//	  - pattern: 'received id=(?P<id>\d+)'
+//	    kind: receive
+//	    receiver: shop
+//
+// Only this subset of YAML is understood: a list of mappings with plain or
+// quoted values, optionally below a top-level "rules" key. Unset templates
+// default to the named group of the same name (e.g. "$sender").
+func parseRules(path string) []tailRule {
+	buf, err := os.ReadFile(path)
+	failIfErr(err)
+
+	var items []map[string]string
+	for idx, line := range strings.Split(string(buf), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" || trimmed == "rules:" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			items = append(items, make(map[string]string))
+			trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))
+			if trimmed == "" {
+				continue
+			}
+		}
+		if len(items) == 0 {
+			fail("%s:%d: expected a list of rules", path, idx+1)
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon < 0 {
+			fail("%s:%d: expected \"key: value\"", path, idx+1)
+		}
+		key := strings.TrimSpace(trimmed[:colon])
+		value, err := parseYAMLScalar(strings.TrimSpace(trimmed[colon+1:]))
+		if err != nil {
+			fail("%s:%d: invalid value for %s: %s", path, idx+1, key, err.Error())
+		}
+		items[len(items)-1][key] = value
+	}
+
+	rules := make([]tailRule, 0, len(items))
+	for idx, item := range items {
+		rule := tailRule{
+			Kind:     "message",
+			Sender:   "$sender",
+			Receiver: "$receiver",
+			Label:    "$label",
+			ID:       "$id",
+		}
+		for key, value := range item {
+			switch key {
+			case "pattern":
+				rule.Pattern, err = regexp.Compile(value)
+				if err != nil {
+					fail("%s: rule %d: invalid pattern: %s", path, idx+1, err.Error())
+				}
+			case "kind":
+				switch value {
+				case "message", "send", "receive":
+					rule.Kind = value
+				default:
+					fail("%s: rule %d: unknown kind: %s (expected message, send or receive)", path, idx+1, value)
+				}
+			case "sender":
+				rule.Sender = value
+			case "receiver":
+				rule.Receiver = value
+			case "label":
+				rule.Label = value
+			case "id":
+				rule.ID = value
+			default:
+				fail("%s: rule %d: unknown key: %s", path, idx+1, key)
+			}
+		}
+		if rule.Pattern == nil {
+			fail("%s: rule %d: missing pattern", path, idx+1)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// parseYAMLScalar understands plain, single-quoted and double-quoted scalars.
+func parseYAMLScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `'`):
+		if len(value) < 2 || !strings.HasSuffix(value, `'`) {
+			return "", fmt.Errorf("unterminated quote")
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], `''`, `'`), nil
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	default:
+		//strip trailing comments
+		if idx := strings.Index(value, " #"); idx >= 0 {
+			value = strings.TrimSpace(value[:idx])
+		}
+		return value, nil
+	}
+}
+
+// logTailer turns log lines into events according to the rules.
+type logTailer struct {
+	Rules   []tailRule
+	Pending map[string]*record.Message //sent messages by ID, until received
+}
+
+// apply turns the line into an event if one of the rules matches. Lines that
+// match a rule, but cannot be turned into an event, are reported and skipped.
+func (lt *logTailer) apply(line string, in *ingester) {
+	for _, rule := range lt.Rules {
+		match := rule.Pattern.FindStringSubmatchIndex(line)
+		if match == nil {
+			continue
+		}
+		expand := func(template string) string {
+			return string(rule.Pattern.ExpandString(nil, template, line, match))
+		}
+		sender, receiver, label, id := expand(rule.Sender), expand(rule.Receiver), expand(rule.Label), expand(rule.ID)
+
+		in.mutex.Lock()
+		defer in.mutex.Unlock()
+		switch {
+		case rule.Kind == "message" && sender != "" && receiver != "":
+			in.Recorder.Send(sender, receiver, label)
+		case rule.Kind == "send" && sender != "" && id != "":
+			lt.Pending[id] = in.Recorder.Post(sender, label)
+		case rule.Kind == "receive" && receiver != "" && lt.Pending[id] != nil:
+			lt.Pending[id].Receive(receiver)
+			delete(lt.Pending, id)
+		default:
+			slog.Warn("skipping line with missing value", "mode", "tail", "pattern", rule.Pattern, "event", rule.Kind, "line", line)
+			return
+		}
+		in.Changed = true
+		return
+	}
+}
+
+// tailLog follows the given log file (like "tail -F"), turns its lines into
+// events using the rules (-rules), and renders the diagram into the output
+// file (-o) whenever events are added.
+func tailLog(path string, opts *sequence.Options, outputPath string) {
+	if outputPath == "" {
+		fail("tail: output file must be given with -o")
+	}
+	if *rulesFlag == "" {
+		fail("tail: rules file must be given with -rules")
+	}
+	lt := &logTailer{Rules: parseRules(*rulesFlag), Pending: make(map[string]*record.Message)}
+	in := &ingester{Recorder: record.New()}
+
+	var (
+		file    *os.File
+		offset  int64
+		partial []byte //incomplete last line
+	)
+	for {
+		//reopen the file when it was rotated or truncated
+		info, err := os.Stat(path)
+		failIfErr(err)
+		if file != nil {
+			current, err := file.Stat()
+			failIfErr(err)
+			if !os.SameFile(info, current) || info.Size() < offset {
+				file.Close()
+				file = nil
+			}
+		}
+		if file == nil {
+			file, err = os.Open(path)
+			failIfErr(err)
+			offset, partial = 0, nil
+		}
+
+		if info.Size() > offset {
+			buf, err := io.ReadAll(io.NewSectionReader(file, offset, info.Size()-offset))
+			failIfErr(err)
+			offset += int64(len(buf))
+			buf = append(partial, buf...)
+			end := bytes.LastIndexByte(buf, '\n') + 1
+			scanner := bufio.NewScanner(bytes.NewReader(buf[:end]))
+			scanner.Buffer(nil, len(buf))
+			for scanner.Scan() {
+				lt.apply(scanner.Text(), in)
+			}
+			partial = append([]byte(nil), buf[end:]...)
+			in.flush(opts, outputPath)
+		}
+		time.Sleep(WatchInterval)
+	}
+}
@@ -0,0 +1,186 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// Protocol describes the allowed message orderings between pairs of actors. A
+// protocol file contains one command per line:
+//
+//	pair <actor1> <actor2> <initial-state>
+//	allow <from-state> <to-state> <sender> <kind> <receiver> [<label>]
+//
+// Each "pair" command declares a state machine for all messages exchanged
+// between the two actors (in either direction). Each "allow" command adds a
+// transition to the state machine of the pair (sender, receiver). The kind
+// may be "send", "call", "return" or "*" (any kind). If the label is omitted,
+// messages with any label match the transition. Messages between actors that
+// are not covered by a "pair" command are not checked.
+type Protocol struct {
+	Pairs map[string]*ProtocolPair //key = pairKey(actor1, actor2)
+}
+
+type ProtocolPair struct {
+	Transitions []ProtocolTransition
+	State       string //initial state; during verification, contains the current state
+}
+
+type ProtocolTransition struct {
+	FromState    string
+	ToState      string
+	SenderName   string
+	Kind         string
+	ReceiverName string
+	Label        string //empty = any label
+}
+
+func pairKey(actor1, actor2 string) string {
+	if actor1 > actor2 {
+		actor1, actor2 = actor2, actor1
+	}
+	return actor1 + " " + actor2
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// parsing
+
+func parseProtocol(path string) *Protocol {
+	file, err := os.Open(path)
+	failIfErr(err)
+	defer file.Close()
+
+	protocol := &Protocol{Pairs: make(map[string]*ProtocolPair)}
+	r := bufio.NewReader(file)
+	lineNo := 0
+
+	loop := true
+	for loop {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			loop = false //break after this iteration
+		} else {
+			failIfErr(err)
+		}
+		lineNo++
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "pair":
+			if len(fields) != 4 {
+				fail("%s:%d: wrong number of arguments for 'pair': expected 3, got %d", path, lineNo, len(fields)-1)
+			}
+			key := pairKey(fields[1], fields[2])
+			if _, exists := protocol.Pairs[key]; exists {
+				fail("%s:%d: duplicate declaration for pair %s", path, lineNo, key)
+			}
+			protocol.Pairs[key] = &ProtocolPair{State: fields[3]}
+		case "allow":
+			if len(fields) < 6 {
+				fail("%s:%d: wrong number of arguments for 'allow': expected 5, got %d", path, lineNo, len(fields)-1)
+			}
+			t := ProtocolTransition{
+				FromState:    fields[1],
+				ToState:      fields[2],
+				SenderName:   fields[3],
+				Kind:         fields[4],
+				ReceiverName: fields[5],
+				Label:        strings.Join(fields[6:], " "),
+			}
+			switch t.Kind {
+			case "send", "call", "return", "*":
+			default:
+				fail("%s:%d: unknown message kind: %s", path, lineNo, t.Kind)
+			}
+			pair, exists := protocol.Pairs[pairKey(t.SenderName, t.ReceiverName)]
+			if !exists {
+				fail("%s:%d: no pair declared for actors %s and %s", path, lineNo, t.SenderName, t.ReceiverName)
+			}
+			pair.Transitions = append(pair.Transitions, t)
+		default:
+			fail("%s:%d: unknown command: %s", path, lineNo, fields[0])
+		}
+	}
+
+	return protocol
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// verification
+
+// verify checks that the messages in the diagram conform to the protocol in the
+// given file, and fails with a description of the first violating message.
+func verify(protocolPath string, messages map[string]*sequence.Message) {
+	protocol := parseProtocol(protocolPath)
+
+	//check messages in the order in which they were sent
+	names := make([]string, 0, len(messages))
+	for name := range messages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		m1, m2 := messages[names[i]], messages[names[j]]
+		if m1.SenderTime != m2.SenderTime {
+			return m1.SenderTime < m2.SenderTime
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		msg := messages[name]
+		pair, exists := protocol.Pairs[pairKey(msg.SenderName, msg.ReceiverName)]
+		if !exists {
+			continue
+		}
+		t := pair.findTransition(msg)
+		if t == nil {
+			fail("message %s violates protocol: %s cannot %s %q to %s in state %s",
+				name, msg.SenderName, msg.Kind, msg.Label, msg.ReceiverName, pair.State,
+			)
+		}
+		pair.State = t.ToState
+	}
+}
+
+func (pair *ProtocolPair) findTransition(msg *sequence.Message) *ProtocolTransition {
+	for idx, t := range pair.Transitions {
+		if t.FromState != pair.State || t.SenderName != msg.SenderName || t.ReceiverName != msg.ReceiverName {
+			continue
+		}
+		if t.Kind != "*" && t.Kind != msg.Kind {
+			continue
+		}
+		if t.Label != "" && t.Label != msg.Label {
+			continue
+		}
+		return &pair.Transitions[idx]
+	}
+	return nil
+}
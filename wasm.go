@@ -0,0 +1,81 @@
+//go:build js && wasm
+
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// In the WebAssembly build, main() does not run the command-line interface,
+// but installs a global JavaScript object:
+//
+//	sequenceDiagram.render(source, options) -> string
+//
+// where options is an optional object with the same names as the
+// command-line flags, e.g. {format: "svg", autonumber: true, "max-height": 800}.
+// Multiple pages are separated by sequence.PageDelimiter. Errors are thrown as
+// Error.
+func init() {
+	startJS = func() {
+		//Go functions cannot throw JavaScript exceptions, so jsRender returns
+		//the error, and is wrapped into a JavaScript function that throws it
+		wrap := js.Global().Get("Function").New("impl", `return function(source, options) {
+			const result = impl(source, options);
+			if (result.error !== undefined) {
+				throw new Error(result.error);
+			}
+			return result.output;
+		};`)
+		js.Global().Set("sequenceDiagram", map[string]interface{}{
+			"render": wrap.Invoke(js.FuncOf(jsRender)),
+		})
+		select {} //keep running to serve calls from JavaScript
+	}
+}
+
+func jsRender(this js.Value, args []js.Value) interface{} {
+	var output string
+	msg := catchFailure(func() {
+		if len(args) == 0 || args[0].Type() != js.TypeString {
+			fail("render: expected source string as first argument")
+		}
+		opts := sequence.DefaultOptions()
+		if len(args) > 1 && args[1].Type() == js.TypeObject {
+			values := make(map[string]string)
+			keys := js.Global().Get("Object").Call("keys", args[1])
+			for idx := 0; idx < keys.Length(); idx++ {
+				key := keys.Index(idx).String()
+				//String() converts booleans and numbers like on the command line
+				values[key] = js.Global().Call("String", args[1].Get(key)).String()
+			}
+			failIfErr(opts.Set(values))
+		}
+		var err error
+		output, err = sequence.RenderSource(args[0].String(), opts)
+		failIfErr(err)
+	})
+	if msg != "" {
+		return map[string]interface{}{"error": msg}
+	}
+	return map[string]interface{}{"output": output}
+}
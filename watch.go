@@ -0,0 +1,78 @@
+/*******************************************************************************
+*
+* Copyright 2017 Stefan Majewsky <majewsky@gmx.net>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT ANY
+* WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS FOR
+* A PARTICULAR PURPOSE. See the GNU General Public License for more details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/johan48191/sequence-diagram/sequence"
+)
+
+// WatchInterval is how often the watched file is checked for changes.
+const WatchInterval = 200 * time.Millisecond
+
+// watchFile renders the given file into the output file (-o) whenever it
+// changes. Errors are reported, but do not end the watch.
+func watchFile(path string, opts *sequence.Options, outputPath string) {
+	if outputPath == "" {
+		fail("watch: output file must be given with -o")
+	}
+	//like render(), but readers of the output never see a partial file (as in -follow)
+	renderOpts := *opts
+	renderOpts.WarnOverlaps = true
+
+	ip := &sequence.IncrementalParser{}
+	var lastModified time.Time
+	var lastSize int64
+	var lastStatError string
+	for {
+		//the file may be missing for a moment while an editor replaces it
+		info, err := os.Stat(path)
+		if err != nil {
+			if err.Error() != lastStatError {
+				slog.Error(err.Error(), "mode", "watch", "path", path)
+				lastStatError = err.Error()
+			}
+			time.Sleep(WatchInterval)
+			continue
+		}
+		lastStatError = ""
+		if info.ModTime() != lastModified || info.Size() != lastSize {
+			lastModified, lastSize = info.ModTime(), info.Size()
+			start := time.Now()
+			msg := catchFailure(func() {
+				input, err := os.ReadFile(path)
+				failIfErr(err)
+				diagrams, err := ip.Parse(input)
+				failIfErr(err)
+				writeOutputAtomically(outputPath, renderDiagrams(diagrams, &renderOpts))
+			})
+			if msg == "" {
+				slog.Info("rendered", "mode", "watch", "path", path, "duration", time.Since(start))
+			} else {
+				//the next version must be parsed from scratch, since the state may be inconsistent
+				ip.Reset()
+				slog.Error(msg, "mode", "watch", "path", path)
+			}
+		}
+		time.Sleep(WatchInterval)
+	}
+}